@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"orderbook-pathfinder/internal/format"
+	"os"
+	"strings"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run converts one snapshot, stdin and stdout stand in for a missing -in or -out
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("orderbook-convert", flag.ContinueOnError)
+	in := flags.String("in", "", "input snapshot (default stdin)")
+	out := flags.String("out", "", "output snapshot (default stdout)")
+	from := flags.String("from", "", "input format: "+strings.Join(format.Names(), ", ")+" (default from the -in extension)")
+	to := flags.String("to", "", "output format: "+strings.Join(format.Names(), ", ")+" (default from the -out extension)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	inputFormat, err := pickFormat(*from, *in)
+	if err != nil {
+		return err
+	}
+	outputFormat, err := pickFormat(*to, *out)
+	if err != nil {
+		return err
+	}
+
+	reader := stdin
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return fmt.Errorf("opening input: %w", err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	pairs, err := inputFormat.Read(reader)
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	// NOTE: the output is only created once the input parsed, a bad input leaves it untouched
+	writer := stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("creating output: %w", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	if err := outputFormat.Write(writer, pairs); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return nil
+}
+
+// pickFormat prefers the explicit name and falls back to the file extension
+func pickFormat(name, path string) (format.Format, error) {
+	if name != "" {
+		return format.ByName(name)
+	}
+	return format.ForPath(path), nil
+}
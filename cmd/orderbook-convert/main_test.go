@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const snapshot = `2
+KNC USDT venue=binance fee=10 lot=0.1
+2
+1.2 200
+1.1 150
+1
+0.9 100
+ETH USDT
+1
+360.000000000000000001 1000
+1
+355 800
+`
+
+// sorted is snapshot in the line format once its levels are best-first
+const sorted = `2
+KNC USDT venue=binance fee=10 lot=0.1
+2
+1.1 150
+1.2 200
+1
+0.9 100
+ETH USDT
+1
+360.000000000000000001 1000
+1
+355 800
+`
+
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "book.txt")
+	if err := os.WriteFile(in, []byte(snapshot), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// lines -> json -> csv -> stdout in the line format
+	for _, args := range [][]string{
+		{"-in", in, "-out", filepath.Join(dir, "book.json")},
+		{"-in", filepath.Join(dir, "book.json"), "-out", filepath.Join(dir, "book.csv")},
+	} {
+		if err := run(args, nil, nil); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	var out bytes.Buffer
+	if err := run([]string{"-in", filepath.Join(dir, "book.csv"), "-to", "lines"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != sorted {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), sorted)
+	}
+
+	// stdin to stdout, formats by name
+	out.Reset()
+	if err := run([]string{"-from", "lines", "-to", "lines"}, strings.NewReader(snapshot), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != sorted {
+		t.Errorf("stdin got:\n%s\nwant:\n%s", out.String(), sorted)
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "book.json")
+	for _, test := range []struct {
+		args  []string
+		stdin string
+		want  string // part of the error
+	}{
+		{[]string{"-from", "xml"}, snapshot, `unknown format "xml"`},
+		{[]string{"-to", "yaml"}, snapshot, `unknown format "yaml"`},
+		{[]string{"-in", filepath.Join(dir, "missing.txt")}, "", "opening input"},
+		{[]string{"-out", out}, "1\nKNC USDT\n1\n1.1\n", "reading input"},
+		{[]string{"-from", "json", "-out", out}, "[{", "invalid json orderbook"},
+		{[]string{"-bogus"}, "", "flag provided but not defined"},
+	} {
+		var stdout bytes.Buffer
+		err := run(test.args, strings.NewReader(test.stdin), &stdout)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: %v, want an error containing %q", test.args, err, test.want)
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("a bad input created the output: %v", err)
+	}
+}
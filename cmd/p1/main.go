@@ -3,10 +3,13 @@ package main
 import (
 	"fmt"
 	"orderbook-pathfinder/internal/p1"
+	"os"
 )
 
 func main() {
 	fmt.Println("=== Running P1: Simple Trading Route Finder ===")
-	p1.RunTestCasesFromFile("cmd/p1/testcases/specific_test_1.txt")
+	if !p1.RunTestCasesFromFile("cmd/p1/testcases/specific_test_1.txt") {
+		os.Exit(1)
+	}
 	// p1.RunTestCasesFromFile("cmd/p1/testcases/testcase1.txt")
 }
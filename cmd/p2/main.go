@@ -1,11 +1,27 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"orderbook-pathfinder/internal/p2"
+	"os"
 )
 
 func main() {
+	config := flag.String("config", "", "JSON options file (levelsPerPair, pathDepth, maxCandidates, maxPaths, timeout)")
+	flag.Parse()
+
+	options := p2.DefaultOptions()
+	if *config != "" {
+		var err error
+		if options, err = p2.LoadOptionsFile(*config); err != nil {
+			log.Fatalf("Error loading options: %v", err)
+		}
+	}
+
 	fmt.Println("=== Running P2: Virtual Orderbook Trading ===")
-	p2.RunTestCasesFromFile("cmd/p2/testcases/specific_test_2.txt")
+	if !p2.RunTestCasesWithOptions("cmd/p2/testcases/specific_test_2.txt", options) {
+		os.Exit(1)
+	}
 }
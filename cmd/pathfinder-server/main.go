@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"orderbook-pathfinder/internal/format"
+	"orderbook-pathfinder/internal/p1"
+	"orderbook-pathfinder/internal/p2"
+	"strconv"
+	"sync"
+)
+
+type server struct {
+	p1Matrix p1.PriceMatrix // every pair of the top-of-book graph, priced once at startup
+	p2Graph  p2.Graph
+	pairs    []p2.TradingPair
+	options  p2.Options
+
+	mu    sync.Mutex
+	books map[[2]string]*p2.IncrementalOrderbook // per base/quote, built on its first quote
+}
+
+type levelResponse struct {
+	Route        []string  `json:"route"`
+	Price        float64   `json:"price"`
+	Amount       float64   `json:"amount"`
+	LevelPrices  []float64 `json:"levelPrices"`
+	LevelVenues  []string  `json:"levelVenues,omitempty"`
+	LevelFeesBps []float64 `json:"levelFeesBps,omitempty"`
+}
+
+type bestPriceResponse struct {
+	Route []string `json:"route"`
+	Price float64  `json:"price"`
+	Error string   `json:"error,omitempty"`
+}
+
+type quoteResponse struct {
+	Base        string             `json:"base"`
+	Quote       string             `json:"quote"`
+	Side        string             `json:"side"`
+	Amount      float64            `json:"amount"`
+	Unit        string             `json:"unit"`
+	Price       float64            `json:"price"`
+	BaseAmount  float64            `json:"baseAmount"`
+	QuoteAmount float64            `json:"quoteAmount"`
+	Unfilled    float64            `json:"unfilled"`
+	Limited     bool               `json:"limited"`
+	Levels      []levelResponse    `json:"levels"`
+	Impact      impactResponse     `json:"impact"`
+	Orders      []orderResponse    `json:"orders"`         // per pair, ready to send
+	ChildOrders []orderResponse    `json:"childOrders"`    // per hop of every filled level
+	Dust        map[string]float64 `json:"dust,omitempty"` // rounding leftovers in intermediate tokens
+	P1          bestPriceResponse  `json:"p1"`
+	// Truncation lists the search limits that cut this quote short, empty when none did
+	Truncation string `json:"truncation,omitempty"`
+}
+
+type orderResponse struct {
+	Fill        int     `json:"fill,omitempty"` // 1-based index of the filled level, child orders only
+	Hop         int     `json:"hop,omitempty"`
+	Pair        string  `json:"pair"`
+	Venue       string  `json:"venue,omitempty"`
+	Side        string  `json:"side"`
+	Price       float64 `json:"price"`
+	LimitPrice  float64 `json:"limitPrice,omitempty"`
+	FeeBps      float64 `json:"feeBps,omitempty"`
+	BaseAmount  float64 `json:"baseAmount"`
+	QuoteAmount float64 `json:"quoteAmount"`
+}
+
+// impactResponse is p2.Impact, prices that don't exist (e.g. the mid of a one-sided book) are null
+type impactResponse struct {
+	MidPrice    *float64 `json:"midPrice"`
+	TopPrice    *float64 `json:"topPrice"`
+	Price       *float64 `json:"price"`
+	WorstPrice  *float64 `json:"worstPrice"`
+	ImpactBps   *float64 `json:"impactBps"`
+	SlippageBps *float64 `json:"slippageBps"`
+	Filled      float64  `json:"filled"`
+	Fillable    bool     `json:"fillable"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	orderbookFile := flag.String("orderbook", "cmd/pathfinder-server/orderbook.txt", "orderbook snapshot: .json, .csv or the line format (pair count followed by p2 pair blocks)")
+	configFile := flag.String("config", "", "JSON search limits, e.g. cmd/p2/options.json (default built-in limits)")
+	flag.Parse()
+
+	options := p2.DefaultOptions()
+	if *configFile != "" {
+		var err error
+		options, err = p2.LoadOptionsFile(*configFile)
+		if err != nil {
+			log.Fatalf("Error loading options: %v", err)
+		}
+	}
+
+	pairs, err := format.LoadFile(*orderbookFile)
+	if err != nil {
+		log.Fatalf("Error loading orderbook: %v", err)
+	}
+
+	s := newServer(pairs, options)
+	log.Printf("Loaded %d pairs from %s, listening on %s", len(pairs), *orderbookFile, *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.routes()))
+}
+
+func newServer(pairs []p2.TradingPair, options p2.Options) *server {
+	p2Graph, _ := p2.BuildGraphWithOptions(pairs, options)
+	return &server{
+		p1Matrix: p1.BuildPriceMatrix(p1.BuildGraph(topOfBook(pairs))),
+		p2Graph:  p2Graph,
+		pairs:    pairs,
+		options:  options,
+		books:    make(map[[2]string]*p2.IncrementalOrderbook),
+	}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quote", s.handleQuote)
+	mux.HandleFunc("/matrix", s.handleMatrix)
+	return mux
+}
+
+// orderbook returns the virtual orderbook of base/quote. The incremental book of a pair is
+// built once and only merged afterwards, its truncation already names the books cut at load.
+// NOTE: tokens the snapshot doesn't quote get an empty book and no cache entry, so unknown
+// pairs in requests can't grow the cache
+func (s *server) orderbook(base, quote string) p2.VirtualTradingPair {
+	if _, exists := s.p2Graph[base]; !exists {
+		return p2.VirtualTradingPair{Base: base, Quote: quote}
+	}
+	if _, exists := s.p2Graph[quote]; !exists {
+		return p2.VirtualTradingPair{Base: base, Quote: quote}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{base, quote}
+	book, exists := s.books[key]
+	if !exists {
+		book = p2.NewIncrementalOrderbookWithOptions(s.pairs, base, quote, s.options)
+		s.books[key] = book
+	}
+	return book.Orderbook()
+}
+
+// topOfBook reduces each p2 book to its best ask/bid for the infinite-depth p1 search
+func topOfBook(pairs []p2.TradingPair) []p1.TradingPair {
+	var topPairs []p1.TradingPair
+	for _, pair := range pairs {
+		if len(pair.AskOrders) == 0 || len(pair.BidOrders) == 0 {
+			continue
+		}
+		topPairs = append(topPairs, p1.TradingPair{
+			Base:    pair.Base,
+			Quote:   pair.Quote,
+			Ask:     pair.AskOrders[0].Price,
+			Bid:     pair.BidOrders[0].Price,
+			Venue:   pair.Venue,
+			FeeBps:  pair.FeeBps,
+			AskSize: pair.AskOrders[0].Amount,
+			BidSize: pair.BidOrders[0].Amount,
+		})
+	}
+	return topPairs
+}
+
+func (s *server) handleQuote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"only GET is supported"})
+		return
+	}
+	query := r.URL.Query()
+	base := query.Get("base")
+	quote := query.Get("quote")
+	side := query.Get("side")
+	if base == "" || quote == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"base and quote are required"})
+		return
+	}
+	if side != "ask" && side != "bid" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"side must be ask or bid"})
+		return
+	}
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("invalid amount: %q", query.Get("amount"))})
+		return
+	}
+	unit := query.Get("unit")
+	if unit == "" {
+		unit = "base"
+	}
+	denomination, err := p2.ParseDenomination(unit)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	var limit p2.Limit
+	for _, param := range []struct {
+		name   string
+		target *float64
+	}{{"limit", &limit.Price}, {"maxSlippageBps", &limit.MaxSlippageBps}} {
+		if text := query.Get(param.name); text != "" {
+			value, err := strconv.ParseFloat(text, 64)
+			if err != nil || value <= 0 {
+				writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("invalid %s: %q", param.name, text)})
+				return
+			}
+			*param.target = value
+		}
+	}
+	if err := limit.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	isAsk := side == "ask"
+
+	virtualOrderbook := s.orderbook(base, quote)
+	levels := virtualOrderbook.BidOrders
+	if isAsk {
+		levels = virtualOrderbook.AskOrders
+	}
+	if len(levels) == 0 {
+		writeJSON(w, http.StatusNotFound, errorResponse{fmt.Sprintf("no route for %s/%s", base, quote)})
+		return
+	}
+	fill := p2.FillVirtualOrderbookWithLimit(levels, amount, denomination, isAsk, limit)
+	// NOTE: nothing filled has no price, and NaN can't be encoded once the status is sent
+	if len(fill.Levels) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{fmt.Sprintf("%s/%s unfillable within limit", base, quote)})
+		return
+	}
+	impact := p2.MeasureImpact(virtualOrderbook, fill, amount, denomination, isAsk)
+	bestRoute := fill.Levels
+	orders := p2.BuildOrderPlan(s.p2Graph, bestRoute, isAsk)
+
+	response := quoteResponse{
+		Base:        base,
+		Quote:       quote,
+		Side:        side,
+		Amount:      amount,
+		Unit:        denomination.String(),
+		Price:       fill.Price,
+		BaseAmount:  fill.BaseAmount,
+		QuoteAmount: fill.QuoteAmount,
+		Unfilled:    fill.Unfilled,
+		Limited:     fill.Limited,
+		Levels:      make([]levelResponse, 0, len(bestRoute)),
+		Impact: impactResponse{
+			MidPrice:    finite(impact.MidPrice),
+			TopPrice:    finite(impact.TopPrice),
+			Price:       finite(impact.Price),
+			WorstPrice:  finite(impact.WorstPrice),
+			ImpactBps:   finite(impact.ImpactBps),
+			SlippageBps: finite(impact.SlippageBps),
+			Filled:      impact.Filled,
+			Fillable:    impact.Fillable,
+		},
+		P1: s.bestPrice(base, quote, isAsk),
+	}
+	response.Truncation = virtualOrderbook.Truncation.String()
+	response.Orders = make([]orderResponse, 0, len(orders.Pairs))
+	for _, order := range orders.Pairs {
+		response.Orders = append(response.Orders, orderResponse{
+			Pair:        order.Base + "/" + order.Quote,
+			Venue:       order.Venue,
+			Side:        order.Side,
+			Price:       order.Price,
+			LimitPrice:  order.LimitPrice,
+			FeeBps:      order.FeeBps,
+			BaseAmount:  order.BaseAmount,
+			QuoteAmount: order.QuoteAmount,
+		})
+	}
+	response.ChildOrders = make([]orderResponse, 0, len(orders.Children))
+	for _, child := range orders.Children {
+		response.ChildOrders = append(response.ChildOrders, orderResponse{
+			Fill:        child.Fill + 1,
+			Hop:         child.Hop + 1,
+			Pair:        child.Base + "/" + child.Quote,
+			Venue:       child.Venue,
+			Side:        child.Side,
+			Price:       child.Price,
+			FeeBps:      child.FeeBps,
+			BaseAmount:  child.BaseAmount,
+			QuoteAmount: child.QuoteAmount,
+		})
+	}
+	for _, leftover := range orders.Dust {
+		if response.Dust == nil {
+			response.Dust = make(map[string]float64)
+		}
+		response.Dust[leftover.Token] += leftover.Amount
+	}
+	for _, level := range bestRoute {
+		response.Levels = append(response.Levels, levelResponse{
+			Route:        level.Route,
+			Price:        level.Price,
+			Amount:       level.Amount,
+			LevelPrices:  level.LevelPrices,
+			LevelVenues:  level.LevelVenues,
+			LevelFeesBps: level.LevelFeesBps,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *server) bestPrice(base, quote string, isAsk bool) bestPriceResponse {
+	entry, ok := s.p1Matrix.Quote(base, quote)
+	if !ok {
+		return bestPriceResponse{Route: []string{}}
+	}
+	route := entry.Bid
+	if isAsk {
+		route = entry.Ask
+	}
+	response := bestPriceResponse{Route: route.Route, Price: route.Price}
+	var arbitrageErr *p1.ArbitrageError
+	if errors.As(entry.Err, &arbitrageErr) {
+		// NOTE: the price is unbounded when a cycle is involved, don't report it
+		response.Price = 0
+		response.Error = entry.Err.Error()
+	}
+	return response
+}
+
+// handleMatrix exports the best p1 ask and bid of every pair, ?format=json (default) or csv
+func (s *server) handleMatrix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"only GET is supported"})
+		return
+	}
+	// The export is buffered so a failure can still be reported as a 500 instead of a cut 200
+	var body bytes.Buffer
+	var contentType string
+	var err error
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		contentType, err = "application/json", s.p1Matrix.WriteJSON(&body)
+	case "csv":
+		contentType, err = "text/csv", s.p1Matrix.WriteCSV(&body)
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{"format must be json or csv"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error encoding matrix: %v", err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{"internal error"})
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := body.WriteTo(w); err != nil {
+		log.Printf("Error writing matrix: %v", err)
+	}
+}
+
+// finite drops NaN, which JSON can't encode
+func finite(value float64) *float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return &value
+}
+
+// writeJSON sends the status only once body is encoded, a body that can't be encoded is a 500
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"internal error"}` + "\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(encoded, '\n')); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"orderbook-pathfinder/internal/format"
+	"orderbook-pathfinder/internal/p2"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+	pairs, err := format.LoadFile("orderbook.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(pairs, p2.DefaultOptions())
+	httpServer := httptest.NewServer(s.routes())
+	t.Cleanup(httpServer.Close)
+	return s, httpServer
+}
+
+func get(t *testing.T, httpServer *httptest.Server, path string) (*http.Response, string) {
+	t.Helper()
+	response, err := http.Get(httpServer.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(body)
+}
+
+func TestQuote(t *testing.T) {
+	s, httpServer := newTestServer(t)
+	response, body := get(t, httpServer, "/quote?base=KNC&quote=ETH&amount=300&side=ask")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, body)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type %q", contentType)
+	}
+	var quote quoteResponse
+	if err := json.Unmarshal([]byte(body), &quote); err != nil {
+		t.Fatal(err)
+	}
+	if quote.BaseAmount != 300 || quote.Unfilled != 0 || len(quote.Levels) == 0 || len(quote.Orders) == 0 {
+		t.Fatalf("unexpected quote: %s", body)
+	}
+
+	// The same answer as a full rebuild, from the book cached by the first request
+	virtualOrderbook := p2.BuildVirtualOrderbookWithOptions(s.p2Graph, "KNC", "ETH", s.options)
+	fill := p2.FillVirtualOrderbookWithLimit(virtualOrderbook.AskOrders, 300, p2.BaseAmount, true, p2.Limit{})
+	if quote.Price != fill.Price || quote.QuoteAmount != fill.QuoteAmount {
+		t.Errorf("price %v quote amount %v, a rebuilt book gives %v %v", quote.Price, quote.QuoteAmount, fill.Price, fill.QuoteAmount)
+	}
+	get(t, httpServer, "/quote?base=KNC&quote=ETH&amount=1&side=bid")
+	if len(s.books) != 1 {
+		t.Errorf("%d cached books after two KNC/ETH quotes, want 1", len(s.books))
+	}
+}
+
+func TestQuoteErrors(t *testing.T) {
+	s, httpServer := newTestServer(t)
+	for _, test := range []struct {
+		path   string
+		status int
+		error  string
+	}{
+		{"/quote?quote=ETH&amount=1&side=ask", http.StatusBadRequest, "base and quote are required"},
+		{"/quote?base=KNC&quote=ETH&amount=1&side=buy", http.StatusBadRequest, "side must be ask or bid"},
+		{"/quote?base=KNC&quote=ETH&amount=-1&side=ask", http.StatusBadRequest, `invalid amount: "-1"`},
+		{"/quote?base=KNC&quote=ETH&amount=1&side=ask&unit=lots", http.StatusBadRequest, "lots"},
+		{"/quote?base=KNC&quote=ETH&amount=1&side=ask&limit=x", http.StatusBadRequest, `invalid limit: "x"`},
+		{"/quote?base=KNC&quote=DOGE&amount=1&side=ask", http.StatusNotFound, "no route for KNC/DOGE"},
+		{"/quote?base=KNC&quote=ETH&amount=1&side=ask&limit=0.0001", http.StatusUnprocessableEntity, "KNC/ETH unfillable within limit"},
+		{"/matrix?format=xml", http.StatusBadRequest, "format must be json or csv"},
+	} {
+		response, body := get(t, httpServer, test.path)
+		var decoded errorResponse
+		if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+			t.Errorf("%s: %v in %q", test.path, err, body)
+			continue
+		}
+		if response.StatusCode != test.status || !strings.Contains(decoded.Error, test.error) {
+			t.Errorf("%s: %d %q, want %d %q", test.path, response.StatusCode, decoded.Error, test.status, test.error)
+		}
+	}
+	if _, exists := s.books[[2]string{"KNC", "DOGE"}]; exists {
+		t.Error("an unknown token was cached")
+	}
+
+	response, err := http.Post(httpServer.URL+"/quote", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /quote: %d", response.StatusCode)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	s, httpServer := newTestServer(t)
+	for _, test := range []struct {
+		format      string
+		contentType string
+		write       func(*strings.Builder) error
+	}{
+		{"", "application/json", func(b *strings.Builder) error { return s.p1Matrix.WriteJSON(b) }},
+		{"csv", "text/csv", func(b *strings.Builder) error { return s.p1Matrix.WriteCSV(b) }},
+	} {
+		response, body := get(t, httpServer, "/matrix?format="+test.format)
+		if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != test.contentType {
+			t.Errorf("format %q: %d %q", test.format, response.StatusCode, response.Header.Get("Content-Type"))
+		}
+		var want strings.Builder
+		if err := test.write(&want); err != nil {
+			t.Fatal(err)
+		}
+		if body != want.String() {
+			t.Errorf("format %q: body differs from the export:\n%s", test.format, body)
+		}
+	}
+}
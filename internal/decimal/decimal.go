@@ -0,0 +1,118 @@
+package decimal
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact rational number for settlement-grade prices and amounts.
+// The zero value is 0 and reports IsSet() == false, values are never mutated in place.
+type Decimal struct {
+	rat *big.Rat
+}
+
+func New(x int64) Decimal {
+	return Decimal{new(big.Rat).SetInt64(x)}
+}
+
+// Parse reads decimal text such as "-0.0031" or "3.1e-3", fractions such as "1/3" are rejected
+func Parse(s string) (Decimal, error) {
+	if strings.Contains(s, "/") {
+		return Decimal{}, fmt.Errorf("invalid decimal: %s", s)
+	}
+	rat, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal: %s", s)
+	}
+	return Decimal{rat}, nil
+}
+
+// FromFloat uses the shortest decimal that round-trips to f, so a float parsed
+// from "0.0031" gives back exactly 0.0031 rather than its binary approximation
+func FromFloat(f float64) Decimal {
+	d, err := Parse(strconv.FormatFloat(f, 'g', -1, 64))
+	if err != nil {
+		// NaN and Inf have no exact value
+		return Decimal{}
+	}
+	return d
+}
+
+func (d Decimal) IsSet() bool {
+	return d.rat != nil
+}
+
+func (d Decimal) value() *big.Rat {
+	if d.rat == nil {
+		return new(big.Rat)
+	}
+	return d.rat
+}
+
+func (d Decimal) Add(other Decimal) Decimal {
+	return Decimal{new(big.Rat).Add(d.value(), other.value())}
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	return Decimal{new(big.Rat).Sub(d.value(), other.value())}
+}
+
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{new(big.Rat).Mul(d.value(), other.value())}
+}
+
+// Quo panics on division by zero like big.Rat
+func (d Decimal) Quo(other Decimal) Decimal {
+	return Decimal{new(big.Rat).Quo(d.value(), other.value())}
+}
+
+func (d Decimal) Inv() Decimal {
+	return Decimal{new(big.Rat).Inv(d.value())}
+}
+
+func (d Decimal) Cmp(other Decimal) int {
+	return d.value().Cmp(other.value())
+}
+
+func (d Decimal) Sign() int {
+	return d.value().Sign()
+}
+
+func Min(a, b Decimal) Decimal {
+	if a.Cmp(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+// Truncate drops every decimal after prec, rounding toward zero
+func (d Decimal) Truncate(prec int) Decimal {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(prec)), nil)
+	scaled := new(big.Int).Mul(d.value().Num(), scale)
+	scaled.Quo(scaled, d.value().Denom())
+	return Decimal{new(big.Rat).SetFrac(scaled, scale)}
+}
+
+func (d Decimal) Float64() float64 {
+	f, _ := d.value().Float64()
+	return f
+}
+
+// StringFixed rounds to prec decimals, halves away from zero. A negative value that rounds
+// to zero loses its sign.
+func (d Decimal) StringFixed(prec int) string {
+	s := d.value().FloatString(prec)
+	if strings.HasPrefix(s, "-") && strings.Trim(s, "-0.") == "" {
+		return s[1:]
+	}
+	return s
+}
+
+func (d Decimal) String() string {
+	if d.value().IsInt() {
+		return d.value().RatString()
+	}
+	return d.value().FloatString(18)
+}
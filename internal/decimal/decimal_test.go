@@ -0,0 +1,150 @@
+package decimal
+
+import "testing"
+
+func mustParse(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want string // StringFixed(6), "" when the text is malformed
+	}{
+		{"1.5", "1.500000"},
+		{"-0.125", "-0.125000"},
+		{"+2", "2.000000"},
+		{"3.1e-3", "0.003100"},
+		{"0.000000000000000001", "0.000000"},
+		{"123456789012345678901234567890", "123456789012345678901234567890.000000"},
+		{"", ""},
+		{"abc", ""},
+		{"0.1.2", ""},
+		{"1,5", ""},
+		{" 3", ""},
+		{"1/3", ""},
+		{"NaN", ""},
+		{"Inf", ""},
+	} {
+		d, err := Parse(test.text)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want an error", test.text, d)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.text, err)
+			continue
+		}
+		if got := d.StringFixed(6); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseIsExact(t *testing.T) {
+	// 0.1 + 0.2 is 0.30000000000000004 in floats
+	sum := mustParse(t, "0.1").Add(mustParse(t, "0.2"))
+	if sum.Cmp(mustParse(t, "0.3")) != 0 {
+		t.Fatalf("0.1 + 0.2 = %s", sum)
+	}
+}
+
+func TestQuo(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		prec int
+		want string
+	}{
+		{"2", "3", 5, "0.66667"},
+		{"-2", "3", 5, "-0.66667"},
+		{"1", "8", 3, "0.125"},
+		{"1", "-8", 2, "-0.13"},
+		{"0.0031", "0.0001", 0, "31"},
+		{"1", "3", 36, "0.333333333333333333333333333333333333"},
+	} {
+		if got := mustParse(t, test.a).Quo(mustParse(t, test.b)).StringFixed(test.prec); got != test.want {
+			t.Errorf("%s / %s = %s, want %s", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestQuoByZeroPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("1 / 0 did not panic")
+		}
+	}()
+	New(1).Quo(New(0))
+}
+
+func TestTruncate(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		prec  int
+		want  string
+	}{
+		{"0.666666", 3, "0.666"},
+		{"-0.666666", 3, "-0.666"}, // toward zero, not down
+		{"1.999", 0, "1"},
+		{"-1.999", 0, "-1"},
+		{"0.0004", 3, "0"},
+		{"-0.0004", 3, "0"},
+		{"123.45", 5, "123.45"},
+	} {
+		if got := mustParse(t, test.value).Truncate(test.prec); got.Cmp(mustParse(t, test.want)) != 0 {
+			t.Errorf("%s truncated to %d decimals = %s, want %s", test.value, test.prec, got, test.want)
+		}
+	}
+	third := New(1).Quo(New(3)).Truncate(36)
+	if want := mustParse(t, "0.333333333333333333333333333333333333"); third.Cmp(want) != 0 {
+		t.Errorf("1/3 truncated to 36 decimals = %s", third.StringFixed(40))
+	}
+}
+
+func TestStringFixed(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		prec  int
+		want  string
+	}{
+		{"0.125", 2, "0.13"}, // halves away from zero
+		{"-0.125", 2, "-0.13"},
+		{"0.124", 2, "0.12"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"1", 3, "1.000"},
+		{"-0.0005", 3, "-0.001"},
+		{"-0.0004", 3, "0.000"}, // no negative zero
+		{"-0.4", 0, "0"},
+		{"0.000000000000000001", 18, "0.000000000000000001"},
+	} {
+		if got := mustParse(t, test.value).StringFixed(test.prec); got != test.want {
+			t.Errorf("%s to %d decimals = %s, want %s", test.value, test.prec, got, test.want)
+		}
+	}
+	if got := (Decimal{}).StringFixed(2); got != "0.00" {
+		t.Errorf("zero value = %s, want 0.00", got)
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	for _, test := range []struct {
+		f    float64
+		want string
+	}{
+		{0.0031, "0.0031"},
+		{-1.5, "-1.5"},
+		{1e-20, "0.00000000000000000001"},
+	} {
+		if got := FromFloat(test.f); got.Cmp(mustParse(t, test.want)) != 0 {
+			t.Errorf("FromFloat(%v) = %s, want %s", test.f, got, test.want)
+		}
+	}
+}
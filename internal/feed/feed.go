@@ -0,0 +1,261 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"orderbook-pathfinder/internal/decimal"
+	"orderbook-pathfinder/internal/p2"
+	"sort"
+	"sync"
+)
+
+var ErrOutOfOrder = errors.New("out-of-order delta")
+
+type BookID struct {
+	Base  string
+	Quote string
+	Venue string
+}
+
+func (id BookID) String() string {
+	if id.Venue == "" {
+		return id.Base + "/" + id.Quote
+	}
+	return id.Base + "/" + id.Quote + "@" + id.Venue
+}
+
+// Snapshot is a full L2 book as of Sequence
+type Snapshot struct {
+	Sequence uint64
+	Pair     p2.TradingPair
+}
+
+// LevelUpdate sets the amount resting at a price, an Amount of 0 removes the level
+type LevelUpdate struct {
+	IsAsk  bool
+	Price  float64
+	Amount float64
+	// Optional exact Price/Amount as sent by the venue, like p2.Level's
+	ExactPrice  decimal.Decimal
+	ExactAmount decimal.Decimal
+}
+
+// Delta must carry the sequence right after the last one applied to its book
+type Delta struct {
+	Book     BookID
+	Sequence uint64
+	Updates  []LevelUpdate
+}
+
+// SnapshotSource is asked for a fresh snapshot whenever a book is unknown or has a gap
+type SnapshotSource interface {
+	Snapshot(book BookID) (Snapshot, error)
+}
+
+// GapError is returned when a gap could not be repaired from the snapshot source,
+// the book stays out of Pairs until a newer snapshot arrives
+type GapError struct {
+	Book     BookID
+	Expected uint64
+	Got      uint64
+	Err      error
+}
+
+func (e *GapError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: sequence gap (expected %d, got %d): %v", e.Book, e.Expected, e.Got, e.Err)
+	}
+	return fmt.Sprintf("%s: sequence gap (expected %d, got %d)", e.Book, e.Expected, e.Got)
+}
+
+func (e *GapError) Unwrap() error {
+	return e.Err
+}
+
+type book struct {
+	sequence uint64
+	pair     p2.TradingPair
+	stale    bool
+}
+
+// Store applies sequenced deltas to in-memory books and serves consistent copies to the graph builder
+type Store struct {
+	mu     sync.RWMutex
+	source SnapshotSource
+	books  map[BookID]*book
+}
+
+func NewStore(source SnapshotSource) *Store {
+	return &Store{
+		source: source,
+		books:  make(map[BookID]*book),
+	}
+}
+
+func (s *Store) ApplySnapshot(snapshot Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applySnapshot(snapshot)
+}
+
+func (s *Store) applySnapshot(snapshot Snapshot) {
+	id := BookID{snapshot.Pair.Base, snapshot.Pair.Quote, snapshot.Pair.Venue}
+	if current, ok := s.books[id]; ok && !current.stale && current.sequence > snapshot.Sequence {
+		return
+	}
+	s.books[id] = &book{
+		sequence: snapshot.Sequence,
+		pair:     copyPair(snapshot.Pair),
+	}
+}
+
+// ApplyDelta returns ErrOutOfOrder for deltas at or below the book's sequence (they are dropped)
+// and a *GapError when a gap could not be repaired with a fresh snapshot.
+func (s *Store) ApplyDelta(delta Delta) error {
+	s.mu.Lock()
+	applied, expected, err := s.applyInSequence(delta)
+	s.mu.Unlock()
+	if applied {
+		return err
+	}
+
+	// NOTE: fetched without the lock, a slow snapshot source must not block Pairs
+	snapshot, err := s.source.Snapshot(delta.Book)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another delta or snapshot may have brought the book in sync during the fetch
+	if applied, _, err := s.applyInSequence(delta); applied {
+		return err
+	}
+	if err != nil {
+		s.markStale(delta.Book)
+		return &GapError{Book: delta.Book, Expected: expected, Got: delta.Sequence, Err: err}
+	}
+	s.applySnapshot(snapshot)
+	recovered := s.books[delta.Book]
+	switch {
+	case recovered == nil || recovered.sequence+1 < delta.Sequence:
+		// NOTE: the snapshot is older than the delta, wait for a newer one
+		s.markStale(delta.Book)
+		return &GapError{Book: delta.Book, Expected: expected, Got: delta.Sequence}
+	case recovered.sequence+1 == delta.Sequence:
+		applyUpdates(&recovered.pair, delta.Updates)
+		recovered.sequence = delta.Sequence
+	}
+	// The snapshot already contains the delta otherwise
+	return nil
+}
+
+// applyInSequence applies delta when its book is in sync and the delta is next or already
+// applied. Otherwise it reports false and the sequence the book expected, 0 for a new book.
+func (s *Store) applyInSequence(delta Delta) (bool, uint64, error) {
+	current, ok := s.books[delta.Book]
+	switch {
+	case !ok:
+		return false, 0, nil
+	case current.stale:
+		return false, current.sequence + 1, nil
+	case delta.Sequence <= current.sequence:
+		return true, 0, fmt.Errorf("%s: %w (book at %d, got %d)", delta.Book, ErrOutOfOrder, current.sequence, delta.Sequence)
+	case delta.Sequence == current.sequence+1:
+		applyUpdates(&current.pair, delta.Updates)
+		current.sequence = delta.Sequence
+		return true, 0, nil
+	}
+	return false, current.sequence + 1, nil
+}
+
+// Consume applies deltas until the channel is closed, reporting errors to onError when set
+func (s *Store) Consume(deltas <-chan Delta, onError func(error)) {
+	for delta := range deltas {
+		if err := s.ApplyDelta(delta); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Pairs returns a deep copy of every book that is in sync, ready for p2.BuildGraph
+func (s *Store) Pairs() []p2.TradingPair {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]BookID, 0, len(s.books))
+	for id, b := range s.books {
+		if !b.stale {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+	pairs := make([]p2.TradingPair, 0, len(ids))
+	for _, id := range ids {
+		pairs = append(pairs, copyPair(s.books[id].pair))
+	}
+	return pairs
+}
+
+// Sequence reports the last sequence applied to a book and whether it is in sync
+func (s *Store) Sequence(id BookID) (uint64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.books[id]
+	if !ok {
+		return 0, false
+	}
+	return b.sequence, !b.stale
+}
+
+func (s *Store) markStale(id BookID) {
+	if b, ok := s.books[id]; ok {
+		b.stale = true
+	}
+}
+
+func applyUpdates(pair *p2.TradingPair, updates []LevelUpdate) {
+	for _, update := range updates {
+		if update.IsAsk {
+			pair.AskOrders = setLevel(pair.AskOrders, update, true)
+		} else {
+			pair.BidOrders = setLevel(pair.BidOrders, update, false)
+		}
+	}
+}
+
+// setLevel keeps asks ascending and bids descending, as the graph builder expects.
+// An update to an existing level keeps its exact price unless it brings one, the exact
+// amount is always the update's so a stale one is never settled.
+func setLevel(levels []p2.Level, update LevelUpdate, isAsk bool) []p2.Level {
+	for i, level := range levels {
+		if level.Price == update.Price {
+			if update.Amount <= 0 {
+				return append(levels[:i], levels[i+1:]...)
+			}
+			levels[i].Amount = update.Amount
+			levels[i].ExactAmount = update.ExactAmount
+			if update.ExactPrice.IsSet() {
+				levels[i].ExactPrice = update.ExactPrice
+			}
+			return levels
+		}
+	}
+	if update.Amount <= 0 {
+		return levels
+	}
+	idx := sort.Search(len(levels), func(i int) bool {
+		if isAsk {
+			return levels[i].Price > update.Price
+		}
+		return levels[i].Price < update.Price
+	})
+	levels = append(levels, p2.Level{})
+	copy(levels[idx+1:], levels[idx:])
+	levels[idx] = p2.Level{Price: update.Price, Amount: update.Amount, ExactPrice: update.ExactPrice, ExactAmount: update.ExactAmount}
+	return levels
+}
+
+func copyPair(pair p2.TradingPair) p2.TradingPair {
+	pair.AskOrders = append([]p2.Level(nil), pair.AskOrders...)
+	pair.BidOrders = append([]p2.Level(nil), pair.BidOrders...)
+	return pair
+}
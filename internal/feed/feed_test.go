@@ -0,0 +1,281 @@
+package feed
+
+import (
+	"errors"
+	"orderbook-pathfinder/internal/decimal"
+	"orderbook-pathfinder/internal/p2"
+	"testing"
+	"time"
+)
+
+var ethUSDT = BookID{Base: "ETH", Quote: "USDT"}
+
+func snapshotAt(sequence uint64, bid, ask float64) Snapshot {
+	return Snapshot{
+		Sequence: sequence,
+		Pair: p2.TradingPair{
+			Base:      "ETH",
+			Quote:     "USDT",
+			AskOrders: []p2.Level{{Price: ask, Amount: 1}},
+			BidOrders: []p2.Level{{Price: bid, Amount: 1}},
+		},
+	}
+}
+
+func deltaAt(sequence uint64, updates ...LevelUpdate) Delta {
+	return Delta{Book: ethUSDT, Sequence: sequence, Updates: updates}
+}
+
+// sourceFunc answers snapshot requests with a function, counting them
+type sourceFunc struct {
+	snapshot func(BookID) (Snapshot, error)
+	requests int
+}
+
+func (s *sourceFunc) Snapshot(id BookID) (Snapshot, error) {
+	s.requests++
+	return s.snapshot(id)
+}
+
+func checkBook(t *testing.T, store *Store, sequence uint64, bids, asks []p2.Level) {
+	t.Helper()
+	got, inSync := store.Sequence(ethUSDT)
+	if got != sequence || !inSync {
+		t.Fatalf("book at %d (in sync %v), want %d in sync", got, inSync, sequence)
+	}
+	pairs := store.Pairs()
+	if len(pairs) != 1 {
+		t.Fatalf("%d pairs, want 1", len(pairs))
+	}
+	for _, side := range []struct {
+		name      string
+		got, want []p2.Level
+	}{{"bids", pairs[0].BidOrders, bids}, {"asks", pairs[0].AskOrders, asks}} {
+		if len(side.got) != len(side.want) {
+			t.Fatalf("%s %v, want %v", side.name, side.got, side.want)
+		}
+		for i := range side.got {
+			if side.got[i].Price != side.want[i].Price || side.got[i].Amount != side.want[i].Amount {
+				t.Fatalf("%s %v, want %v", side.name, side.got, side.want)
+			}
+		}
+	}
+}
+
+func TestStoreAppliesDeltasInSequence(t *testing.T) {
+	store := NewStore(&sourceFunc{snapshot: func(BookID) (Snapshot, error) {
+		t.Fatal("no snapshot should be requested")
+		return Snapshot{}, nil
+	}})
+	store.ApplySnapshot(snapshotAt(10, 2000, 2001))
+	if err := store.ApplyDelta(deltaAt(11,
+		LevelUpdate{IsAsk: true, Price: 2000.5, Amount: 2},
+		LevelUpdate{IsAsk: false, Price: 1999, Amount: 3},
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ApplyDelta(deltaAt(12, LevelUpdate{IsAsk: false, Price: 2000, Amount: 0})); err != nil {
+		t.Fatal(err)
+	}
+	checkBook(t, store, 12,
+		[]p2.Level{{Price: 1999, Amount: 3}},
+		[]p2.Level{{Price: 2000.5, Amount: 2}, {Price: 2001, Amount: 1}})
+}
+
+func exact(t *testing.T, text string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestStoreKeepsExactLevels(t *testing.T) {
+	store := NewStore(&sourceFunc{})
+	snapshot := snapshotAt(10, 2000, 2001)
+	snapshot.Pair.AskOrders[0].ExactPrice = exact(t, "2001")
+	snapshot.Pair.AskOrders[0].ExactAmount = exact(t, "1")
+	store.ApplySnapshot(snapshot)
+	if err := store.ApplyDelta(deltaAt(11,
+		LevelUpdate{IsAsk: true, Price: 2000.1, Amount: 0.3, ExactPrice: exact(t, "2000.1"), ExactAmount: exact(t, "0.3")},
+		LevelUpdate{IsAsk: true, Price: 2001, Amount: 0.7, ExactAmount: exact(t, "0.7")},
+		LevelUpdate{IsAsk: false, Price: 2000, Amount: 2},
+	)); err != nil {
+		t.Fatal(err)
+	}
+	pair := store.Pairs()[0]
+	for _, test := range []struct {
+		level         p2.Level
+		price, amount string // "" when the level has no exact value
+	}{
+		{pair.AskOrders[0], "2000.1", "0.3"},
+		{pair.AskOrders[1], "2001", "0.7"}, // the snapshot's exact price, the update's amount
+		{pair.BidOrders[0], "", ""},
+	} {
+		for _, field := range []struct {
+			got  decimal.Decimal
+			want string
+		}{{test.level.ExactPrice, test.price}, {test.level.ExactAmount, test.amount}} {
+			if field.want == "" {
+				if field.got.IsSet() {
+					t.Errorf("level %v: exact value %s, want none", test.level.Price, field.got)
+				}
+			} else if !field.got.IsSet() || field.got.Cmp(exact(t, field.want)) != 0 {
+				t.Errorf("level %v: exact value %s, want %s", test.level.Price, field.got, field.want)
+			}
+		}
+	}
+}
+
+func TestStoreDropsOutOfOrderDeltas(t *testing.T) {
+	store := NewStore(&sourceFunc{})
+	store.ApplySnapshot(snapshotAt(10, 2000, 2001))
+	for _, sequence := range []uint64{10, 9} {
+		if err := store.ApplyDelta(deltaAt(sequence, LevelUpdate{IsAsk: true, Price: 2001, Amount: 5})); !errors.Is(err, ErrOutOfOrder) {
+			t.Fatalf("delta %d: got %v, want ErrOutOfOrder", sequence, err)
+		}
+	}
+	checkBook(t, store, 10, []p2.Level{{Price: 2000, Amount: 1}}, []p2.Level{{Price: 2001, Amount: 1}})
+}
+
+func TestStoreResyncsOnGap(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		snapshot Snapshot
+		want     uint64
+		asks     []p2.Level
+	}{
+		// The snapshot stops right before the delta, the delta is applied on top
+		{"snapshot before delta", snapshotAt(14, 2000, 2002), 15, []p2.Level{{Price: 2002, Amount: 7}}},
+		// The snapshot already contains the delta
+		{"snapshot past delta", snapshotAt(16, 2000, 2003), 16, []p2.Level{{Price: 2003, Amount: 1}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			source := &sourceFunc{snapshot: func(id BookID) (Snapshot, error) {
+				if id != ethUSDT {
+					t.Fatalf("snapshot of %s requested", id)
+				}
+				return test.snapshot, nil
+			}}
+			store := NewStore(source)
+			store.ApplySnapshot(snapshotAt(10, 2000, 2001))
+			if err := store.ApplyDelta(deltaAt(15, LevelUpdate{IsAsk: true, Price: 2002, Amount: 7})); err != nil {
+				t.Fatal(err)
+			}
+			if source.requests != 1 {
+				t.Fatalf("%d snapshot requests, want 1", source.requests)
+			}
+			checkBook(t, store, test.want, []p2.Level{{Price: 2000, Amount: 1}}, test.asks)
+		})
+	}
+}
+
+func TestStoreFetchesUnknownBooks(t *testing.T) {
+	source := &sourceFunc{snapshot: func(BookID) (Snapshot, error) { return snapshotAt(3, 2000, 2001), nil }}
+	store := NewStore(source)
+	if err := store.ApplyDelta(deltaAt(4, LevelUpdate{IsAsk: false, Price: 2000, Amount: 4})); err != nil {
+		t.Fatal(err)
+	}
+	checkBook(t, store, 4, []p2.Level{{Price: 2000, Amount: 4}}, []p2.Level{{Price: 2001, Amount: 1}})
+}
+
+func TestStoreKeepsUnrepairedBooksOut(t *testing.T) {
+	unavailable := errors.New("exchange unavailable")
+	for _, test := range []struct {
+		name    string
+		source  func(BookID) (Snapshot, error)
+		wrapped error
+	}{
+		{"source error", func(BookID) (Snapshot, error) { return Snapshot{}, unavailable }, unavailable},
+		{"stale snapshot", func(BookID) (Snapshot, error) { return snapshotAt(12, 2000, 2001), nil }, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewStore(&sourceFunc{snapshot: test.source})
+			store.ApplySnapshot(snapshotAt(10, 2000, 2001))
+			err := store.ApplyDelta(deltaAt(15))
+			var gapErr *GapError
+			if !errors.As(err, &gapErr) {
+				t.Fatalf("got %v, want a *GapError", err)
+			}
+			if gapErr.Expected != 11 || gapErr.Got != 15 || !errors.Is(gapErr.Err, test.wrapped) {
+				t.Fatalf("gap %+v, want expected 11, got 15 wrapping %v", gapErr, test.wrapped)
+			}
+			if _, inSync := store.Sequence(ethUSDT); inSync {
+				t.Fatal("book still in sync after an unrepaired gap")
+			}
+			if pairs := store.Pairs(); len(pairs) != 0 {
+				t.Fatalf("stale book served: %v", pairs)
+			}
+
+			// A newer snapshot brings the book back
+			store.ApplySnapshot(snapshotAt(20, 2000, 2004))
+			checkBook(t, store, 20, []p2.Level{{Price: 2000, Amount: 1}}, []p2.Level{{Price: 2004, Amount: 1}})
+		})
+	}
+}
+
+func TestStoreServesPairsDuringSnapshotFetch(t *testing.T) {
+	fetching, release := make(chan struct{}), make(chan struct{})
+	store := NewStore(&sourceFunc{snapshot: func(BookID) (Snapshot, error) {
+		close(fetching)
+		<-release
+		return snapshotAt(14, 2000, 2001), nil
+	}})
+	store.ApplySnapshot(snapshotAt(10, 2000, 2001))
+	done := make(chan error)
+	go func() { done <- store.ApplyDelta(deltaAt(15)) }()
+	<-fetching
+
+	served := make(chan []p2.TradingPair)
+	go func() { served <- store.Pairs() }()
+	select {
+	case pairs := <-served:
+		if len(pairs) != 1 {
+			t.Fatalf("%d pairs during the fetch, want the book at 10", len(pairs))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pairs blocked by the snapshot fetch")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	checkBook(t, store, 15, []p2.Level{{Price: 2000, Amount: 1}}, []p2.Level{{Price: 2001, Amount: 1}})
+}
+
+func TestChannelSourceRecoversGaps(t *testing.T) {
+	snapshots := make(chan Snapshot)
+	source := NewChannelSource(snapshots)
+	if _, err := source.Snapshot(ethUSDT); err == nil {
+		t.Fatal("snapshot served before any was received")
+	}
+	snapshots <- snapshotAt(10, 2000, 2001)
+	snapshots <- snapshotAt(8, 1990, 1991)
+	snapshots <- snapshotAt(12, 2000, 2002)
+	close(snapshots)
+	// NOTE: the last send returns once the source took the snapshot, not once it stored it
+	deadline := time.Now().Add(time.Second)
+	for {
+		if snapshot, err := source.Snapshot(ethUSDT); err == nil && snapshot.Sequence == 12 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot 12 never stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	store := NewStore(source)
+	store.ApplySnapshot(snapshotAt(5, 1980, 1981))
+	deltas := make(chan Delta, 2)
+	deltas <- deltaAt(13, LevelUpdate{IsAsk: true, Price: 2001.5, Amount: 2})
+	deltas <- deltaAt(13)
+	close(deltas)
+	var errs []error
+	store.Consume(deltas, func(err error) { errs = append(errs, err) })
+	if len(errs) != 1 || !errors.Is(errs[0], ErrOutOfOrder) {
+		t.Fatalf("errors %v, want the repeated delta out of order", errs)
+	}
+	checkBook(t, store, 13, []p2.Level{{Price: 2000, Amount: 1}}, []p2.Level{{Price: 2001.5, Amount: 2}, {Price: 2002, Amount: 1}})
+}
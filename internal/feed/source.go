@@ -0,0 +1,45 @@
+package feed
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ChannelSource is a stand-in exchange REST endpoint: it answers snapshot requests
+// with the latest snapshot received on its channel for that book.
+type ChannelSource struct {
+	mu     sync.RWMutex
+	latest map[BookID]Snapshot
+}
+
+// NewChannelSource drains snapshots in the background until the channel is closed
+func NewChannelSource(snapshots <-chan Snapshot) *ChannelSource {
+	source := &ChannelSource{latest: make(map[BookID]Snapshot)}
+	go func() {
+		for snapshot := range snapshots {
+			source.Set(snapshot)
+		}
+	}()
+	return source
+}
+
+func (c *ChannelSource) Set(snapshot Snapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := BookID{snapshot.Pair.Base, snapshot.Pair.Quote, snapshot.Pair.Venue}
+	if current, ok := c.latest[id]; ok && current.Sequence > snapshot.Sequence {
+		return
+	}
+	c.latest[id] = snapshot
+}
+
+func (c *ChannelSource) Snapshot(id BookID) (Snapshot, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	snapshot, ok := c.latest[id]
+	if !ok {
+		return Snapshot{}, fmt.Errorf("no snapshot for %s", id)
+	}
+	snapshot.Pair = copyPair(snapshot.Pair)
+	return snapshot, nil
+}
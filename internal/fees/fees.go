@@ -0,0 +1,103 @@
+package fees
+
+import (
+	"fmt"
+	"orderbook-pathfinder/internal/decimal"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Model returns the taker fee in basis points charged for trading base/quote on a venue
+type Model interface {
+	TakerFeeBps(venue, base, quote string) float64
+}
+
+// Flat charges the same fee everywhere
+type Flat float64
+
+func (f Flat) TakerFeeBps(venue, base, quote string) float64 {
+	return float64(f)
+}
+
+// Tier applies when the account's 30-day volume is at least MinVolume30d
+type Tier struct {
+	MinVolume30d float64
+	TakerBps     float64
+}
+
+type VenueFees struct {
+	Tiers     []Tier
+	Volume30d float64
+	PairBps   map[string]float64 // per pair overrides, keyed by PairKey
+}
+
+// Schedule resolves fees per venue: pair override first, then the highest tier reached by Volume30d.
+// Venues that are not configured fall back to Default.
+type Schedule struct {
+	Venues  map[string]VenueFees
+	Default VenueFees
+}
+
+func (s Schedule) TakerFeeBps(venue, base, quote string) float64 {
+	venueFees, ok := s.Venues[venue]
+	if !ok {
+		venueFees = s.Default
+	}
+	return venueFees.TakerFeeBps(base, quote)
+}
+
+func (v VenueFees) TakerFeeBps(base, quote string) float64 {
+	if bps, ok := v.PairBps[PairKey(base, quote)]; ok {
+		return bps
+	}
+	tiers := make([]Tier, len(v.Tiers))
+	copy(tiers, v.Tiers)
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].MinVolume30d < tiers[j].MinVolume30d
+	})
+	bps := 0.0
+	for _, tier := range tiers {
+		if v.Volume30d >= tier.MinVolume30d {
+			bps = tier.TakerBps
+		}
+	}
+	return bps
+}
+
+func PairKey(base, quote string) string {
+	return base + "/" + quote
+}
+
+// AskPrice is what a taker pays per base unit once the fee is added
+func AskPrice(price, bps float64) float64 {
+	return price * (1 + bps/10000)
+}
+
+// BidPrice is what a taker receives per base unit once the fee is deducted
+func BidPrice(price, bps float64) float64 {
+	return price * (1 - bps/10000)
+}
+
+// ExactAskPrice is AskPrice without float rounding
+func ExactAskPrice(price decimal.Decimal, bps float64) decimal.Decimal {
+	return price.Mul(decimal.New(1).Add(decimal.FromFloat(bps).Quo(decimal.New(10000))))
+}
+
+// ExactBidPrice is BidPrice without float rounding
+func ExactBidPrice(price decimal.Decimal, bps float64) decimal.Decimal {
+	return price.Mul(decimal.New(1).Sub(decimal.FromFloat(bps).Quo(decimal.New(10000))))
+}
+
+// ParseBps parses the "fee=<bps>" option used by the test case files
+func ParseBps(option string) (float64, bool, error) {
+	value, ok := strings.CutPrefix(option, "fee=")
+	if !ok {
+		return 0, false, nil
+	}
+	bps, err := strconv.ParseFloat(value, 64)
+	if err != nil || bps < 0 || bps >= 10000 {
+		return 0, true, fmt.Errorf("invalid fee: %s", option)
+	}
+	return bps, true, nil
+}
@@ -0,0 +1,157 @@
+package format
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"orderbook-pathfinder/internal/p2"
+	"strconv"
+	"strings"
+)
+
+// CSV has one level per row under a header naming the columns, venue, fee_bps and the order
+// rules (tick_size, lot_step, min_qty, min_notional) are optional:
+//
+//	pair,side,price,amount,venue,fee_bps,tick_size,lot_step,min_qty,min_notional
+//	KNC/USDT,ask,1.1,150,binance,10,,0.1,,5
+//
+// Rows of the same pair and venue form one book, books keep the order they first appear in
+// and every row of a book repeats its fee and rules.
+type CSV struct{}
+
+var csvColumns = []string{"pair", "side", "price", "amount", "venue", "fee_bps", "tick_size", "lot_step", "min_qty", "min_notional"}
+
+func (CSV) Read(r io.Reader) ([]p2.TradingPair, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("empty csv orderbook")
+		}
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing the %q column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var pairs []p2.TradingPair
+	index := make(map[[2]string]int)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row, _ := reader.FieldPos(0)
+		base, quote, ok := strings.Cut(field(record, "pair"), "/")
+		if !ok {
+			return nil, fmt.Errorf("row %d: pair should be BASE/QUOTE: %q", row, field(record, "pair"))
+		}
+		if err := validatePair(base, quote); err != nil {
+			return nil, fmt.Errorf("row %d: %v", row, err)
+		}
+		level, err := p2.NewLevel(field(record, "price"), field(record, "amount"))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		feeBps := 0.0
+		if text := field(record, "fee_bps"); text != "" {
+			feeBps, err = strconv.ParseFloat(text, 64)
+			if err != nil || feeBps < 0 || feeBps >= 10000 {
+				return nil, fmt.Errorf("row %d: invalid fee: %s", row, text)
+			}
+		}
+
+		var rules p2.PairRules
+		for _, rule := range []struct {
+			column string
+			target *float64
+		}{{"tick_size", &rules.TickSize}, {"lot_step", &rules.LotStep}, {"min_qty", &rules.MinQty}, {"min_notional", &rules.MinNotional}} {
+			if text := field(record, rule.column); text != "" {
+				if *rule.target, err = strconv.ParseFloat(text, 64); err != nil {
+					return nil, fmt.Errorf("row %d: invalid %s: %s", row, rule.column, text)
+				}
+			}
+		}
+		if err := rules.Validate(); err != nil {
+			return nil, fmt.Errorf("row %d: %v", row, err)
+		}
+
+		venue := field(record, "venue")
+		key := [2]string{base + "/" + quote, venue}
+		pairIdx, exists := index[key]
+		if !exists {
+			pairIdx = len(pairs)
+			index[key] = pairIdx
+			pairs = append(pairs, p2.TradingPair{Base: base, Quote: quote, Venue: venue, FeeBps: feeBps, Rules: rules})
+		} else if pairs[pairIdx].FeeBps != feeBps {
+			return nil, fmt.Errorf("row %d: fee %v differs from %v earlier in %s", row, feeBps, pairs[pairIdx].FeeBps, key[0])
+		} else if pairs[pairIdx].Rules != rules {
+			return nil, fmt.Errorf("row %d: rules %+v differ from %+v earlier in %s", row, rules, pairs[pairIdx].Rules, key[0])
+		}
+
+		switch strings.ToLower(field(record, "side")) {
+		case "ask":
+			pairs[pairIdx].AskOrders = append(pairs[pairIdx].AskOrders, level)
+		case "bid":
+			pairs[pairIdx].BidOrders = append(pairs[pairIdx].BidOrders, level)
+		default:
+			return nil, fmt.Errorf("row %d: side should be ask or bid: %q", row, field(record, "side"))
+		}
+	}
+	sortLevels(pairs)
+	return pairs, nil
+}
+
+// NOTE: a book without any level has no row to live in and is dropped
+func (CSV) Write(w io.Writer, pairs []p2.TradingPair) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		book := []string{pair.Venue, ""}
+		if pair.FeeBps > 0 {
+			book[1] = formatFloat(pair.FeeBps)
+		}
+		for _, rule := range ruleFields(pair.Rules) {
+			text := ""
+			if rule.value > 0 {
+				text = formatFloat(rule.value)
+			}
+			book = append(book, text)
+		}
+		for _, side := range []struct {
+			name   string
+			levels []p2.Level
+		}{{"ask", pair.AskOrders}, {"bid", pair.BidOrders}} {
+			for _, level := range side.levels {
+				record := append([]string{pair.Base + "/" + pair.Quote, side.name, priceText(level), amountText(level)}, book...)
+				if err := writer.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
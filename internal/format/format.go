@@ -0,0 +1,159 @@
+package format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"orderbook-pathfinder/internal/decimal"
+	"orderbook-pathfinder/internal/p2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format reads and writes orderbook snapshots as []p2.TradingPair
+type Format interface {
+	Read(r io.Reader) ([]p2.TradingPair, error)
+	Write(w io.Writer, pairs []p2.TradingPair) error
+}
+
+var formats = map[string]Format{
+	"lines": Lines{},
+	"json":  JSON{},
+	"csv":   CSV{},
+}
+
+// Names lists the registered formats
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ByName(name string) (Format, error) {
+	f, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (want one of %s)", name, strings.Join(Names(), ", "))
+	}
+	return f, nil
+}
+
+// ForPath picks the format from the file extension, anything but .json and .csv is the line format
+func ForPath(path string) Format {
+	if f, ok := formats[strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")]; ok {
+		return f
+	}
+	return Lines{}
+}
+
+func LoadFile(path string) ([]p2.TradingPair, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ForPath(path).Read(file)
+}
+
+// Lines is the snapshot format of p2.LoadPairs: the pair count, then per pair
+// "BASE QUOTE [venue=<name>] [fee=<bps>] [tick=] [lot=] [min-qty=] [min-notional=]"
+// followed by the ask and bid levels
+type Lines struct{}
+
+func (Lines) Read(r io.Reader) ([]p2.TradingPair, error) {
+	pairs, err := p2.LoadPairs(r)
+	if err != nil {
+		return nil, err
+	}
+	sortLevels(pairs)
+	return pairs, nil
+}
+
+func (Lines) Write(w io.Writer, pairs []p2.TradingPair) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d\n", len(pairs))
+	for _, pair := range pairs {
+		fmt.Fprintf(bw, "%s %s", pair.Base, pair.Quote)
+		if pair.Venue != "" {
+			fmt.Fprintf(bw, " venue=%s", pair.Venue)
+		}
+		if pair.FeeBps > 0 {
+			fmt.Fprintf(bw, " fee=%s", formatFloat(pair.FeeBps))
+		}
+		for _, rule := range ruleFields(pair.Rules) {
+			if rule.value > 0 {
+				fmt.Fprintf(bw, " %s=%s", rule.option, formatFloat(rule.value))
+			}
+		}
+		fmt.Fprintln(bw)
+		for _, levels := range [][]p2.Level{pair.AskOrders, pair.BidOrders} {
+			fmt.Fprintf(bw, "%d\n", len(levels))
+			for _, level := range levels {
+				fmt.Fprintf(bw, "%s %s\n", priceText(level), amountText(level))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ruleField names one order rule in the line format (option) and in the csv header (column)
+type ruleField struct {
+	option string
+	column string
+	value  float64
+}
+
+func ruleFields(rules p2.PairRules) []ruleField {
+	return []ruleField{
+		{"tick", "tick_size", rules.TickSize},
+		{"lot", "lot_step", rules.LotStep},
+		{"min-qty", "min_qty", rules.MinQty},
+		{"min-notional", "min_notional", rules.MinNotional},
+	}
+}
+
+// sortLevels orders asks ascending and bids descending whatever the order of the file.
+// The graph keeps only the first LevelsPerPair levels and the top of book is level 0, so
+// an unsorted snapshot would otherwise lose its best levels.
+func sortLevels(pairs []p2.TradingPair) {
+	for _, pair := range pairs {
+		sort.SliceStable(pair.AskOrders, func(i, j int) bool { return pair.AskOrders[i].Price < pair.AskOrders[j].Price })
+		sort.SliceStable(pair.BidOrders, func(i, j int) bool { return pair.BidOrders[i].Price > pair.BidOrders[j].Price })
+	}
+}
+
+func validatePair(base, quote string) error {
+	if !p2.ValidSymbol(base) || !p2.ValidSymbol(quote) {
+		return fmt.Errorf("invalid pair symbols %q/%q", base, quote)
+	}
+	return nil
+}
+
+// NOTE: prefer the exact value so a round trip keeps every digit the exchange published
+func priceText(level p2.Level) string {
+	return numberText(level.Price, level.ExactPrice)
+}
+
+func amountText(level p2.Level) string {
+	return numberText(level.Amount, level.ExactAmount)
+}
+
+func numberText(value float64, exact decimal.Decimal) string {
+	if !exact.IsSet() {
+		return formatFloat(value)
+	}
+	text := exact.String()
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	return text
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,178 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"orderbook-pathfinder/internal/p2"
+	"strings"
+	"testing"
+)
+
+func level(t *testing.T, price, amount string) p2.Level {
+	t.Helper()
+	l, err := p2.NewLevel(price, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// testPairs has a venue, a fee, order rules and prices floats can't hold exactly
+func testPairs(t *testing.T) []p2.TradingPair {
+	return []p2.TradingPair{
+		{
+			Base:      "KNC",
+			Quote:     "USDT",
+			AskOrders: []p2.Level{level(t, "1.1", "150"), level(t, "1.2", "200.5")},
+			BidOrders: []p2.Level{level(t, "0.9", "100")},
+			Venue:     "binance",
+			FeeBps:    10,
+			Rules:     p2.PairRules{LotStep: 0.1, MinNotional: 5},
+		},
+		{
+			Base:      "USDC.e",
+			Quote:     "ETH",
+			AskOrders: []p2.Level{level(t, "0.000277777777777777777", "1000")},
+			BidOrders: []p2.Level{level(t, "0.000276", "0.000000000000000001"), level(t, "0.0002", "3")},
+		},
+	}
+}
+
+func checkPairs(t *testing.T, got, want []p2.TradingPair) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d pairs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Base != w.Base || g.Quote != w.Quote || g.Venue != w.Venue || g.FeeBps != w.FeeBps || g.Rules != w.Rules {
+			t.Errorf("pair %d: %s/%s@%s fee %v rules %+v, want %s/%s@%s fee %v rules %+v",
+				i, g.Base, g.Quote, g.Venue, g.FeeBps, g.Rules, w.Base, w.Quote, w.Venue, w.FeeBps, w.Rules)
+		}
+		for _, side := range []struct {
+			name      string
+			got, want []p2.Level
+		}{{"asks", g.AskOrders, w.AskOrders}, {"bids", g.BidOrders, w.BidOrders}} {
+			if len(side.got) != len(side.want) {
+				t.Errorf("pair %d %s: %d levels, want %d", i, side.name, len(side.got), len(side.want))
+				continue
+			}
+			for j := range side.want {
+				if priceText(side.got[j]) != priceText(side.want[j]) || amountText(side.got[j]) != amountText(side.want[j]) {
+					t.Errorf("pair %d %s level %d: %s %s, want %s %s", i, side.name, j,
+						priceText(side.got[j]), amountText(side.got[j]), priceText(side.want[j]), amountText(side.want[j]))
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			f, err := ByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var encoded bytes.Buffer
+			if err := f.Write(&encoded, testPairs(t)); err != nil {
+				t.Fatal(err)
+			}
+			pairs, err := f.Read(bytes.NewReader(encoded.Bytes()))
+			if err != nil {
+				t.Fatalf("%v in:\n%s", err, encoded.String())
+			}
+			checkPairs(t, pairs, testPairs(t))
+
+			// A second trip writes the same bytes
+			var again bytes.Buffer
+			if err := f.Write(&again, pairs); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != encoded.String() {
+				t.Errorf("second write differs:\n%s\nfirst:\n%s", again.String(), encoded.String())
+			}
+		})
+	}
+}
+
+func TestReadSortsLevels(t *testing.T) {
+	want := []p2.TradingPair{{
+		Base:      "KNC",
+		Quote:     "USDT",
+		AskOrders: []p2.Level{level(t, "1.1", "1"), level(t, "1.2", "2"), level(t, "1.3", "3")},
+		BidOrders: []p2.Level{level(t, "0.9", "4"), level(t, "0.8", "5")},
+	}}
+	for _, test := range []struct {
+		name string
+		text string
+	}{
+		{"lines", "1\nKNC USDT\n3\n1.3 3\n1.1 1\n1.2 2\n2\n0.8 5\n0.9 4\n"},
+		{"csv", "pair,side,price,amount\nKNC/USDT,ask,1.2,2\nKNC/USDT,bid,0.8,5\nKNC/USDT,ask,1.3,3\nKNC/USDT,ask,1.1,1\nKNC/USDT,bid,0.9,4\n"},
+		{"json", `[{"base": "KNC", "quote": "USDT", "asks": [["1.3", "3"], [1.1, 1], ["1.2", "2"]], "bids": [["0.8", "5"], ["0.9", "4"]]}]`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := ByName(test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pairs, err := f.Read(strings.NewReader(test.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPairs(t, pairs, want)
+		})
+	}
+}
+
+func TestReadRejectsMalformedInput(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		text   string
+		want   string // part of the error
+	}{
+		{"lines", "", "empty orderbook snapshot"},
+		{"lines", "x\n", "invalid pair count"},
+		{"lines", "1\nKNC USDT\n1\n1.1\n0\n", "expected PRICE AMOUNT"},
+		{"lines", "1\nKNC USDT\n2\n1.1 1\n", "missing ask order 2"},
+		{"csv", "", "empty csv orderbook"},
+		{"csv", "pair,side,price\nKNC/USDT,ask,1.1\n", `missing the "amount" column`},
+		{"csv", "pair,side,price,amount\nKNCUSDT,ask,1.1,1\n", "pair should be BASE/QUOTE"},
+		{"csv", "pair,side,price,amount\nKNC/USDT,buy,1.1,1\n", "side should be ask or bid"},
+		{"csv", "pair,side,price,amount\nKNC/USDT,ask,abc,1\n", "malformed level"},
+		{"csv", "pair,side,price,amount\nKNC/USDT,ask,-1,1\n", "-1"},
+		{"csv", "pair,side,price,amount,venue,fee_bps\nKNC/USDT,ask,1.1,1,,10\nKNC/USDT,bid,0.9,1,,20\n", "fee 20 differs from 10"},
+		{"csv", "pair,side,price,amount,venue,fee_bps\nKNC/USDT,ask,1.1,1,,10000\n", "invalid fee"},
+		{"json", "", "invalid json orderbook"},
+		{"json", `{"base": "KNC"}`, "invalid json orderbook"},
+		{"json", `[{"base": "KNC", "quote": "", "asks": [], "bids": []}]`, "invalid pair symbols"},
+		{"json", `[{"base": "KNC", "quote": "USDT", "asks": [["1.1"]], "bids": []}]`, "expected [price, amount]"},
+		{"json", `[{"base": "KNC", "quote": "USDT", "asks": [], "bids": [["0.9", "-1"]]}]`, "bids: level 1"},
+		{"json", `[{"base": "KNC", "quote": "USDT", "feeBps": -1, "asks": [], "bids": []}]`, "invalid fee"},
+	} {
+		f, err := ByName(test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs, err := f.Read(strings.NewReader(test.text))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s %q: %v (%d pairs), want an error containing %q", test.format, test.text, err, len(pairs), test.want)
+		}
+	}
+
+	_, err := JSON{}.Read(strings.NewReader(`[{"base": "KNC", "quote": "USDT", "asks": [["1.1", "-1"]], "bids": []}]`))
+	if !errors.Is(err, p2.ErrMalformedLevel) {
+		t.Errorf("got %v, want ErrMalformedLevel", err)
+	}
+}
+
+func TestByName(t *testing.T) {
+	if _, err := ByName("xml"); err == nil || !strings.Contains(err.Error(), "csv, json, lines") {
+		t.Errorf("ByName(xml): %v", err)
+	}
+	for path, want := range map[string]Format{"book.JSON": JSON{}, "book.csv": CSV{}, "book.txt": Lines{}, "book": Lines{}} {
+		if got := ForPath(path); got != want {
+			t.Errorf("ForPath(%s) = %T, want %T", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"orderbook-pathfinder/internal/p2"
+)
+
+// JSON is an array of books with levels as exchanges publish them, prices and
+// amounts may be strings or numbers and extra fields per level are ignored:
+//
+//	[{"base": "KNC", "quote": "USDT", "venue": "binance", "feeBps": 10,
+//	  "asks": [["1.1", "150"]], "bids": [["0.9", "100"]]}]
+//
+// Optional "tickSize", "lotStep", "minQty" and "minNotional" give the pair's order rules.
+type JSON struct{}
+
+type jsonPair struct {
+	Base   string          `json:"base"`
+	Quote  string          `json:"quote"`
+	Venue  string          `json:"venue,omitempty"`
+	FeeBps float64         `json:"feeBps,omitempty"`
+	Asks   [][]json.Number `json:"asks"`
+	Bids   [][]json.Number `json:"bids"`
+
+	TickSize    float64 `json:"tickSize,omitempty"`
+	LotStep     float64 `json:"lotStep,omitempty"`
+	MinQty      float64 `json:"minQty,omitempty"`
+	MinNotional float64 `json:"minNotional,omitempty"`
+}
+
+func (JSON) Read(r io.Reader) ([]p2.TradingPair, error) {
+	var books []jsonPair
+	if err := json.NewDecoder(r).Decode(&books); err != nil {
+		return nil, fmt.Errorf("invalid json orderbook: %v", err)
+	}
+	pairs := make([]p2.TradingPair, 0, len(books))
+	for i, book := range books {
+		if err := validatePair(book.Base, book.Quote); err != nil {
+			return nil, fmt.Errorf("book %d: %v", i+1, err)
+		}
+		if book.FeeBps < 0 || book.FeeBps >= 10000 {
+			return nil, fmt.Errorf("book %d: invalid fee: %v", i+1, book.FeeBps)
+		}
+		rules := p2.PairRules{TickSize: book.TickSize, LotStep: book.LotStep, MinQty: book.MinQty, MinNotional: book.MinNotional}
+		if err := rules.Validate(); err != nil {
+			return nil, fmt.Errorf("book %d: %v", i+1, err)
+		}
+		askOrders, err := jsonLevels(book.Asks)
+		if err != nil {
+			return nil, fmt.Errorf("book %d (%s/%s) asks: %w", i+1, book.Base, book.Quote, err)
+		}
+		bidOrders, err := jsonLevels(book.Bids)
+		if err != nil {
+			return nil, fmt.Errorf("book %d (%s/%s) bids: %w", i+1, book.Base, book.Quote, err)
+		}
+		pairs = append(pairs, p2.TradingPair{
+			Base:      book.Base,
+			Quote:     book.Quote,
+			AskOrders: askOrders,
+			BidOrders: bidOrders,
+			Venue:     book.Venue,
+			FeeBps:    book.FeeBps,
+			Rules:     rules,
+		})
+	}
+	sortLevels(pairs)
+	return pairs, nil
+}
+
+func jsonLevels(raw [][]json.Number) ([]p2.Level, error) {
+	levels := make([]p2.Level, 0, len(raw))
+	for j, entry := range raw {
+		if len(entry) < 2 {
+			return nil, fmt.Errorf("level %d: %w: expected [price, amount]", j+1, p2.ErrMalformedLevel)
+		}
+		level, err := p2.NewLevel(entry[0].String(), entry[1].String())
+		if err != nil {
+			return nil, fmt.Errorf("level %d: %w", j+1, err)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+func (JSON) Write(w io.Writer, pairs []p2.TradingPair) error {
+	books := make([]jsonPair, 0, len(pairs))
+	for _, pair := range pairs {
+		book := jsonPair{
+			Base:        pair.Base,
+			Quote:       pair.Quote,
+			Venue:       pair.Venue,
+			FeeBps:      pair.FeeBps,
+			TickSize:    pair.Rules.TickSize,
+			LotStep:     pair.Rules.LotStep,
+			MinQty:      pair.Rules.MinQty,
+			MinNotional: pair.Rules.MinNotional,
+			Asks:        make([][]json.Number, 0, len(pair.AskOrders)),
+			Bids:        make([][]json.Number, 0, len(pair.BidOrders)),
+		}
+		for _, level := range pair.AskOrders {
+			book.Asks = append(book.Asks, []json.Number{json.Number(priceText(level)), json.Number(amountText(level))})
+		}
+		for _, level := range pair.BidOrders {
+			book.Bids = append(book.Bids, []json.Number{json.Number(priceText(level)), json.Number(amountText(level))})
+		}
+		books = append(books, book)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(books)
+}
@@ -0,0 +1,184 @@
+package p1
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// NOTE: log-space noise on cycles whose product is exactly 1 (e.g. ask == bid) must not count as arbitrage
+const arbitrageEpsilon = 1e-12
+
+var ErrArbitrageCycle = errors.New("arbitrage cycle detected")
+
+// ArbitrageCycle is a closed loop of conversions whose gross multiplier is above 1.
+type ArbitrageCycle struct {
+	Tokens     []string      // e.g. ["USDT", "ETH", "KNC", "USDT"]
+	Multiplier float64       // product of the bids along the cycle
+	Edges      []TradingPair // graph edge taken at each hop, oriented along the cycle
+}
+
+// ArbitrageError is returned by FindOptimalTradingRoutes when the requested route
+// passes through a token that sits on an arbitrage cycle, so its price is unbounded.
+type ArbitrageError struct {
+	Base   string
+	Quote  string
+	Cycles []ArbitrageCycle
+}
+
+func (e *ArbitrageError) Error() string {
+	if len(e.Cycles) == 0 {
+		return fmt.Sprintf("%s->%s: %v", e.Base, e.Quote, ErrArbitrageCycle)
+	}
+	return fmt.Sprintf("%s->%s: %v: %d cycle(s), e.g. %s (x%.8f)",
+		e.Base, e.Quote, ErrArbitrageCycle, len(e.Cycles), formatRoute(e.Cycles[0].Tokens), e.Cycles[0].Multiplier)
+}
+
+func (e *ArbitrageError) Unwrap() error {
+	return ErrArbitrageCycle
+}
+
+func DetectArbitrageCycles(pairs []TradingPair) []ArbitrageCycle {
+	return detectArbitrageCycles(buildGraph(pairs))
+}
+
+// bidEdge is one directed edge of the cycle search, from and to index the sorted tokens
+type bidEdge struct {
+	from      int
+	to        int
+	logWeight float64
+}
+
+// bidEdges lists the best bid edge of every token pair once, in sorted token order, so the
+// relax passes neither sort nor pick venues again
+func bidEdges(graph Graph, tokens []string) []bidEdge {
+	index := make(map[string]int, len(tokens))
+	for i, token := range tokens {
+		index[token] = i
+	}
+	var edges []bidEdge
+	for i, u := range tokens {
+		for _, v := range sortedTokens(graph[u]) {
+			edges = append(edges, bidEdge{from: i, to: index[v], logWeight: -math.Log(bestEdge(graph[u][v], false).Bid)})
+		}
+	}
+	return edges
+}
+
+func detectArbitrageCycles(graph Graph) []ArbitrageCycle {
+	tokens := sortedTokens(graph)
+	edges := bidEdges(graph, tokens)
+	// NOTE: every token starts at 0 (virtual source) so cycles in every component are reachable
+	distances := make([]float64, len(tokens))
+	tracer := make([]int, len(tokens))
+	for i := range tracer {
+		tracer[i] = -1
+	}
+	relax := func() []int {
+		var relaxed []int
+		for _, edge := range edges {
+			if distance := distances[edge.from] + edge.logWeight; distance < distances[edge.to]-arbitrageEpsilon {
+				distances[edge.to] = distance
+				tracer[edge.to] = edge.from
+				relaxed = append(relaxed, edge.to)
+			}
+		}
+		return relaxed
+	}
+	// A pass that relaxes nothing leaves nothing for the next one: without a cycle the loop
+	// stops after the longest shortest path, not after V-1 passes
+	for i := 0; i < len(tokens)-1; i++ {
+		if len(relax()) == 0 {
+			return nil
+		}
+	}
+
+	// Any token still relaxable after V-1 rounds has a cycle somewhere on its predecessor chain
+	var cycles []ArbitrageCycle
+	seen := make(map[string]bool)
+	for _, token := range relax() {
+		cycle, ok := extractCycle(graph, tokens, tracer, token)
+		if !ok {
+			continue
+		}
+		key := formatRoute(cycle.Tokens)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		cycles = append(cycles, cycle)
+	}
+	return cycles
+}
+
+func extractCycle(graph Graph, tokens []string, tracer []int, token int) (ArbitrageCycle, bool) {
+	// Walk back V times to make sure we are inside the cycle, not on its tail
+	current := token
+	for i := 0; i < len(tokens); i++ {
+		if tracer[current] < 0 {
+			return ArbitrageCycle{}, false
+		}
+		current = tracer[current]
+	}
+
+	reversed := []string{tokens[current]}
+	for prev := tracer[current]; prev != current; prev = tracer[prev] {
+		reversed = append(reversed, tokens[prev])
+		if len(reversed) > len(tokens) {
+			return ArbitrageCycle{}, false
+		}
+	}
+
+	// Rotate so the cycle starts at its smallest token, which makes it comparable across walks
+	start := 0
+	for i, t := range reversed {
+		if t < reversed[start] {
+			start = i
+		}
+	}
+	path := make([]string, 0, len(reversed)+1)
+	for i := 0; i < len(reversed); i++ {
+		path = append(path, reversed[(start-i+len(reversed))%len(reversed)])
+	}
+	path = append(path, path[0])
+
+	cycle := ArbitrageCycle{Tokens: path, Multiplier: 1.0}
+	for i := 0; i < len(path)-1; i++ {
+		edge := bestEdge(graph[path[i]][path[i+1]], false)
+		cycle.Edges = append(cycle.Edges, edge)
+		cycle.Multiplier *= edge.Bid
+	}
+	if cycle.Multiplier <= 1.0 {
+		return ArbitrageCycle{}, false
+	}
+	return cycle, true
+}
+
+func contaminatingCycles(cycles []ArbitrageCycle, routes ...TradingRoute) []ArbitrageCycle {
+	onRoute := make(map[string]bool)
+	for _, route := range routes {
+		for _, token := range route.Route {
+			onRoute[token] = true
+		}
+	}
+	var contaminating []ArbitrageCycle
+	for _, cycle := range cycles {
+		for _, token := range cycle.Tokens {
+			if onRoute[token] {
+				contaminating = append(contaminating, cycle)
+				break
+			}
+		}
+	}
+	return contaminating
+}
+
+func sortedTokens[V any](m map[string]V) []string {
+	tokens := make([]string, 0, len(m))
+	for token := range m {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
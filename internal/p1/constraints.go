@@ -0,0 +1,507 @@
+package p1
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// TokenPair is excluded in both directions and on every venue
+type TokenPair struct {
+	Base  string
+	Quote string
+}
+
+// RouteConstraints restrict the routes the search may return, the zero value allows any route
+type RouteConstraints struct {
+	MaxHops        int // 0 means no limit
+	RequiredTokens []string
+	ExcludedTokens []string
+	ExcludedPairs  []TokenPair
+	// MinVolume in base: the route's Capacity, its smallest top-of-book size converted
+	// through the hop prices, must cover it. 0 ignores the sizes.
+	MinVolume float64
+}
+
+// maxDepthAwareRoutes bounds how many routes, best first, a MinVolume search inspects
+const maxDepthAwareRoutes = 256
+
+// ErrSearchTruncated is returned when no route fit before a search limit, one may fit beyond it
+var ErrSearchTruncated = errors.New("route search truncated")
+
+// maxRequiredTokens bounds the (token, visited required tokens) states of the layered search
+// to 2^maxRequiredTokens per token, also when MaxHops doesn't limit the route length
+const maxRequiredTokens = 8
+
+// maxConstrainedLabels bounds how many walks a constrained search extends
+const maxConstrainedLabels = 1 << 14
+
+func (c RouteConstraints) IsZero() bool {
+	return c.MaxHops == 0 && len(c.RequiredTokens) == 0 && len(c.ExcludedTokens) == 0 && len(c.ExcludedPairs) == 0 && c.MinVolume == 0
+}
+
+func (c RouteConstraints) validate(baseCurrency, quoteCurrency string) error {
+	if c.MaxHops < 0 {
+		return fmt.Errorf("max hops must not be negative, got %d", c.MaxHops)
+	}
+	if c.MinVolume < 0 || math.IsNaN(c.MinVolume) || math.IsInf(c.MinVolume, 0) {
+		return fmt.Errorf("invalid min volume: %v", c.MinVolume)
+	}
+	for _, token := range c.ExcludedTokens {
+		if token == baseCurrency || token == quoteCurrency {
+			return fmt.Errorf("cannot exclude %s, it is the base or quote", token)
+		}
+	}
+	for _, token := range c.RequiredTokens {
+		if token == baseCurrency || token == quoteCurrency {
+			return fmt.Errorf("required token %s must be an intermediate token", token)
+		}
+		for _, excluded := range c.ExcludedTokens {
+			if token == excluded {
+				return fmt.Errorf("token %s is both required and excluded", token)
+			}
+		}
+	}
+	if len(c.RequiredTokens) > maxRequiredTokens {
+		return fmt.Errorf("at most %d required tokens, got %d", maxRequiredTokens, len(c.RequiredTokens))
+	}
+	if c.MaxHops > 0 && len(c.RequiredTokens) >= c.MaxHops {
+		return fmt.Errorf("%d required tokens need more than %d hops", len(c.RequiredTokens), c.MaxHops)
+	}
+	return nil
+}
+
+// FindConstrainedTradingRoutes is FindOptimalTradingRoutes restricted to routes satisfying constraints
+func FindConstrainedTradingRoutes(baseCurrency, quoteCurrency string, pairs []TradingPair, constraints RouteConstraints) (TradingRoute, TradingRoute, error) {
+	return FindConstrainedTradingRoutesInGraph(buildGraph(pairs), baseCurrency, quoteCurrency, constraints)
+}
+
+// FindConstrainedTradingRoutesInGraph returns ErrNoRoute when no route satisfies the constraints,
+// ErrSearchTruncated when a search limit was hit before a route was found. Excluded tokens and
+// pairs are dropped from the graph first, so an arbitrage cycle among them is no error.
+func FindConstrainedTradingRoutesInGraph(graph Graph, baseCurrency, quoteCurrency string, constraints RouteConstraints) (TradingRoute, TradingRoute, error) {
+	if err := constraints.validate(baseCurrency, quoteCurrency); err != nil {
+		return TradingRoute{}, TradingRoute{}, err
+	}
+	graph = constraints.removed().apply(graph)
+	search := constrainedRoute
+	if constraints.MinVolume > 0 {
+		search = depthAwareRoute
+	}
+	bestAskRoute, askErr := search(graph, baseCurrency, quoteCurrency, true, constraints)
+	bestBidRoute, bidErr := search(graph, baseCurrency, quoteCurrency, false, constraints)
+	if errors.Is(askErr, ErrArbitrageCycle) || errors.Is(bidErr, ErrArbitrageCycle) {
+		// NOTE: the cycle that made the search loop may not touch the routes found before it
+		return bestAskRoute, bestBidRoute, &ArbitrageError{
+			Base:   baseCurrency,
+			Quote:  quoteCurrency,
+			Cycles: detectArbitrageCycles(graph),
+		}
+	}
+	if askErr != nil {
+		return bestAskRoute, bestBidRoute, askErr
+	}
+	if bidErr != nil {
+		return bestAskRoute, bestBidRoute, bidErr
+	}
+	if len(bestAskRoute.Route) == 0 || len(bestBidRoute.Route) == 0 {
+		return bestAskRoute, bestBidRoute, fmt.Errorf("%w: %s -> %s under the route constraints", ErrNoRoute, baseCurrency, quoteCurrency)
+	}
+	if cycles := contaminatingCycles(detectArbitrageCycles(graph), bestAskRoute, bestBidRoute); len(cycles) > 0 {
+		return bestAskRoute, bestBidRoute, &ArbitrageError{
+			Base:   baseCurrency,
+			Quote:  quoteCurrency,
+			Cycles: cycles,
+		}
+	}
+	return bestAskRoute, bestBidRoute, nil
+}
+
+// searchState is a token reached with a set of required tokens already visited
+type searchState struct {
+	token string
+	mask  int
+}
+
+type searchLabel struct {
+	distance float64
+	previous searchState
+	edge     TradingPair
+}
+
+// constrainedRoute is the best route satisfying constraints. The layered search is fast but
+// may miss it (see constrainedBellmanFord), so constrainedPaths looks for a cheaper one and
+// proves the layered route when there is none. Past maxConstrainedLabels the layered route is
+// returned unproven, or ErrSearchTruncated when there is none. The error is ErrArbitrageCycle
+// when a cycle leaves the routes unranked.
+func constrainedRoute(graph Graph, start, end string, isAsk bool, constraints RouteConstraints) (TradingRoute, error) {
+	best, ok := constrainedBellmanFord(graph, start, end, isAsk, constraints)
+	limit := math.Inf(1)
+	if ok {
+		limit = best.cost
+	}
+	err := constrainedPaths(graph, start, end, isAsk, constraints, limit, func(path candidatePath) bool {
+		best, ok = path, true
+		return false
+	})
+	if err != nil && !(ok && errors.Is(err, ErrSearchTruncated)) {
+		return TradingRoute{Route: []string{}, Price: 0}, err
+	}
+	if !ok {
+		return TradingRoute{Route: []string{}, Price: 0}, nil
+	}
+	return pathToRoute(best, isAsk), nil
+}
+
+// constrainedBellmanFord runs Bellman-Ford in hop layers over (token, visited required tokens)
+// states: layer h holds the best h-hop walks, so MaxHops is the number of layers and the route
+// must end in the state with every required token visited.
+// NOTE: a relaxation is skipped when the walk it extends already visits the target token, so
+// routes stay loop-free. Only the best walk of a state is kept, so a better simple route behind
+// a worse prefix is missed, constrainedRoute checks the result.
+func constrainedBellmanFord(graph Graph, start, end string, isAsk bool, constraints RouteConstraints) (candidatePath, bool) {
+	if _, ok := graph[start]; !ok {
+		return candidatePath{}, false
+	}
+	requiredBit, fullMask := constraints.requiredBits()
+	maxHops := constraints.maxHops(graph)
+
+	layers := []map[searchState]searchLabel{{{start, 0}: {distance: 0}}}
+	best := math.Inf(1)
+	bestLayer := -1
+	tokens := sortedTokens(graph)
+	for h := 1; h <= maxHops; h++ {
+		previous := layers[h-1]
+		current := make(map[searchState]searchLabel)
+		for _, u := range tokens {
+			for mask := 0; mask <= fullMask; mask++ {
+				state := searchState{u, mask}
+				label, ok := previous[state]
+				// The route ends at the quote, it is never an intermediate token
+				if !ok || u == end {
+					continue
+				}
+				for _, v := range sortedTokens(graph[u]) {
+					if walkVisits(layers, h-1, state, v) {
+						continue
+					}
+					edge := bestEdge(graph[u][v], isAsk)
+					distance := label.distance + logWeight(edge, isAsk)
+					next := searchState{v, mask | requiredBit[v]}
+					if existing, ok := current[next]; !ok || distance < existing.distance {
+						current[next] = searchLabel{distance: distance, previous: state, edge: edge}
+					}
+				}
+			}
+		}
+		layers = append(layers, current)
+		if label, ok := current[searchState{end, fullMask}]; ok && label.distance < best {
+			best = label.distance
+			bestLayer = h
+		}
+		if len(current) == 0 {
+			break
+		}
+	}
+	if bestLayer < 0 {
+		return candidatePath{}, false
+	}
+
+	var edges []TradingPair
+	state := searchState{end, fullMask}
+	for h := bestLayer; h > 0; h-- {
+		label := layers[h][state]
+		edges = append([]TradingPair{label.edge}, edges...)
+		state = label.previous
+	}
+	return candidatePath{edges: edges, cost: best}, true
+}
+
+// constrainedLabel is a loop-free walk from start in the layered search's state, its hop
+// count is the number of edges
+type constrainedLabel struct {
+	searchState
+	edges []TradingPair
+	cost  float64
+	// bound is cost plus the best distance left to end, no route through the walk costs less
+	bound float64
+	key   string
+}
+
+// constrainedPaths passes the routes satisfying constraints but MinVolume to yield best first,
+// until yield returns false or no route costing less than limit is left. Walks are extended
+// best bound first and only while they can still meet the constraints: a walk never goes past
+// MaxHops, enters end before every required token or leaves fewer hops than it has required
+// tokens to visit. Every parallel edge is its own route. The error is ErrArbitrageCycle when
+// a cycle leaves no distances to bound walks with, ErrSearchTruncated past
+// maxConstrainedLabels extended walks.
+func constrainedPaths(graph Graph, start, end string, isAsk bool, constraints RouteConstraints, limit float64, yield func(candidatePath) bool) error {
+	if _, ok := graph[start]; !ok || start == end {
+		return nil
+	}
+	toEnd, cycle := distancesToEnd(graph, start, end, isAsk)
+	if cycle {
+		return fmt.Errorf("%w: %s -> %s reaches a negative cycle", ErrArbitrageCycle, start, end)
+	}
+	if _, ok := toEnd[start]; !ok {
+		return nil
+	}
+	requiredBit, fullMask := constraints.requiredBits()
+	maxHops := constraints.maxHops(graph)
+
+	frontier := &labelHeap{{searchState: searchState{start, requiredBit[start]}, bound: toEnd[start]}}
+	extended := 0
+	for frontier.Len() > 0 {
+		label := heap.Pop(frontier).(constrainedLabel)
+		if label.bound >= limit {
+			return nil
+		}
+		if label.token == end {
+			if !yield(candidatePath{edges: label.edges, cost: label.cost}) {
+				return nil
+			}
+			continue
+		}
+		if extended++; extended > maxConstrainedLabels {
+			return fmt.Errorf("%w: %d walks %s -> %s extended", ErrSearchTruncated, maxConstrainedLabels, start, end)
+		}
+		hops := len(label.edges) + 1
+		for _, v := range sortedTokens(graph[label.token]) {
+			distance, ok := toEnd[v]
+			if !ok || label.visits(v) {
+				continue
+			}
+			mask := label.mask | requiredBit[v]
+			missing := bits.OnesCount(uint(fullMask &^ mask))
+			if (v == end && missing > 0) || (v != end && hops+missing+1 > maxHops) {
+				continue
+			}
+			for _, edge := range graph[label.token][v] {
+				next := constrainedLabel{
+					searchState: searchState{v, mask},
+					edges:       append(append(make([]TradingPair, 0, hops), label.edges...), edge),
+					cost:        label.cost + logWeight(edge, isAsk),
+				}
+				next.bound = next.cost + distance
+				next.key = candidatePath{edges: next.edges}.key()
+				heap.Push(frontier, next)
+			}
+		}
+	}
+	return nil
+}
+
+func (l constrainedLabel) visits(token string) bool {
+	if len(l.edges) == 0 {
+		return l.token == token
+	}
+	if l.edges[0].Base == token {
+		return true
+	}
+	for _, edge := range l.edges {
+		if edge.Quote == token {
+			return true
+		}
+	}
+	return false
+}
+
+// labelHeap is a min-heap on bound, ties go to the smaller key so equal routes come in a fixed order
+type labelHeap []constrainedLabel
+
+func (h labelHeap) Len() int { return len(h) }
+func (h labelHeap) Less(i, j int) bool {
+	if h[i].bound != h[j].bound {
+		return h[i].bound < h[j].bound
+	}
+	return h[i].key < h[j].key
+}
+func (h labelHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *labelHeap) Push(x any)   { *h = append(*h, x.(constrainedLabel)) }
+func (h *labelHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// distancesToEnd is the best distance of every token start reaches to end, tokens that can't
+// reach end are left out. cycle reports a negative cycle among them, the distances are then
+// wrong.
+func distancesToEnd(graph Graph, start, end string, isAsk bool) (map[string]float64, bool) {
+	// spfa from end over the reversed edges: reversed[v][u] keeps the u->v edges and weights
+	reversed := make(Graph)
+	for _, u := range reachable(graph, start) {
+		if reversed[u] == nil {
+			reversed[u] = make(map[string][]TradingPair)
+		}
+		for v, edges := range graph[u] {
+			if reversed[v] == nil {
+				reversed[v] = make(map[string][]TradingPair)
+			}
+			reversed[v][u] = edges
+		}
+	}
+	if _, ok := reversed[end]; !ok {
+		return nil, false
+	}
+	distances, _, cycle := spfa(reversed, []string{end}, isAsk)
+	return distances, cycle
+}
+
+// depthAwareRoute walks the routes satisfying constraints best first (see constrainedPaths)
+// and returns the first one whose capacity covers MinVolume. The error is ErrSearchTruncated
+// when maxDepthAwareRoutes routes went by without one, ErrArbitrageCycle when a cycle leaves
+// the routes unranked.
+// NOTE: a smaller prefix price makes an ask route carry less through its later hops but a bid
+// route more, so no walk dominates another and the routes are checked one by one.
+func depthAwareRoute(graph Graph, start, end string, isAsk bool, constraints RouteConstraints) (TradingRoute, error) {
+	best := TradingRoute{Route: []string{}, Price: 0}
+	inspected := 0
+	err := constrainedPaths(graph, start, end, isAsk, constraints, math.Inf(1), func(path candidatePath) bool {
+		inspected++
+		if tradingRoute := pathToRoute(path, isAsk); tradingRoute.Path.Capacity() >= constraints.MinVolume {
+			best = tradingRoute
+			return false
+		}
+		return inspected < maxDepthAwareRoutes
+	})
+	if err != nil {
+		return best, err
+	}
+	if len(best.Route) == 0 && inspected >= maxDepthAwareRoutes {
+		side := "bid"
+		if isAsk {
+			side = "ask"
+		}
+		return best, fmt.Errorf("%w: none of the best %d %s routes %s -> %s carries %v %s",
+			ErrSearchTruncated, maxDepthAwareRoutes, side, start, end, constraints.MinVolume, start)
+	}
+	return best, nil
+}
+
+// removed lists the excluded tokens and pairs
+func (c RouteConstraints) removed() *removedEdges {
+	removed := &removedEdges{tokens: make(map[string]bool), pairs: make(map[TokenPair]bool)}
+	for _, token := range c.ExcludedTokens {
+		removed.tokens[token] = true
+	}
+	for _, pair := range c.ExcludedPairs {
+		removed.pairs[pair] = true
+	}
+	return removed
+}
+
+// removedEdges are tokens and token pairs a search never uses, in both directions and on every venue
+type removedEdges struct {
+	tokens map[string]bool
+	pairs  map[TokenPair]bool
+}
+
+func (r *removedEdges) token(token string) bool {
+	return r != nil && r.tokens[token]
+}
+
+func (r *removedEdges) pair(from, to string) bool {
+	return r != nil && (r.pairs[TokenPair{from, to}] || r.pairs[TokenPair{to, from}])
+}
+
+// apply copies graph without the removed tokens and pairs, every search and the arbitrage
+// check then see the same market
+func (r *removedEdges) apply(graph Graph) Graph {
+	filtered := make(Graph, len(graph))
+	for u, neighbours := range graph {
+		if r.token(u) {
+			continue
+		}
+		filtered[u] = make(map[string][]TradingPair, len(neighbours))
+		for v, edges := range neighbours {
+			if !r.token(v) && !r.pair(u, v) {
+				filtered[u][v] = edges
+			}
+		}
+	}
+	return filtered
+}
+
+// requiredBits gives every required token its bit of the search state's mask
+func (c RouteConstraints) requiredBits() (map[string]int, int) {
+	requiredBit := make(map[string]int)
+	for i, token := range c.RequiredTokens {
+		requiredBit[token] = 1 << i
+	}
+	return requiredBit, 1<<len(c.RequiredTokens) - 1
+}
+
+// maxHops is MaxHops, or the longest loop-free route of graph when there is no limit
+func (c RouteConstraints) maxHops(graph Graph) int {
+	maxHops := len(graph) - 1
+	if c.MaxHops > 0 && c.MaxHops < maxHops {
+		maxHops = c.MaxHops
+	}
+	return maxHops
+}
+
+// walkVisits reports whether the walk ending in state at layer h passes through token
+func walkVisits(layers []map[searchState]searchLabel, h int, state searchState, token string) bool {
+	for ; h >= 0; h-- {
+		if state.token == token {
+			return true
+		}
+		if h > 0 {
+			state = layers[h][state].previous
+		}
+	}
+	return false
+}
+
+// parseConstraint reads a "constraint" line of a test case:
+//
+//	constraint max-hops=<n> | require=<TOKEN> | exclude=<TOKEN> | exclude-pair=<BASE>/<QUOTE> | min-volume=<base>
+//
+// several options may share a line and token lists are comma separated
+func parseConstraint(line string, constraints *RouteConstraints) error {
+	parts := strings.Fields(line)
+	if len(parts) < 2 || parts[0] != "constraint" {
+		return errors.New("expected a constraint line")
+	}
+	for _, option := range parts[1:] {
+		name, value, ok := strings.Cut(option, "=")
+		if !ok || value == "" {
+			return fmt.Errorf("invalid constraint: %s", option)
+		}
+		switch name {
+		case "max-hops":
+			hops, err := strconv.Atoi(value)
+			if err != nil || hops < 1 {
+				return fmt.Errorf("invalid max-hops: %s", value)
+			}
+			constraints.MaxHops = hops
+		case "min-volume":
+			volume, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(volume > 0) || math.IsInf(volume, 0) {
+				return fmt.Errorf("invalid min-volume: %s", value)
+			}
+			constraints.MinVolume = volume
+		case "require":
+			constraints.RequiredTokens = append(constraints.RequiredTokens, strings.Split(value, ",")...)
+		case "exclude":
+			constraints.ExcludedTokens = append(constraints.ExcludedTokens, strings.Split(value, ",")...)
+		case "exclude-pair":
+			for _, pair := range strings.Split(value, ",") {
+				base, quote, ok := strings.Cut(pair, "/")
+				if !ok {
+					return fmt.Errorf("excluded pair should be BASE/QUOTE: %s", pair)
+				}
+				constraints.ExcludedPairs = append(constraints.ExcludedPairs, TokenPair{base, quote})
+			}
+		default:
+			return fmt.Errorf("unknown constraint: %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package p1
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// quoted is a pair around mid with a relative spread
+func quoted(base, quote string, mid, spread float64) TradingPair {
+	return TradingPair{Base: base, Quote: quote, Ask: mid * (1 + spread), Bid: mid * (1 - spread)}
+}
+
+// allows checks a base->quote path against every constraint but MinVolume, the searches
+// enforce them while they walk instead
+func (c RouteConstraints) allows(edges []TradingPair) bool {
+	if c.MaxHops > 0 && len(edges) > c.MaxHops {
+		return false
+	}
+	visited := make(map[string]bool)
+	for _, edge := range edges {
+		visited[edge.Quote] = true
+		if c.removed().pair(edge.Base, edge.Quote) {
+			return false
+		}
+	}
+	for _, token := range c.ExcludedTokens {
+		if visited[token] {
+			return false
+		}
+	}
+	for _, token := range c.RequiredTokens {
+		if !visited[token] {
+			return false
+		}
+	}
+	return true
+}
+
+// exhaustiveConstrainedPrice prices every simple base->quote route allowed by constraints
+// and returns the best price, false when there is none
+func exhaustiveConstrainedPrice(graph Graph, base, quote string, isAsk bool, constraints RouteConstraints) (float64, bool) {
+	excluded := constraints.removed()
+	best := math.Inf(1)
+	visited := map[string]bool{base: true}
+	var edges []TradingPair
+	var walk func(token string, cost float64)
+	walk = func(token string, cost float64) {
+		if token == quote {
+			if constraints.allows(edges) && cost < best {
+				best = cost
+			}
+			return
+		}
+		for _, next := range sortedTokens(graph[token]) {
+			if visited[next] || excluded.token(next) || excluded.pair(token, next) {
+				continue
+			}
+			edge := bestEdge(graph[token][next], isAsk)
+			visited[next] = true
+			edges = append(edges, edge)
+			walk(next, cost+logWeight(edge, isAsk))
+			edges = edges[:len(edges)-1]
+			visited[next] = false
+		}
+	}
+	walk(base, 0)
+	if math.IsInf(best, 1) {
+		return 0, false
+	}
+	if isAsk {
+		return math.Exp(best), true
+	}
+	return math.Exp(-best), true
+}
+
+func TestConstrainedRoutesMatchExhaustiveSearch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tokens := []string{"T0", "T1", "T2", "T3", "T4", "T5"}
+	for i := 0; i < 500; i++ {
+		// One value per token and ask above bid on every pair, so there is no arbitrage
+		values := make([]float64, len(tokens))
+		for j := range values {
+			values[j] = 0.5 + r.Float64()
+		}
+		var pairs []TradingPair
+		for a := range tokens {
+			for b := a + 1; b < len(tokens); b++ {
+				if r.Float64() < 0.6 {
+					pairs = append(pairs, quoted(tokens[a], tokens[b], values[a]/values[b], 0.001+0.02*r.Float64()))
+				}
+			}
+		}
+		graph := buildGraph(pairs)
+		if graph["T0"] == nil || graph["T5"] == nil {
+			continue
+		}
+
+		var constraints RouteConstraints
+		constraints.MaxHops = r.Intn(5)
+		intermediates := r.Perm(4)
+		for _, j := range intermediates[:r.Intn(3)] {
+			constraints.RequiredTokens = append(constraints.RequiredTokens, tokens[1+j])
+		}
+		for _, j := range intermediates[3:][:r.Intn(2)] {
+			constraints.ExcludedTokens = append(constraints.ExcludedTokens, tokens[1+j])
+		}
+		for n := r.Intn(3); n > 0; n-- {
+			a, b := r.Intn(len(tokens)), r.Intn(len(tokens))
+			if a != b {
+				constraints.ExcludedPairs = append(constraints.ExcludedPairs, TokenPair{tokens[a], tokens[b]})
+			}
+		}
+		if constraints.validate("T0", "T5") != nil {
+			continue
+		}
+
+		askRoute, bidRoute, err := FindConstrainedTradingRoutesInGraph(graph, "T0", "T5", constraints)
+		askPrice, askOK := exhaustiveConstrainedPrice(graph, "T0", "T5", true, constraints)
+		bidPrice, bidOK := exhaustiveConstrainedPrice(graph, "T0", "T5", false, constraints)
+		if !askOK || !bidOK {
+			if !errors.Is(err, ErrNoRoute) {
+				t.Fatalf("case %d %+v: got %v, want ErrNoRoute", i, constraints, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d %+v: %v, exhaustive search prices ask %.8f bid %.8f", i, constraints, err, askPrice, bidPrice)
+		}
+		for _, side := range []struct {
+			name        string
+			route       TradingRoute
+			exhaustive  float64
+			constraints RouteConstraints
+		}{{"ask", askRoute, askPrice, constraints}, {"bid", bidRoute, bidPrice, constraints}} {
+			if math.Abs(side.route.Price-side.exhaustive) > 1e-9*side.exhaustive {
+				t.Fatalf("case %d %+v: %s %s at %.10f, exhaustive search %.10f",
+					i, side.constraints, side.name, formatRoute(side.route.Route), side.route.Price, side.exhaustive)
+			}
+		}
+	}
+}
+
+func TestConstrainedRouteBehindWorsePrefix(t *testing.T) {
+	// The best 3-hop walk to B is S->A->C->B, it can't continue to E through A. The only route
+	// through B starts with the worse S/D pair.
+	pairs := []TradingPair{
+		quoted("S", "A", 1, 0.01),
+		quoted("S", "D", 1, 0.05),
+		quoted("A", "C", 1, 0.01),
+		quoted("D", "C", 1, 0.01),
+		quoted("C", "B", 1, 0.01),
+		quoted("B", "A", 1, 0.01),
+		quoted("A", "E", 1, 0.01),
+	}
+	askRoute, bidRoute, err := FindConstrainedTradingRoutes("S", "E", pairs, RouteConstraints{RequiredTokens: []string{"B"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatRoute(askRoute.Route); got != "E->A->B->C->D->S" {
+		t.Fatalf("ask route %s, want E->A->B->C->D->S", got)
+	}
+	if got := formatRoute(bidRoute.Route); got != "S->D->C->B->A->E" {
+		t.Fatalf("bid route %s, want S->D->C->B->A->E", got)
+	}
+}
+
+func TestRouteConstraintInteractions(t *testing.T) {
+	// KNC/ETH direct, through USDT (best) or through USDT and DAI
+	pairs := []TradingPair{
+		quoted("KNC", "USDT", 1, 0.01),
+		quoted("ETH", "USDT", 360, 0.001),
+		quoted("KNC", "ETH", 1.0/360, 0.1),
+		quoted("USDT", "DAI", 1, 0.001),
+		quoted("DAI", "ETH", 1.0/360, 0.001),
+	}
+	for _, test := range []struct {
+		name        string
+		constraints RouteConstraints
+		bid         string // "" when no route satisfies the constraints
+	}{
+		{"unconstrained", RouteConstraints{MaxHops: 5}, "KNC->USDT->ETH"},
+		{"excluded pair takes the detour", RouteConstraints{ExcludedPairs: []TokenPair{{"ETH", "USDT"}}}, "KNC->USDT->DAI->ETH"},
+		{"excluded pair and hop limit leave the direct pair", RouteConstraints{MaxHops: 2, ExcludedPairs: []TokenPair{{"ETH", "USDT"}}}, "KNC->ETH"},
+		{"required token within the hop limit", RouteConstraints{MaxHops: 3, RequiredTokens: []string{"DAI"}}, "KNC->USDT->DAI->ETH"},
+		{"required token beyond the hop limit", RouteConstraints{MaxHops: 2, RequiredTokens: []string{"DAI"}}, ""},
+		{"required token behind an excluded token", RouteConstraints{RequiredTokens: []string{"DAI"}, ExcludedTokens: []string{"USDT"}}, ""},
+		{"required token behind an excluded pair", RouteConstraints{RequiredTokens: []string{"USDT"}, ExcludedPairs: []TokenPair{{"USDT", "KNC"}}}, ""},
+		{"two required tokens", RouteConstraints{RequiredTokens: []string{"DAI", "USDT"}, MaxHops: 3}, "KNC->USDT->DAI->ETH"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, bidRoute, err := FindConstrainedTradingRoutes("KNC", "ETH", pairs, test.constraints)
+			if test.bid == "" {
+				if !errors.Is(err, ErrNoRoute) {
+					t.Fatalf("got %s, %v, want ErrNoRoute", formatRoute(bidRoute.Route), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatRoute(bidRoute.Route); got != test.bid {
+				t.Fatalf("bid route %s, want %s", got, test.bid)
+			}
+		})
+	}
+}
+
+func TestExcludedArbitrageCycle(t *testing.T) {
+	// X is depegged: USDT->X->USDT multiplies by 1.1, an arbitrage cycle on X/USDT only. Sized
+	// books let min-volume run the same exclusions through the depth-aware search.
+	pairs := []TradingPair{
+		quoted("KNC", "USDT", 1, 0.01),
+		quoted("ETH", "USDT", 360, 0.001),
+		{Base: "X", Quote: "USDT", Ask: 0.9, Bid: 1.1},
+	}
+	for i := range pairs {
+		pairs[i].AskSize, pairs[i].BidSize = 1000, 1000
+	}
+	for _, minVolume := range []float64{0, 500} {
+		_, _, err := FindConstrainedTradingRoutes("KNC", "ETH", pairs, RouteConstraints{MinVolume: minVolume})
+		if !errors.Is(err, ErrArbitrageCycle) {
+			t.Fatalf("min-volume %v unconstrained: got %v, want ErrArbitrageCycle", minVolume, err)
+		}
+		for _, constraints := range []RouteConstraints{
+			{ExcludedTokens: []string{"X"}, MinVolume: minVolume},
+			{ExcludedPairs: []TokenPair{{"USDT", "X"}}, MinVolume: minVolume},
+		} {
+			_, bidRoute, err := FindConstrainedTradingRoutes("KNC", "ETH", pairs, constraints)
+			if err != nil {
+				t.Fatalf("%+v: %v", constraints, err)
+			}
+			if got := formatRoute(bidRoute.Route); got != "KNC->USDT->ETH" {
+				t.Fatalf("%+v: bid route %s, want KNC->USDT->ETH", constraints, got)
+			}
+		}
+	}
+	// The KNC/USDT book caps both routes at 1000 KNC, the excluded cycle adds no volume
+	_, _, err := FindConstrainedTradingRoutes("KNC", "ETH", pairs, RouteConstraints{ExcludedTokens: []string{"X"}, MinVolume: 1200})
+	if !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("min-volume 1200: got %v, want ErrNoRoute", err)
+	}
+}
+
+func TestConstrainedSearchLimit(t *testing.T) {
+	// R only trades against T0, no route can visit it and go on. The walk has to try every
+	// route through the other tokens to prove it.
+	pairs := append(completeMarket(6, 1), quoted("T0", "R", 1, 0.001))
+	_, _, err := FindConstrainedTradingRoutes("T0", "T1", pairs, RouteConstraints{RequiredTokens: []string{"R"}})
+	if !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("6 tokens: got %v, want ErrNoRoute", err)
+	}
+	pairs = append(completeMarket(10, 1), quoted("T0", "R", 1, 0.001))
+	_, _, err = FindConstrainedTradingRoutes("T0", "T1", pairs, RouteConstraints{RequiredTokens: []string{"R"}})
+	if !errors.Is(err, ErrSearchTruncated) {
+		t.Fatalf("10 tokens: got %v, want ErrSearchTruncated", err)
+	}
+}
+
+func TestRouteConstraintsValidate(t *testing.T) {
+	tooMany := make([]string, maxRequiredTokens+1)
+	for i := range tooMany {
+		tooMany[i] = "T" + strconv.Itoa(i)
+	}
+	for _, test := range []struct {
+		constraints RouteConstraints
+		err         string
+	}{
+		{RouteConstraints{RequiredTokens: tooMany}, "required tokens"},
+		{RouteConstraints{RequiredTokens: []string{"USDT", "DAI"}, MaxHops: 2}, "need more than 2 hops"},
+		{RouteConstraints{RequiredTokens: []string{"USDT"}, ExcludedTokens: []string{"USDT"}}, "both required and excluded"},
+		{RouteConstraints{ExcludedTokens: []string{"ETH"}}, "cannot exclude ETH"},
+		{RouteConstraints{MaxHops: -1}, "must not be negative"},
+	} {
+		err := test.constraints.validate("KNC", "ETH")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%+v: got %v, want %q", test.constraints, err, test.err)
+		}
+	}
+}
+
+// completeMarket quotes every pair of tokens at 1 with a tight spread and the given top-of-book
+// size, except T0/T1: a wide spread but 1000 on each side
+func completeMarket(tokens int, size float64) []TradingPair {
+	var pairs []TradingPair
+	for a := 0; a < tokens; a++ {
+		for b := a + 1; b < tokens; b++ {
+			pair := quoted("T"+strconv.Itoa(a), "T"+strconv.Itoa(b), 1, 0.001)
+			pair.AskSize, pair.BidSize = size, size
+			if a == 0 && b == 1 {
+				pair = quoted("T0", "T1", 1, 0.5)
+				pair.AskSize, pair.BidSize = 1000, 1000
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func TestDepthAwareRouteLimit(t *testing.T) {
+	// 8 tokens have 1957 T0->T1 routes and the direct one, the only one carrying 100, is the worst
+	pairs := completeMarket(8, 1)
+	_, _, err := FindConstrainedTradingRoutes("T0", "T1", pairs, RouteConstraints{MinVolume: 100})
+	if !errors.Is(err, ErrSearchTruncated) {
+		t.Fatalf("got %v, want ErrSearchTruncated", err)
+	}
+
+	// Without T6 and T7 only 65 routes are left to walk
+	askRoute, bidRoute, err := FindConstrainedTradingRoutes("T0", "T1", pairs, RouteConstraints{MinVolume: 100, ExcludedTokens: []string{"T6", "T7"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatRoute(askRoute.Route); got != "T1->T0" {
+		t.Fatalf("ask route %s, want T1->T0", got)
+	}
+	if got := formatRoute(bidRoute.Route); got != "T0->T1" {
+		t.Fatalf("bid route %s, want T0->T1", got)
+	}
+
+	// Every route walked and none carries the volume
+	_, _, err = FindConstrainedTradingRoutes("T0", "T1", completeMarket(4, 1), RouteConstraints{MinVolume: 2000})
+	if !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("got %v, want ErrNoRoute", err)
+	}
+}
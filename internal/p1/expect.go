@@ -0,0 +1,138 @@
+package p1
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultTolerance covers prices copied from the runner output, which prints 8 decimals
+const DefaultTolerance = 1e-8
+
+// Expectation is one "expect" line of a test case:
+//
+//	expect ask|bid <route> <price> [tol=<abs>] [rank=<n>]
+//	expect error <text>
+//
+// The route is written as printed, e.g. ETH->USDT->KNC, and "-" skips the route check.
+// rank picks the n-th best route of a "routes <k>" test case, the best one by default.
+type Expectation struct {
+	Line      int
+	Side      string
+	Route     []string
+	Price     float64
+	Tolerance float64
+	Rank      int // 1-based, 0 is the best route
+	Error     string
+}
+
+func parseExpectation(line string) (Expectation, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 || parts[0] != "expect" {
+		return Expectation{}, errors.New("expected an expect line")
+	}
+	switch parts[1] {
+	case "error":
+		if len(parts) < 3 {
+			return Expectation{}, errors.New("expect error needs the error text")
+		}
+		return Expectation{Side: "error", Error: strings.Join(parts[2:], " ")}, nil
+	case "ask", "bid":
+		if len(parts) < 4 || len(parts) > 6 {
+			return Expectation{}, fmt.Errorf("expect %s needs a route and a price", parts[1])
+		}
+		expectation := Expectation{Side: parts[1], Tolerance: DefaultTolerance}
+		if parts[2] != "-" {
+			expectation.Route = strings.Split(parts[2], "->")
+		}
+		price, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil {
+			return Expectation{}, fmt.Errorf("invalid expected price: %s", parts[3])
+		}
+		expectation.Price = price
+		for _, option := range parts[4:] {
+			name, value, _ := strings.Cut(option, "=")
+			switch name {
+			case "tol":
+				if expectation.Tolerance, err = strconv.ParseFloat(value, 64); err != nil || expectation.Tolerance < 0 {
+					return Expectation{}, fmt.Errorf("invalid tolerance: %s", value)
+				}
+			case "rank":
+				if expectation.Rank, err = strconv.Atoi(value); err != nil || expectation.Rank < 1 {
+					return Expectation{}, fmt.Errorf("invalid rank: %s", value)
+				}
+			default:
+				return Expectation{}, fmt.Errorf("unknown expect option: %s", option)
+			}
+		}
+		return expectation, nil
+	}
+	return Expectation{}, fmt.Errorf("unknown expectation: %s", parts[1])
+}
+
+// Check compares the outcome of Solve with the scenario's expectations and returns one
+// message per mismatch. An error is a mismatch unless an "expect error" line matches it.
+func (s Scenario) Check(result Result, err error) []string {
+	var mismatches []string
+	errorExpected := false
+	for _, expectation := range s.Expect {
+		switch expectation.Side {
+		case "error":
+			errorExpected = true
+			if err == nil {
+				mismatches = append(mismatches, fmt.Sprintf("line %d: expected error %q, got none", expectation.Line, expectation.Error))
+			} else if !strings.Contains(err.Error(), expectation.Error) {
+				mismatches = append(mismatches, fmt.Sprintf("line %d: expected error %q, got %q", expectation.Line, expectation.Error, err.Error()))
+			}
+		case "ask", "bid":
+			if err != nil {
+				continue
+			}
+			route, ok := result.ranked(expectation.Side, expectation.Rank)
+			if !ok {
+				mismatches = append(mismatches, fmt.Sprintf("line %d: %s route %d: expected %s, got none", expectation.Line, expectation.Side, expectation.Rank, formatRoute(expectation.Route)))
+				continue
+			}
+			if expectation.Route != nil && formatRoute(expectation.Route) != formatRoute(route.Route) {
+				mismatches = append(mismatches, fmt.Sprintf("line %d: %s route: expected %s, got %s", expectation.Line, expectation.Side, formatRoute(expectation.Route), formatRoute(route.Route)))
+			}
+			if math.Abs(route.Price-expectation.Price) > expectation.Tolerance {
+				mismatches = append(mismatches, fmt.Sprintf("line %d: %s price: expected %.8f, got %.8f (tol %g)", expectation.Line, expectation.Side, expectation.Price, route.Price, expectation.Tolerance))
+			}
+		}
+	}
+	if err != nil && !errorExpected {
+		mismatches = append(mismatches, fmt.Sprintf("unexpected error: %v", err))
+	}
+	// NOTE: every solved case also checks the orientation of its routes, see route.Route.Check
+	if err == nil {
+		for _, side := range []struct {
+			name   string
+			routes []TradingRoute
+		}{{"ask", append([]TradingRoute{result.Ask}, result.AskRoutes...)}, {"bid", append([]TradingRoute{result.Bid}, result.BidRoutes...)}} {
+			for _, tradingRoute := range side.routes {
+				if checkErr := tradingRoute.Path.Check(s.Base, s.Quote, tradingRoute.Price); checkErr != nil {
+					mismatches = append(mismatches, fmt.Sprintf("%s route: %v", side.name, checkErr))
+				}
+			}
+		}
+	}
+	return mismatches
+}
+
+// ranked returns the rank-th best route of side, the best one for rank 0
+func (r Result) ranked(side string, rank int) (TradingRoute, bool) {
+	routes, best := r.BidRoutes, r.Bid
+	if side == "ask" {
+		routes, best = r.AskRoutes, r.Ask
+	}
+	if rank <= 1 {
+		return best, true
+	}
+	if rank > len(routes) {
+		return TradingRoute{}, false
+	}
+	return routes[rank-1], true
+}
@@ -0,0 +1,230 @@
+package p1
+
+import (
+	"fmt"
+	"math"
+	"orderbook-pathfinder/internal/route"
+	"sort"
+	"strings"
+)
+
+// edgeID identifies one venue's edge between two tokens
+type edgeID struct {
+	from  string
+	to    string
+	venue string
+}
+
+func edgeOf(pair TradingPair) edgeID {
+	return edgeID{pair.Base, pair.Quote, pair.Venue}
+}
+
+// candidatePath is a base->quote path of graph edges with its summed log weight
+type candidatePath struct {
+	edges []TradingPair
+	cost  float64
+}
+
+func (c candidatePath) key() string {
+	var b strings.Builder
+	for _, edge := range c.edges {
+		fmt.Fprintf(&b, "%s>%s@%s|", edge.Base, edge.Quote, edge.Venue)
+	}
+	return b.String()
+}
+
+// FindKBestRoutes returns up to k loop-free ask and bid routes, best first, using Yen's algorithm
+// over the log-weight Bellman-Ford. The same tokens on another venue is a different route, so a
+// fallback exists when a venue is down. The error is an *ArbitrageError when a cycle touches the
+// routes, or when a cycle made a search loop and the routes are cut short.
+func FindKBestRoutes(baseCurrency, quoteCurrency string, pairs []TradingPair, k int) ([]TradingRoute, []TradingRoute, error) {
+	return findKBestRoutesInGraph(buildGraph(pairs), baseCurrency, quoteCurrency, k)
+}
+
+func findKBestRoutesInGraph(graph Graph, baseCurrency, quoteCurrency string, k int) ([]TradingRoute, []TradingRoute, error) {
+	if k < 1 {
+		return nil, nil, fmt.Errorf("k must be at least 1, got %d", k)
+	}
+	askRoutes, askErr := kBestRoutes(graph, baseCurrency, quoteCurrency, k, true)
+	bidRoutes, bidErr := kBestRoutes(graph, baseCurrency, quoteCurrency, k, false)
+	routes := append(append([]TradingRoute{}, askRoutes...), bidRoutes...)
+	cycles := detectArbitrageCycles(graph)
+	contaminating := contaminatingCycles(cycles, routes...)
+	if askErr != nil || bidErr != nil {
+		// NOTE: the cycle that made the search loop may not touch the routes found before it
+		contaminating = cycles
+	}
+	if len(contaminating) > 0 || askErr != nil || bidErr != nil {
+		return askRoutes, bidRoutes, &ArbitrageError{
+			Base:   baseCurrency,
+			Quote:  quoteCurrency,
+			Cycles: contaminating,
+		}
+	}
+	return askRoutes, bidRoutes, nil
+}
+
+func kBestRoutes(graph Graph, start, end string, k int, isAsk bool) ([]TradingRoute, error) {
+	var routes []TradingRoute
+	err := yenPaths(graph, start, end, isAsk, func(path candidatePath) bool {
+		routes = append(routes, pathToRoute(path, isAsk))
+		return len(routes) < k
+	})
+	return routes, err
+}
+
+// yenPaths passes loop-free paths to yield best first until yield returns false or no
+// path is left. A search that reaches an arbitrage cycle stops the enumeration with
+// ErrArbitrageCycle: the paths after it can't be ranked.
+func yenPaths(graph Graph, start, end string, isAsk bool, yield func(candidatePath) bool) error {
+	if _, ok := graph[start]; !ok || start == end {
+		return nil
+	}
+	first, ok, err := shortestEdgePath(graph, start, end, isAsk, nil, nil)
+	if err != nil || !ok || !yield(first) {
+		return err
+	}
+	accepted := []candidatePath{first}
+	seen := map[string]bool{first.key(): true}
+	var candidates []candidatePath
+
+	for {
+		previous := accepted[len(accepted)-1]
+		for i := range previous.edges {
+			spurNode := previous.edges[i].Base
+			root := previous.edges[:i]
+
+			// Remove the next edge of every accepted path sharing this root, and the root's nodes
+			removedEdges := make(map[edgeID]bool)
+			for _, path := range accepted {
+				if len(path.edges) > i && sameEdges(path.edges[:i], root) {
+					removedEdges[edgeOf(path.edges[i])] = true
+				}
+			}
+			removedNodes := make(map[string]bool)
+			for _, edge := range root {
+				removedNodes[edge.Base] = true
+			}
+
+			spur, ok, err := shortestEdgePath(graph, spurNode, end, isAsk, removedNodes, removedEdges)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			candidate := candidatePath{edges: append(append([]TradingPair{}, root...), spur.edges...)}
+			for _, edge := range candidate.edges {
+				candidate.cost += logWeight(edge, isAsk)
+			}
+			if key := candidate.key(); !seen[key] {
+				seen[key] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].cost != candidates[j].cost {
+				return candidates[i].cost < candidates[j].cost
+			}
+			return candidates[i].key() < candidates[j].key()
+		})
+		accepted = append(accepted, candidates[0])
+		candidates = candidates[1:]
+		if !yield(accepted[len(accepted)-1]) {
+			return nil
+		}
+	}
+}
+
+// shortestEdgePath is bellmanFordWithLog over every parallel edge, skipping removed nodes and edges.
+// A negative cycle the search reaches, an arbitrage cycle, is reported as ErrArbitrageCycle.
+func shortestEdgePath(graph Graph, start, end string, isAsk bool, removedNodes map[string]bool, removedEdges map[edgeID]bool) (candidatePath, bool, error) {
+	distances := make(map[string]float64)
+	tracer := make(map[string]TradingPair)
+	tokens := sortedTokens(graph)
+	for _, node := range tokens {
+		distances[node] = math.Inf(1)
+	}
+	distances[start] = 0
+
+	relax := func() bool {
+		changed := false
+		for _, u := range tokens {
+			if removedNodes[u] || distances[u] == math.Inf(1) {
+				continue
+			}
+			for _, v := range sortedTokens(graph[u]) {
+				if removedNodes[v] {
+					continue
+				}
+				for _, edge := range graph[u][v] {
+					if removedEdges[edgeOf(edge)] {
+						continue
+					}
+					if distance := distances[u] + logWeight(edge, isAsk); distance < distances[v] {
+						distances[v] = distance
+						tracer[v] = edge
+						changed = true
+					}
+				}
+			}
+		}
+		return changed
+	}
+	changed := true
+	for i := 0; i < len(graph)-1 && changed; i++ {
+		changed = relax()
+	}
+	// Still improving after |V|-1 passes: a negative cycle is reachable, the distances are wrong
+	// even when the trace doesn't loop
+	if changed && relax() {
+		return candidatePath{}, false, fmt.Errorf("%w: %s -> %s reaches a negative cycle", ErrArbitrageCycle, start, end)
+	}
+	if distances[end] == math.Inf(1) {
+		return candidatePath{}, false, nil
+	}
+
+	var edges []TradingPair
+	visited := map[string]bool{end: true}
+	for current := end; current != start; {
+		edge, ok := tracer[current]
+		if !ok || visited[edge.Base] {
+			return candidatePath{}, false, fmt.Errorf("%w: %s -> %s loops", ErrArbitrageCycle, start, end)
+		}
+		visited[edge.Base] = true
+		edges = append([]TradingPair{edge}, edges...)
+		current = edge.Base
+	}
+	return candidatePath{edges: edges, cost: distances[end]}, true, nil
+}
+
+func logWeight(edge TradingPair, isAsk bool) float64 {
+	if isAsk {
+		return math.Log(edge.Ask)
+	}
+	return -math.Log(edge.Bid)
+}
+
+func sameEdges(a, b []TradingPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if edgeOf(a[i]) != edgeOf(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathToRoute prices a path like bellmanFordWithLog, the ask route is reversed the same way
+func pathToRoute(path candidatePath, isAsk bool) TradingRoute {
+	var hops []route.Hop
+	for _, edge := range path.edges {
+		hops = append(hops, searchHop(edge, isAsk))
+	}
+	return tradingRoute(hops, isAsk)
+}
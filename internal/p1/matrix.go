@@ -0,0 +1,165 @@
+package p1
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// MatrixEntry is the best ask and bid route of one base/quote. Err is an *ArbitrageError
+// when the routes can loop on an arbitrage cycle, like FindOptimalTradingRoutesInGraph returns.
+type MatrixEntry struct {
+	Base  string
+	Quote string
+	Ask   TradingRoute
+	Bid   TradingRoute
+	Err   error
+}
+
+// PriceMatrix holds the best routes of every ordered token pair of one graph snapshot.
+// Both sides come from one Floyd-Warshall run each, Quote reads a pair in O(1).
+type PriceMatrix struct {
+	Tokens  []string
+	index   map[string]int
+	entries [][]MatrixEntry // [base][quote], Base is empty when quote can't be reached
+}
+
+// BuildPriceMatrix prices every pair of graph, see FloydWarshall for the cost
+func BuildPriceMatrix(graph Graph) PriceMatrix {
+	asks := newAllPairsPaths(graph, true)
+	bids := newAllPairsPaths(graph, false)
+	cycles := detectArbitrageCycles(graph)
+	matrix := PriceMatrix{
+		Tokens:  asks.tokens,
+		index:   asks.index,
+		entries: make([][]MatrixEntry, len(asks.tokens)),
+	}
+	for i, base := range matrix.Tokens {
+		matrix.entries[i] = make([]MatrixEntry, len(matrix.Tokens))
+		for j, quote := range matrix.Tokens {
+			if i == j || asks.next[i][j] < 0 {
+				continue
+			}
+			entry := MatrixEntry{
+				Base:  base,
+				Quote: quote,
+				Ask:   asks.route(graph, base, quote, true),
+				Bid:   bids.route(graph, base, quote, false),
+			}
+			if reachable := asks.cyclesBetween(cycles, i, j); len(reachable) > 0 {
+				entry.Err = &ArbitrageError{Base: base, Quote: quote, Cycles: reachable}
+			}
+			matrix.entries[i][j] = entry
+		}
+	}
+	return matrix
+}
+
+// cyclesBetween lists the cycles a path from token i to token j can loop on. Floyd-Warshall
+// may trace a path around them, its price is unbounded all the same.
+func (p allPairsPaths) cyclesBetween(cycles []ArbitrageCycle, i, j int) []ArbitrageCycle {
+	var between []ArbitrageCycle
+	for _, cycle := range cycles {
+		for _, token := range cycle.Tokens {
+			if k := p.index[token]; p.next[i][k] >= 0 && p.next[k][j] >= 0 {
+				between = append(between, cycle)
+				break
+			}
+		}
+	}
+	return between
+}
+
+// Quote returns the routes of base/quote, ok is false for an unknown token, an unreachable
+// quote or base == quote
+func (m PriceMatrix) Quote(base, quote string) (MatrixEntry, bool) {
+	i, ok := m.index[base]
+	if !ok {
+		return MatrixEntry{}, false
+	}
+	j, ok := m.index[quote]
+	if !ok || m.entries[i][j].Base == "" {
+		return MatrixEntry{}, false
+	}
+	return m.entries[i][j], true
+}
+
+// Entries lists the priced pairs ordered by base then quote
+func (m PriceMatrix) Entries() []MatrixEntry {
+	var entries []MatrixEntry
+	for _, row := range m.entries {
+		for _, entry := range row {
+			if entry.Base != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// matrixRecord is one exported entry, the price of a pair on an arbitrage cycle is left out
+type matrixRecord struct {
+	Base     string   `json:"base"`
+	Quote    string   `json:"quote"`
+	Ask      *float64 `json:"ask"`
+	AskRoute []string `json:"askRoute"`
+	Bid      *float64 `json:"bid"`
+	BidRoute []string `json:"bidRoute"`
+	Error    string   `json:"error,omitempty"`
+}
+
+func (e MatrixEntry) record() matrixRecord {
+	record := matrixRecord{Base: e.Base, Quote: e.Quote, AskRoute: e.Ask.Route, BidRoute: e.Bid.Route}
+	if e.Err != nil {
+		record.Error = e.Err.Error()
+		return record
+	}
+	ask, bid := e.Ask.Price, e.Bid.Price
+	record.Ask, record.Bid = &ask, &bid
+	return record
+}
+
+// WriteJSON writes the entries as an array of {base, quote, ask, askRoute, bid, bidRoute, error}
+func (m PriceMatrix) WriteJSON(w io.Writer) error {
+	records := []matrixRecord{}
+	for _, entry := range m.Entries() {
+		records = append(records, entry.record())
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+// WriteCSV writes one row per entry, routes as printed (e.g. ETH->USDT->KNC):
+//
+//	base,quote,ask,ask_route,bid,bid_route,error
+func (m PriceMatrix) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"base", "quote", "ask", "ask_route", "bid", "bid_route", "error"}); err != nil {
+		return err
+	}
+	for _, entry := range m.Entries() {
+		record := entry.record()
+		if err := writer.Write([]string{
+			record.Base,
+			record.Quote,
+			formatPrice(record.Ask),
+			formatRoute(record.AskRoute),
+			formatPrice(record.Bid),
+			formatRoute(record.BidRoute),
+			record.Error,
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatPrice(price *float64) string {
+	if price == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*price, 'f', -1, 64)
+}
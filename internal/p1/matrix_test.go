@@ -0,0 +1,178 @@
+package p1
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestPriceMatrixMatchesSearch(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 200; i++ {
+		pairs := randomMarket(3+r.Intn(5), r)
+		// Cross one market in about half the cases, every pair that can loop on it fails
+		if len(pairs) > 0 && r.Intn(2) == 0 {
+			crossed := &pairs[r.Intn(len(pairs))]
+			crossed.Ask, crossed.Bid = crossed.Bid, crossed.Ask*1.01
+			crossed.FeeBps = 0
+		}
+		graph := buildGraph(pairs)
+		matrix := BuildPriceMatrix(graph)
+		for _, base := range matrix.Tokens {
+			for _, quote := range matrix.Tokens {
+				entry, ok := matrix.Quote(base, quote)
+				ask, bid, err := FindOptimalTradingRoutesInGraph(graph, base, quote)
+				if base == quote || (err == nil && len(ask.Route) == 0) || errors.Is(err, ErrNoRoute) {
+					if ok {
+						t.Fatalf("case %d %s/%s: matrix prices a pair the search can't route", i, base, quote)
+					}
+					continue
+				}
+				if !ok {
+					t.Fatalf("case %d %s/%s: missing from the matrix, the search finds %v", i, base, quote, ask.Route)
+				}
+				var want, got *ArbitrageError
+				if errors.As(err, &want) != errors.As(entry.Err, &got) {
+					t.Fatalf("case %d %s/%s: matrix error %v, search error %v", i, base, quote, entry.Err, err)
+				}
+				if err != nil {
+					continue
+				}
+				if math.Abs(entry.Ask.Price-ask.Price) > 1e-9*ask.Price || math.Abs(entry.Bid.Price-bid.Price) > 1e-9*bid.Price {
+					t.Fatalf("case %d %s/%s: matrix ask %.12g bid %.12g, search %.12g %.12g",
+						i, base, quote, entry.Ask.Price, entry.Bid.Price, ask.Price, bid.Price)
+				}
+				checkMarketPrices(t, pairs, base, quote, entry.Ask)
+				checkMarketPrices(t, pairs, base, quote, entry.Bid)
+			}
+		}
+	}
+}
+
+// goldenPairs quotes KNC/USDT, and X/Y on its own with its bid above its ask
+var goldenPairs = []TradingPair{
+	{Base: "KNC", Quote: "USDT", Ask: 1.25, Bid: 1},
+	{Base: "X", Quote: "Y", Ask: 0.5, Bid: 2},
+}
+
+func TestPriceMatrixArbitrage(t *testing.T) {
+	matrix := BuildPriceMatrix(BuildGraph(goldenPairs))
+	for _, pair := range [][2]string{{"X", "Y"}, {"Y", "X"}} {
+		entry, ok := matrix.Quote(pair[0], pair[1])
+		var arbitrage *ArbitrageError
+		if !ok || !errors.As(entry.Err, &arbitrage) {
+			t.Fatalf("%s/%s: %v, want an ArbitrageError", pair[0], pair[1], entry.Err)
+		}
+		if arbitrage.Base != pair[0] || arbitrage.Quote != pair[1] || len(arbitrage.Cycles) == 0 {
+			t.Fatalf("%s/%s: %+v", pair[0], pair[1], arbitrage)
+		}
+	}
+	if entry, ok := matrix.Quote("KNC", "USDT"); !ok || entry.Err != nil {
+		t.Fatalf("KNC/USDT: %v, no cycle touches it", entry.Err)
+	}
+	for _, pair := range [][2]string{{"KNC", "X"}, {"KNC", "KNC"}, {"KNC", "DOGE"}, {"DOGE", "KNC"}} {
+		if _, ok := matrix.Quote(pair[0], pair[1]); ok {
+			t.Errorf("%s/%s is priced", pair[0], pair[1])
+		}
+	}
+}
+
+const goldenCSV = `base,quote,ask,ask_route,bid,bid_route,error
+KNC,USDT,1.25,USDT->KNC,1,KNC->USDT,
+USDT,KNC,1,KNC->USDT,0.8,USDT->KNC,
+X,Y,,Y->X,,X->Y,"X->Y: arbitrage cycle detected: 1 cycle(s), e.g. X->Y->X (x4.00000000)"
+Y,X,,X->Y,,Y->X,"Y->X: arbitrage cycle detected: 1 cycle(s), e.g. X->Y->X (x4.00000000)"
+`
+
+const goldenJSON = `[
+  {
+    "base": "KNC",
+    "quote": "USDT",
+    "ask": 1.25,
+    "askRoute": [
+      "USDT",
+      "KNC"
+    ],
+    "bid": 1,
+    "bidRoute": [
+      "KNC",
+      "USDT"
+    ]
+  },
+  {
+    "base": "USDT",
+    "quote": "KNC",
+    "ask": 1,
+    "askRoute": [
+      "KNC",
+      "USDT"
+    ],
+    "bid": 0.8,
+    "bidRoute": [
+      "USDT",
+      "KNC"
+    ]
+  },
+  {
+    "base": "X",
+    "quote": "Y",
+    "ask": null,
+    "askRoute": [
+      "Y",
+      "X"
+    ],
+    "bid": null,
+    "bidRoute": [
+      "X",
+      "Y"
+    ],
+    "error": "X-\u003eY: arbitrage cycle detected: 1 cycle(s), e.g. X-\u003eY-\u003eX (x4.00000000)"
+  },
+  {
+    "base": "Y",
+    "quote": "X",
+    "ask": null,
+    "askRoute": [
+      "X",
+      "Y"
+    ],
+    "bid": null,
+    "bidRoute": [
+      "Y",
+      "X"
+    ],
+    "error": "Y-\u003eX: arbitrage cycle detected: 1 cycle(s), e.g. X-\u003eY-\u003eX (x4.00000000)"
+  }
+]
+`
+
+func TestPriceMatrixExport(t *testing.T) {
+	matrix := BuildPriceMatrix(BuildGraph(goldenPairs))
+	for _, test := range []struct {
+		name   string
+		write  func(*strings.Builder) error
+		golden string
+	}{
+		{"csv", func(b *strings.Builder) error { return matrix.WriteCSV(b) }, goldenCSV},
+		{"json", func(b *strings.Builder) error { return matrix.WriteJSON(b) }, goldenJSON},
+	} {
+		var got strings.Builder
+		if err := test.write(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != test.golden {
+			t.Errorf("%s got:\n%s\nwant:\n%s", test.name, got.String(), test.golden)
+		}
+	}
+
+	// An empty matrix is an empty array, not null
+	var empty strings.Builder
+	if err := BuildPriceMatrix(Graph{}).WriteJSON(&empty); err != nil {
+		t.Fatal(err)
+	}
+	if empty.String() != "[]\n" {
+		t.Errorf("empty matrix: %q", empty.String())
+	}
+}
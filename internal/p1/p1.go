@@ -1,33 +1,95 @@
 package p1
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"math"
+	"orderbook-pathfinder/internal/decimal"
+	"orderbook-pathfinder/internal/fees"
+	"orderbook-pathfinder/internal/route"
 	"os"
 	"strconv"
 	"strings"
 )
 
 type TradingPair struct {
-	Base  string
-	Quote string
-	Ask   float64
-	Bid   float64
+	Base   string
+	Quote  string
+	Ask    float64
+	Bid    float64
+	Venue  string
+	FeeBps float64 // taker fee, buildGraph makes Ask/Bid fee-inclusive
+	// Optional exact Ask/Bid, derived from the floats when unset
+	ExactAsk decimal.Decimal
+	ExactBid decimal.Decimal
+	Inverted bool // set by buildGraph: the edge is the reverse of the pair Quote/Base
+	// Optional top-of-book sizes in the market's base, 0 when unknown. The reverse edge
+	// swaps them and keeps the unit, its AskSize is the pair's BidSize in the pair's Base.
+	AskSize float64
+	BidSize float64
 }
 
+// TradingRoute.Price is the product of the hop prices of Path; the log-space search is only
+// used to pick the route. Route lists the tokens of Path, from the token paid to the one received.
 type TradingRoute struct {
-	Route []string
-	Price float64
+	Route      []string
+	Price      float64
+	ExactPrice decimal.Decimal
+	Path       route.Route
 }
 
-type Graph map[string]map[string]TradingPair
+// Graph keeps one parallel edge per venue quoting the same token pair
+type Graph map[string]map[string][]TradingPair
 
-func FindOptimalTradingRoutes(baseCurrency, quoteCurrency string, pairs []TradingPair) (TradingRoute, TradingRoute) {
-	graph := buildGraph(pairs)
-	bestAskRoute := findBestRoute(graph, baseCurrency, quoteCurrency, true)
-	bestBidRoute := findBestRoute(graph, baseCurrency, quoteCurrency, false)
-	return bestAskRoute, bestBidRoute
+// ApplyFees fills FeeBps from model for every pair that has no explicit fee
+func ApplyFees(pairs []TradingPair, model fees.Model) []TradingPair {
+	withFees := make([]TradingPair, len(pairs))
+	for i, pair := range pairs {
+		if pair.FeeBps == 0 {
+			pair.FeeBps = model.TakerFeeBps(pair.Venue, pair.Base, pair.Quote)
+		}
+		withFees[i] = pair
+	}
+	return withFees
+}
+
+func FindOptimalTradingRoutes(baseCurrency, quoteCurrency string, pairs []TradingPair) (TradingRoute, TradingRoute, error) {
+	return FindOptimalTradingRoutesInGraph(buildGraph(pairs), baseCurrency, quoteCurrency)
+}
+
+// BuildGraph lets callers build the graph once and reuse it with FindOptimalTradingRoutesInGraph
+func BuildGraph(pairs []TradingPair) Graph {
+	return buildGraph(pairs)
+}
+
+func FindOptimalTradingRoutesInGraph(graph Graph, baseCurrency, quoteCurrency string) (TradingRoute, TradingRoute, error) {
+	return FindOptimalTradingRoutesWithSolver(graph, baseCurrency, quoteCurrency, DefaultSolver)
+}
+
+// FindOptimalTradingRoutesWithSolver is FindOptimalTradingRoutesInGraph with the search picked
+// by the caller, see Solvers
+func FindOptimalTradingRoutesWithSolver(graph Graph, baseCurrency, quoteCurrency string, solver Solver) (TradingRoute, TradingRoute, error) {
+	bestAskRoute, askCycle, err := bestRoute(solver, graph, baseCurrency, quoteCurrency, true)
+	if err != nil {
+		return TradingRoute{}, TradingRoute{}, err
+	}
+	bestBidRoute, bidCycle, err := bestRoute(solver, graph, baseCurrency, quoteCurrency, false)
+	if err != nil {
+		return TradingRoute{}, TradingRoute{}, err
+	}
+	// A cycle touching a route is reachable from the base, every pair trading both ways. Its
+	// bids are a negative cycle of the bid search and, reversed, of the ask search.
+	if !askCycle && !bidCycle {
+		return bestAskRoute, bestBidRoute, nil
+	}
+	if cycles := contaminatingCycles(detectArbitrageCycles(graph), bestAskRoute, bestBidRoute); len(cycles) > 0 {
+		return bestAskRoute, bestBidRoute, &ArbitrageError{
+			Base:   baseCurrency,
+			Quote:  quoteCurrency,
+			Cycles: cycles,
+		}
+	}
+	return bestAskRoute, bestBidRoute, nil
 }
 
 func buildGraph(pairs []TradingPair) Graph {
@@ -35,26 +97,39 @@ func buildGraph(pairs []TradingPair) Graph {
 
 	for _, pair := range pairs {
 		if graph[pair.Base] == nil {
-			graph[pair.Base] = make(map[string]TradingPair)
+			graph[pair.Base] = make(map[string][]TradingPair)
 		}
 		if graph[pair.Quote] == nil {
-			graph[pair.Quote] = make(map[string]TradingPair)
+			graph[pair.Quote] = make(map[string][]TradingPair)
 		}
 
-		graph[pair.Base][pair.Quote] = pair
+		pair.ExactAsk = fees.ExactAskPrice(exactOrFloat(pair.ExactAsk, pair.Ask), pair.FeeBps)
+		pair.ExactBid = fees.ExactBidPrice(exactOrFloat(pair.ExactBid, pair.Bid), pair.FeeBps)
+		pair.Ask = fees.AskPrice(pair.Ask, pair.FeeBps)
+		pair.Bid = fees.BidPrice(pair.Bid, pair.FeeBps)
+		graph[pair.Base][pair.Quote] = append(graph[pair.Base][pair.Quote], pair)
 		reversePair := TradingPair{
-			Base:  pair.Quote,
-			Quote: pair.Base,
-			Ask:   1.0 / pair.Bid,
-			Bid:   1.0 / pair.Ask,
+			Base:     pair.Quote,
+			Quote:    pair.Base,
+			Ask:      1.0 / pair.Bid,
+			Bid:      1.0 / pair.Ask,
+			Venue:    pair.Venue,
+			FeeBps:   pair.FeeBps,
+			ExactAsk: exactInverse(pair.ExactBid),
+			ExactBid: exactInverse(pair.ExactAsk),
+			Inverted: true,
+			AskSize:  pair.BidSize,
+			BidSize:  pair.AskSize,
 		}
-		graph[pair.Quote][pair.Base] = reversePair
+		graph[pair.Quote][pair.Base] = append(graph[pair.Quote][pair.Base], reversePair)
 	}
 	// // Visualize the trading graph in a readable format
 	// fmt.Println("Trading Graph Visualization:")
 	// for base, neighbors := range graph {
-	// 	for quote, pair := range neighbors {
-	// 		fmt.Printf("%s -> %s | Ask: %.8f, Bid: %.8f\n", base, quote, pair.Ask, pair.Bid)
+	// 	for quote, edges := range neighbors {
+	// 		for _, pair := range edges {
+	// 			fmt.Printf("%s -> %s (%s) | Ask: %.8f, Bid: %.8f\n", base, quote, pair.Venue, pair.Ask, pair.Bid)
+	// 		}
 	// 	}
 	// }
 	// fmt.Println(strings.Repeat("-", 50))
@@ -62,71 +137,35 @@ func buildGraph(pairs []TradingPair) Graph {
 	return graph
 }
 
-func findBestRoute(graph Graph, start, end string, isAsk bool) TradingRoute {
-	return bellmanFordWithLog(graph, start, end, isAsk)
+func exactOrFloat(exact decimal.Decimal, f float64) decimal.Decimal {
+	if exact.IsSet() {
+		return exact
+	}
+	return decimal.FromFloat(f)
 }
 
-func dijkstraWithMultiplication(graph Graph, start, end string, isAsk bool) TradingRoute {
-	distances := make(map[string]float64)
-	tracer := make(map[string]string)
-	visited := make(map[string]bool)
-	for node := range graph {
-		distances[node] = math.Inf(1)
-	}
-	distances[start] = 1.0
-
-	// Main Dijkstra loop
-	for len(visited) < len(graph) {
-		var current string
-		minDist := math.Inf(1)
-		for node := range graph {
-			if !visited[node] && distances[node] < minDist {
-				minDist = distances[node]
-				current = node
-			}
-		}
-		visited[current] = true
-		if current == end || current == "" {
-			break
-		}
-		for neighbor, pair := range graph[current] {
-			if visited[neighbor] {
-				continue
-			}
-			var weight float64
-			if isAsk {
-				weight = pair.Ask
-			} else {
-				weight = 1.0 / pair.Bid
-			}
-			newDist := distances[current] * weight
-			if newDist < distances[neighbor] {
-				distances[neighbor] = newDist
-				tracer[neighbor] = current
-			}
-		}
+// NOTE: a zero price has no inverse, the float side already turns it into +Inf
+func exactInverse(d decimal.Decimal) decimal.Decimal {
+	if d.Sign() == 0 {
+		return decimal.Decimal{}
 	}
+	return d.Inv()
+}
 
-	// Reconstruct path
-	if distances[end] == math.Inf(1) {
-		return TradingRoute{
-			Route: []string{},
-			Price: 0,
+// bestEdge picks the venue quoting the best price for the side among parallel edges
+func bestEdge(edges []TradingPair, isAsk bool) TradingPair {
+	best := edges[0]
+	for _, pair := range edges[1:] {
+		if (isAsk && pair.Ask < best.Ask) || (!isAsk && pair.Bid > best.Bid) {
+			best = pair
 		}
 	}
-	path := []string{}
-	current := end
-	for current != "" {
-		path = append([]string{current}, path...)
-		current = tracer[current]
-	}
-	return TradingRoute{
-		Route: path,
-		Price: distances[end],
-	}
+	return best
 }
 
-func bellmanFordWithLog(graph Graph, start, end string, isAsk bool) TradingRoute {
+// bellmanFordWithLog also reports whether a last pass still relaxes an edge, i.e. whether a
+// negative cycle is reachable from start
+func bellmanFordWithLog(graph Graph, start, end string, isAsk bool) (TradingRoute, bool) {
 	distances := make(map[string]float64)
 	tracer := make(map[string]string)
 	for node := range graph {
@@ -134,9 +173,11 @@ func bellmanFordWithLog(graph Graph, start, end string, isAsk bool) TradingRoute
 	}
 	distances[start] = 0
 
-	for i := 0; i < len(graph)-1; i++ {
+	relax := func() bool {
+		relaxed := false
 		for u := range graph {
-			for v, pair := range graph[u] {
+			for v, edges := range graph[u] {
+				pair := bestEdge(edges, isAsk)
 				var weight float64
 				if isAsk {
 					weight = pair.Ask
@@ -147,30 +188,23 @@ func bellmanFordWithLog(graph Graph, start, end string, isAsk bool) TradingRoute
 				if distances[u] != math.Inf(1) && distances[u]+logWeight < distances[v] {
 					distances[v] = distances[u] + logWeight
 					tracer[v] = u
+					relaxed = true
 				}
 			}
 		}
+		return relaxed
 	}
-
-	// Check for negative cycles
-	for u := range graph {
-		for v, pair := range graph[u] {
-			var weight float64
-			if isAsk {
-				weight = pair.Ask
-			} else {
-				weight = 1.0 / pair.Bid
-			}
-			logWeight := math.Log(weight)
-
-			if distances[u] != math.Inf(1) && distances[u]+logWeight < distances[v] {
-				fmt.Printf("Warning: Negative cycle detected involving %s -> %s\n", u, v)
-			}
-		}
+	for i := 0; i < len(graph)-1; i++ {
+		relax()
 	}
 
-	// Reconstruct path
-	if distances[end] == math.Inf(1) {
+	// NOTE: negative cycles are reported by detectArbitrageCycles, the route below may loop through one
+	return tracedRoute(graph, tracer, distances, end, isAsk), relax()
+}
+
+// tracedRoute follows tracer back from end and prices the path from the hops themselves
+func tracedRoute(graph Graph, tracer map[string]string, distances map[string]float64, end string, isAsk bool) TradingRoute {
+	if distance, ok := distances[end]; !ok || distance == math.Inf(1) {
 		return TradingRoute{
 			Route: []string{},
 			Price: 0,
@@ -185,26 +219,61 @@ func bellmanFordWithLog(graph Graph, start, end string, isAsk bool) TradingRoute
 		pathLength++
 	}
 
-	var finalPrice float64
+	return pathRoute(graph, path, isAsk)
+}
+
+// pathRoute prices the best edge of each hop of path, given in search order
+// NOTE: price from the hops themselves, exp(distance) drifts in the last printed decimals
+func pathRoute(graph Graph, path []string, isAsk bool) TradingRoute {
+	var hops []route.Hop
+	for i := 0; i < len(path)-1; i++ {
+		hops = append(hops, searchHop(bestEdge(graph[path[i]][path[i+1]], isAsk), isAsk))
+	}
+	return tradingRoute(hops, isAsk)
+}
+
+// searchHop is the hop of an edge in search order (start->end) with the rate the side uses
+func searchHop(edge TradingPair, isAsk bool) route.Hop {
+	price, exactPrice, size := edge.Bid, edge.ExactBid, edge.BidSize
 	if isAsk {
-		finalPrice = math.Exp(distances[end])
-		// NOTE: Reverse the path to get the correct route
-		for i := 0; i < len(path)/2; i++ {
-			path[i], path[len(path)-i-1] = path[len(path)-i-1], path[i]
-		}
-		return TradingRoute{
-			Route: path,
-			Price: finalPrice,
-		}
-	} else {
-		finalPrice = math.Exp(-distances[end])
-		return TradingRoute{
-			Route: path,
-			Price: finalPrice,
-		}
+		price, exactPrice, size = edge.Ask, edge.ExactAsk, edge.AskSize
+	}
+	base, quote := edge.Base, edge.Quote
+	if edge.Inverted {
+		base, quote = quote, base
+	}
+	return route.Hop{
+		From:       edge.Base,
+		To:         edge.Quote,
+		Base:       base,
+		Quote:      quote,
+		Venue:      edge.Venue,
+		FeeBps:     edge.FeeBps,
+		Price:      price,
+		ExactPrice: exactPrice,
+		Size:       size,
+	}
+}
+
+// tradingRoute orients the hops of a search into execution order: asks are reported
+// quote->base, bids base->quote
+func tradingRoute(hops []route.Hop, isAsk bool) TradingRoute {
+	path := route.New(route.DirectionOf(isAsk), hops)
+	return TradingRoute{
+		Route:      path.Tokens(),
+		Price:      path.Price(),
+		ExactPrice: path.ExactPrice(),
+		Path:       path,
 	}
 }
 
+func formatVenue(venue string) string {
+	if venue == "" {
+		return ""
+	}
+	return " on " + venue
+}
+
 func formatRoute(route []string) string {
 	return strings.Join(route, "->")
 }
@@ -214,133 +283,128 @@ func printOutput(bestBidRoute, bestAskRoute TradingRoute) {
 	fmt.Println(formatRoute(bestAskRoute.Route))
 
 	// Best ask price (buying base currency)
-	fmt.Printf("%.8f\n", bestAskRoute.Price)
+	fmt.Println(bestAskRoute.ExactPrice.StringFixed(8))
 
 	// Best bid route (selling base currency)
 	fmt.Println(formatRoute(bestBidRoute.Route))
 
 	// Best bid price (selling base currency)
-	fmt.Printf("%.8f\n", bestBidRoute.Price)
-}
+	fmt.Println(bestBidRoute.ExactPrice.StringFixed(8))
 
-func runTestCase(input string) {
-	lines := strings.Split(strings.TrimSpace(input), "\n")
-	if len(lines) < 2 {
-		fmt.Printf("Invalid test case format: not enough lines\n")
-		return
-	}
-
-	// Parse first line
-	parts := strings.Fields(lines[0])
-	if len(parts) < 2 {
-		fmt.Printf("Invalid test case format: first line should have 2 currencies\n")
-		return
-	}
-	baseCurrency := parts[0]
-	quoteCurrency := parts[1]
+	printRouteHops("ASK", bestAskRoute)
+	printRouteHops("BID", bestBidRoute)
+}
 
-	n, err := strconv.Atoi(lines[1])
-	if err != nil {
-		fmt.Printf("Invalid test case format: second line should be a number\n")
-		return
+// printRankedRoutes prints the fallbacks of a "routes <k>" test case, the best one is already printed
+func printRankedRoutes(side string, routes []TradingRoute) {
+	for i := 1; i < len(routes); i++ {
+		fmt.Printf("%s route %d: %s %s\n", side, i+1, formatRoute(routes[i].Route), routes[i].ExactPrice.StringFixed(8))
 	}
+}
 
-	var pairs []TradingPair
-	for i := 0; i < n; i++ {
-		line := lines[2+i]
-		parts := strings.Fields(line)
-
-		if len(parts) < 4 {
-			fmt.Printf("Invalid trading pair format at line %d: %s\n", 2+i+1, line)
-			continue
+// NOTE: only hops with a venue or a fee are printed so plain test cases keep the original output
+func printRouteHops(side string, tradingRoute TradingRoute) {
+	for _, hop := range tradingRoute.Path.Hops {
+		if hop.Venue != "" || hop.FeeBps > 0 {
+			fmt.Printf("%s hop %s->%s%s: %s %s/%s, %.2f bps fee (price %.8f)\n", side, hop.From, hop.To, formatVenue(hop.Venue), hop.Side, hop.Base, hop.Quote, hop.FeeBps, hop.Price)
 		}
+	}
+}
 
-		base := parts[0]
-		quote := parts[1]
-		ask, _ := strconv.ParseFloat(parts[2], 64)
-		bid, _ := strconv.ParseFloat(parts[3], 64)
-
-		pair := TradingPair{
-			Base:  base,
-			Quote: quote,
-			Ask:   ask,
-			Bid:   bid,
+// parsePairOptions reads the optional trailing "fee=<bps>", "venue=<name>", "ask-size=<base>"
+// and "bid-size=<base>" fields of a pair line into pair
+func parsePairOptions(options []string, pair *TradingPair) error {
+	for _, option := range options {
+		if bps, ok, err := fees.ParseBps(option); ok {
+			if err != nil {
+				return err
+			}
+			pair.FeeBps = bps
+		} else if name, ok := strings.CutPrefix(option, "venue="); ok {
+			pair.Venue = name
+		} else if name, value, ok := strings.Cut(option, "="); ok && (name == "ask-size" || name == "bid-size") {
+			size, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(size > 0) || math.IsInf(size, 0) {
+				return fmt.Errorf("invalid %s: %s", name, value)
+			}
+			if name == "ask-size" {
+				pair.AskSize = size
+			} else {
+				pair.BidSize = size
+			}
+		} else {
+			return fmt.Errorf("unknown pair option: %s", option)
 		}
-		pairs = append(pairs, pair)
 	}
+	return nil
+}
 
-	// Find optimal routes
-	bestAskRoute, bestBidRoute := FindOptimalTradingRoutes(baseCurrency, quoteCurrency, pairs)
+// runTestCase prints the routes of a scenario and reports whether its expectations hold
+func runTestCase(scenario Scenario) bool {
+	result, err := Solve(scenario)
 
 	// Print results
-	fmt.Printf("Test Case: %s -> %s\n", baseCurrency, quoteCurrency)
-	printOutput(bestBidRoute, bestAskRoute)
+	fmt.Printf("Test Case: %s -> %s\n", scenario.Base, scenario.Quote)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		var arbitrageErr *ArbitrageError
+		if errors.As(err, &arbitrageErr) {
+			for _, cycle := range arbitrageErr.Cycles {
+				fmt.Printf("  %s (x%.8f)\n", formatRoute(cycle.Tokens), cycle.Multiplier)
+			}
+		}
+	} else {
+		printOutput(result.Bid, result.Ask)
+		printRankedRoutes("ASK", result.AskRoutes)
+		printRankedRoutes("BID", result.BidRoutes)
+	}
+	mismatches := scenario.Check(result, err)
+	for _, mismatch := range mismatches {
+		fmt.Printf("FAIL %s\n", mismatch)
+	}
 	fmt.Println("---")
+	return len(mismatches) == 0
 }
 
-func RunTestCasesFromFile(filename string) {
+// RunTestCasesFromFile runs every test case of filename and returns false when any of them
+// fails its expectations. Cases without expectations only fail on an error.
+func RunTestCasesFromFile(filename string) bool {
 	file, err := os.Open(filename)
 	if err != nil {
 		fmt.Printf("Error opening file: %v\n", err)
-		return
+		return false
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	var currentTestCase strings.Builder
+	inputs, err := splitScenarios(file)
+	if err != nil {
+		fmt.Printf("Error reading file: %v\n", err)
+		return false
+	}
 	testCaseCount := 0
 	passedCount := 0
+	uncheckedCount := 0
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(strings.TrimSpace(line), "#") || strings.TrimSpace(line) == "" {
+	for _, input := range inputs {
+		testCaseCount++
+		fmt.Printf("=== Test Case %d ===\n", testCaseCount)
+		scenario, err := parseScenario(input)
+		if err != nil {
+			fmt.Printf("Invalid test case: %v\n", err)
+			fmt.Println("---")
 			continue
 		}
-
-		// Check if this is a new test case (two currencies separated by space)
-		parts := strings.Fields(line)
-		if len(parts) == 2 {
-			isCurrencyPair := true
-			for _, part := range parts {
-				for _, char := range part {
-					if char < 'A' || char > 'Z' {
-						isCurrencyPair = false
-						break
-					}
-				}
-				if !isCurrencyPair {
-					break
-				}
-			}
-
-			if isCurrencyPair {
-				// Process previous test case if exists
-				if currentTestCase.Len() > 0 {
-					testCaseCount++
-					fmt.Printf("=== Test Case %d ===\n", testCaseCount)
-					runTestCase(currentTestCase.String())
-					passedCount++
-				}
-
-				// Start new test case
-				currentTestCase.Reset()
-				currentTestCase.WriteString(line)
-				currentTestCase.WriteString("\n")
-				continue
+		if runTestCase(scenario) {
+			passedCount++
+			if len(scenario.Expect) == 0 {
+				uncheckedCount++
 			}
 		}
-		currentTestCase.WriteString(line)
-		currentTestCase.WriteString("\n")
-	}
-	if currentTestCase.Len() > 0 {
-		testCaseCount++
-		fmt.Printf("=== Test Case %d ===\n", testCaseCount)
-		runTestCase(currentTestCase.String())
-		passedCount++
 	}
 
 	fmt.Printf("\n=== SUMMARY ===\n")
 	fmt.Printf("Total test cases: %d\n", testCaseCount)
-	fmt.Printf("Passed: %d\n", passedCount)
+	fmt.Printf("Passed: %d (%d without expectations)\n", passedCount, uncheckedCount)
 	fmt.Printf("Failed: %d\n", testCaseCount-passedCount)
+	return passedCount == testCaseCount
 }
@@ -0,0 +1,106 @@
+package p1
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"orderbook-pathfinder/internal/route"
+	"orderbook-pathfinder/internal/route/routetest"
+	"testing"
+)
+
+// randomMarket spreads routetest's random markets by up to 2%
+func randomMarket(tokens int, r *rand.Rand) []TradingPair {
+	var pairs []TradingPair
+	for _, market := range routetest.RandomMarkets(tokens, r) {
+		spread := 0.001 + 0.02*r.Float64()
+		pairs = append(pairs, TradingPair{
+			Base:   routetest.Token(market.Base),
+			Quote:  routetest.Token(market.Quote),
+			Ask:    market.Mid * (1 + spread),
+			Bid:    market.Mid * (1 - spread),
+			Venue:  market.Venue,
+			FeeBps: market.FeeBps,
+		})
+	}
+	return pairs
+}
+
+// checkMarketPrices checks a route's hops against the markets they name, and its exact price
+func checkMarketPrices(t *testing.T, pairs []TradingPair, base, quote string, tradingRoute TradingRoute) {
+	t.Helper()
+	routetest.CheckPrices(t, tradingRoute.Path, base, quote, tradingRoute.Price, func(hop route.Hop) ([]float64, float64, bool) {
+		for _, pair := range pairs {
+			if pair.Base == hop.Base && pair.Quote == hop.Quote && pair.Venue == hop.Venue {
+				if hop.Side == "sell" {
+					return []float64{pair.Bid}, pair.FeeBps, true
+				}
+				return []float64{pair.Ask}, pair.FeeBps, true
+			}
+		}
+		return nil, 0, false
+	})
+	if exact := tradingRoute.ExactPrice.Float64(); math.Abs(exact-tradingRoute.Price) > 1e-9*tradingRoute.Price {
+		t.Fatalf("%s %s: exact price %.12g, reported %.12g", tradingRoute.Path.Direction, tradingRoute.Path, exact, tradingRoute.Price)
+	}
+}
+
+func TestRoutePricesAreTheProductOfTheirMarkets(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		pairs := randomMarket(3+r.Intn(5), r)
+		graph := buildGraph(pairs)
+		base, quote := "T0", "T1"
+		if graph[base] == nil || graph[quote] == nil {
+			continue
+		}
+		for _, solver := range Solvers() {
+			askRoute, bidRoute, err := FindOptimalTradingRoutesWithSolver(graph, base, quote, solver)
+			if errors.Is(err, ErrSolverNotApplicable) || len(askRoute.Route) == 0 {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("case %d %s: %v", i, solver, err)
+			}
+			checkMarketPrices(t, pairs, base, quote, askRoute)
+			checkMarketPrices(t, pairs, base, quote, bidRoute)
+		}
+
+		askRoutes, bidRoutes, err := FindKBestRoutes(base, quote, pairs, 5)
+		if err != nil {
+			t.Fatalf("case %d k-best: %v", i, err)
+		}
+		for _, tradingRoute := range append(askRoutes, bidRoutes...) {
+			checkMarketPrices(t, pairs, base, quote, tradingRoute)
+		}
+	}
+}
+
+func TestArbitrageCheckRunsWhenACycleTouchesARoute(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		pairs := randomMarket(3+r.Intn(5), r)
+		// Cross one market so its bid beats its ask, about half the cases
+		if len(pairs) > 0 && r.Intn(2) == 0 {
+			crossed := &pairs[r.Intn(len(pairs))]
+			crossed.Ask, crossed.Bid = crossed.Bid, crossed.Ask*1.01
+			crossed.FeeBps = 0
+		}
+		graph := buildGraph(pairs)
+		base, quote := "T0", "T1"
+		if graph[base] == nil || graph[quote] == nil {
+			continue
+		}
+		for _, solver := range Solvers() {
+			askRoute, bidRoute, err := FindOptimalTradingRoutesWithSolver(graph, base, quote, solver)
+			if errors.Is(err, ErrSolverNotApplicable) {
+				continue
+			}
+			cycles := contaminatingCycles(detectArbitrageCycles(graph), askRoute, bidRoute)
+			var arbitrage *ArbitrageError
+			if errors.As(err, &arbitrage) != (len(cycles) > 0) {
+				t.Fatalf("case %d %s: got %v, full check finds %d cycles on the routes", i, solver, err, len(cycles))
+			}
+		}
+	}
+}
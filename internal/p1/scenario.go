@@ -0,0 +1,277 @@
+package p1
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrUnknownToken   = errors.New("unknown token")
+	ErrNoRoute        = errors.New("no route")
+	ErrMalformedLevel = errors.New("malformed level")
+	ErrInvalidPrice   = errors.New("negative or zero price")
+)
+
+// ParseError points at the input line that could not be parsed
+type ParseError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v: %q", e.Line, e.Err, e.Text)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Scenario is one test case: the pairs to search and the base/quote to price
+type Scenario struct {
+	Base        string
+	Quote       string
+	Pairs       []TradingPair
+	Constraints RouteConstraints
+	Solver      Solver // nil searches with DefaultSolver
+	Routes      int    // k of FindKBestRoutes, 0 searches the best route only
+	Expect      []Expectation
+	Line        int
+}
+
+type Result struct {
+	Ask       TradingRoute
+	Bid       TradingRoute
+	AskRoutes []TradingRoute // best first when Scenario.Routes is set, Ask is the first one
+	BidRoutes []TradingRoute
+}
+
+// scenarioInput holds the non-comment lines of one test case with their line numbers
+type scenarioInput struct {
+	lines   []string
+	lineNos []int
+}
+
+// ParseScenario parses every test case of r. Malformed test cases are skipped and
+// their *ParseError joined into the returned error, the others are still returned.
+func ParseScenario(r io.Reader) ([]Scenario, error) {
+	inputs, err := splitScenarios(r)
+	if err != nil {
+		return nil, err
+	}
+	var scenarios []Scenario
+	var errs []error
+	for _, input := range inputs {
+		scenario, err := parseScenario(input)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		scenarios = append(scenarios, scenario)
+	}
+	return scenarios, errors.Join(errs...)
+}
+
+// Solve finds the best ask and bid routes of a scenario. An *ArbitrageError is returned
+// together with the (unreliable) routes, ErrNoRoute when quote can't be reached from base.
+func Solve(scenario Scenario) (Result, error) {
+	graph := buildGraph(scenario.Pairs)
+	for _, token := range []string{scenario.Base, scenario.Quote} {
+		if _, ok := graph[token]; !ok {
+			return Result{}, fmt.Errorf("%w: %s", ErrUnknownToken, token)
+		}
+	}
+	if !scenario.Constraints.IsZero() {
+		bestAskRoute, bestBidRoute, err := FindConstrainedTradingRoutesInGraph(graph, scenario.Base, scenario.Quote, scenario.Constraints)
+		return Result{Ask: bestAskRoute, Bid: bestBidRoute}, err
+	}
+	if scenario.Routes > 0 {
+		return solveKBest(graph, scenario)
+	}
+	solver := scenario.Solver
+	if solver == nil {
+		solver = DefaultSolver
+	}
+	bestAskRoute, bestBidRoute, err := FindOptimalTradingRoutesWithSolver(graph, scenario.Base, scenario.Quote, solver)
+	result := Result{Ask: bestAskRoute, Bid: bestBidRoute}
+	if err != nil {
+		return result, err
+	}
+	if len(bestAskRoute.Route) == 0 || len(bestBidRoute.Route) == 0 {
+		return result, fmt.Errorf("%w: %s -> %s", ErrNoRoute, scenario.Base, scenario.Quote)
+	}
+	return result, nil
+}
+
+func solveKBest(graph Graph, scenario Scenario) (Result, error) {
+	askRoutes, bidRoutes, err := findKBestRoutesInGraph(graph, scenario.Base, scenario.Quote, scenario.Routes)
+	result := Result{AskRoutes: askRoutes, BidRoutes: bidRoutes}
+	if len(askRoutes) > 0 {
+		result.Ask = askRoutes[0]
+	}
+	if len(bidRoutes) > 0 {
+		result.Bid = bidRoutes[0]
+	}
+	if err != nil {
+		return result, err
+	}
+	if len(askRoutes) == 0 || len(bidRoutes) == 0 {
+		return result, fmt.Errorf("%w: %s -> %s", ErrNoRoute, scenario.Base, scenario.Quote)
+	}
+	return result, nil
+}
+
+func splitScenarios(r io.Reader) ([]scenarioInput, error) {
+	scanner := bufio.NewScanner(r)
+	var inputs []scenarioInput
+	var current scenarioInput
+	lineNo := 0
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNo++
+		if strings.HasPrefix(strings.TrimSpace(line), "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// A new test case starts with two currencies separated by space
+		if isCurrencyPair(line) && len(current.lines) > 0 {
+			inputs = append(inputs, current)
+			current = scenarioInput{}
+		}
+		current.lines = append(current.lines, line)
+		current.lineNos = append(current.lineNos, lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current.lines) > 0 {
+		inputs = append(inputs, current)
+	}
+	return inputs, nil
+}
+
+func isCurrencyPair(line string) bool {
+	parts := strings.Fields(line)
+	return len(parts) == 2 && parts[0] != "expect" && parts[0] != "solver" && parts[0] != "routes" && isSymbol(parts[0]) && isSymbol(parts[1])
+}
+
+// isSymbol accepts exchange tickers such as KNC, 1INCH or USDC.e: letters, digits,
+// '.', '-' and '_' with at least one letter
+func isSymbol(symbol string) bool {
+	hasLetter := false
+	for _, char := range symbol {
+		switch {
+		case unicode.IsLetter(char):
+			hasLetter = true
+		case unicode.IsDigit(char) || char == '.' || char == '-' || char == '_':
+		default:
+			return false
+		}
+	}
+	return hasLetter
+}
+
+func parseScenario(input scenarioInput) (Scenario, error) {
+	malformed := func(i int, err error) error {
+		return &ParseError{Line: input.lineNos[i], Text: input.lines[i], Err: err}
+	}
+
+	parts := strings.Fields(input.lines[0])
+	if len(parts) != 2 {
+		return Scenario{}, malformed(0, errors.New("first line should have 2 currencies"))
+	}
+	scenario := Scenario{Base: parts[0], Quote: parts[1], Line: input.lineNos[0]}
+	if len(input.lines) < 2 {
+		return Scenario{}, malformed(0, errors.New("missing pair count"))
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(input.lines[1]))
+	if err != nil || n < 0 {
+		return Scenario{}, malformed(1, errors.New("second line should be a pair count"))
+	}
+	if len(input.lines) < 2+n {
+		return Scenario{}, malformed(len(input.lines)-1, fmt.Errorf("expected %d pairs, got %d", n, len(input.lines)-2))
+	}
+
+	for i := 2; i < 2+n; i++ {
+		pair, err := parsePair(input.lines[i])
+		if err != nil {
+			return Scenario{}, malformed(i, err)
+		}
+		scenario.Pairs = append(scenario.Pairs, pair)
+	}
+	for i := 2 + n; i < len(input.lines); i++ {
+		if strings.HasPrefix(input.lines[i], "constraint") {
+			if err := parseConstraint(input.lines[i], &scenario.Constraints); err != nil {
+				return Scenario{}, malformed(i, err)
+			}
+			continue
+		}
+		if name, ok := strings.CutPrefix(input.lines[i], "solver "); ok {
+			solver, err := ParseSolver(strings.TrimSpace(name))
+			if err != nil {
+				return Scenario{}, malformed(i, err)
+			}
+			scenario.Solver = solver
+			continue
+		}
+		if count, ok := strings.CutPrefix(input.lines[i], "routes "); ok {
+			k, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil || k < 1 {
+				return Scenario{}, malformed(i, fmt.Errorf("invalid route count: %s", count))
+			}
+			scenario.Routes = k
+			continue
+		}
+		expectation, err := parseExpectation(input.lines[i])
+		if err != nil {
+			return Scenario{}, malformed(i, err)
+		}
+		expectation.Line = input.lineNos[i]
+		scenario.Expect = append(scenario.Expect, expectation)
+	}
+	if scenario.Routes > 0 && (!scenario.Constraints.IsZero() || scenario.Solver != nil) {
+		return Scenario{}, malformed(0, errors.New("routes can't be combined with constraints or a solver"))
+	}
+	for _, expectation := range scenario.Expect {
+		if expectation.Rank > 1 && scenario.Routes < expectation.Rank {
+			return Scenario{}, &ParseError{Line: expectation.Line, Text: fmt.Sprintf("rank=%d", expectation.Rank), Err: errors.New("rank beyond the route count")}
+		}
+	}
+	return scenario, nil
+}
+
+// parsePair parses "BASE QUOTE ASK BID [venue=<name>] [fee=<bps>] [ask-size=<base>] [bid-size=<base>]"
+func parsePair(line string) (TradingPair, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 4 {
+		return TradingPair{}, fmt.Errorf("%w: expected BASE QUOTE ASK BID", ErrMalformedLevel)
+	}
+	ask, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return TradingPair{}, fmt.Errorf("%w: ask %q", ErrMalformedLevel, parts[2])
+	}
+	bid, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil {
+		return TradingPair{}, fmt.Errorf("%w: bid %q", ErrMalformedLevel, parts[3])
+	}
+	if !(ask > 0) || !(bid > 0) || math.IsInf(ask, 0) || math.IsInf(bid, 0) {
+		return TradingPair{}, fmt.Errorf("%w: ask %s, bid %s", ErrInvalidPrice, parts[2], parts[3])
+	}
+	pair := TradingPair{
+		Base:  parts[0],
+		Quote: parts[1],
+		Ask:   ask,
+		Bid:   bid,
+	}
+	if err := parsePairOptions(parts[4:], &pair); err != nil {
+		return TradingPair{}, fmt.Errorf("%w: %v", ErrMalformedLevel, err)
+	}
+	return pair, nil
+}
@@ -0,0 +1,320 @@
+package p1
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrSolverNotApplicable is returned by a solver that can't price the graph correctly,
+// e.g. Dijkstra on a graph with an arbitrage cycle, which has no potentials
+var ErrSolverNotApplicable = errors.New("solver not applicable")
+
+// Solver finds the best route of one side from start to end in log space. Every solver
+// returns an empty route when end can't be reached and leaves arbitrage cycles to
+// detectArbitrageCycles.
+type Solver interface {
+	BestRoute(graph Graph, start, end string, isAsk bool) (TradingRoute, error)
+	String() string
+}
+
+var (
+	// BellmanFord relaxes every edge |V|-1 times, O(V·E)
+	BellmanFord Solver = bellmanFordSolver{}
+	// SPFA relaxes only the edges out of improved tokens and stops once nothing improves
+	SPFA Solver = spfaSolver{}
+	// Dijkstra reweights the edges with Johnson potentials, one SPFA run from every token, so
+	// rates below 1 are fine but a graph with an arbitrage cycle is not
+	Dijkstra Solver = dijkstraSolver{}
+	// FloydWarshall computes every pair at once, O(V³), only worth it when the matrix is reused
+	FloydWarshall Solver = floydWarshallSolver{}
+)
+
+// DefaultSolver is picked with BenchmarkSolvers: SPFA converges in a few passes on market graphs
+// where Bellman-Ford always runs |V|-1 of them
+var DefaultSolver = SPFA
+
+// Solvers lists every solver, DefaultSolver first
+func Solvers() []Solver {
+	return []Solver{SPFA, BellmanFord, Dijkstra, FloydWarshall}
+}
+
+// cycleReporter is a Solver whose search notices a negative cycle reachable from start, the
+// arbitrage check is skipped when neither side met one. Other solvers always get the check.
+type cycleReporter interface {
+	bestRouteOrCycle(graph Graph, start, end string, isAsk bool) (TradingRoute, bool, error)
+}
+
+// bestRoute searches one side with solver, cycle is false only when solver proved there is
+// no negative cycle reachable from start
+func bestRoute(solver Solver, graph Graph, start, end string, isAsk bool) (TradingRoute, bool, error) {
+	if reporter, ok := solver.(cycleReporter); ok {
+		return reporter.bestRouteOrCycle(graph, start, end, isAsk)
+	}
+	tradingRoute, err := solver.BestRoute(graph, start, end, isAsk)
+	return tradingRoute, true, err
+}
+
+// ParseSolver looks a solver up by name, case-insensitive
+func ParseSolver(name string) (Solver, error) {
+	for _, solver := range Solvers() {
+		if strings.EqualFold(solver.String(), name) {
+			return solver, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown solver: %s", name)
+}
+
+type bellmanFordSolver struct{}
+
+func (bellmanFordSolver) String() string { return "bellman-ford" }
+
+func (bellmanFordSolver) BestRoute(graph Graph, start, end string, isAsk bool) (TradingRoute, error) {
+	tradingRoute, _ := bellmanFordWithLog(graph, start, end, isAsk)
+	return tradingRoute, nil
+}
+
+func (bellmanFordSolver) bestRouteOrCycle(graph Graph, start, end string, isAsk bool) (TradingRoute, bool, error) {
+	tradingRoute, cycle := bellmanFordWithLog(graph, start, end, isAsk)
+	return tradingRoute, cycle, nil
+}
+
+type spfaSolver struct{}
+
+func (spfaSolver) String() string { return "spfa" }
+
+// BestRoute stops early when the queue drains. A token queued |V| times sits on a negative
+// cycle, the search stops there like Bellman-Ford would after its last pass.
+func (spfaSolver) BestRoute(graph Graph, start, end string, isAsk bool) (TradingRoute, error) {
+	tradingRoute, _, err := spfaSolver{}.bestRouteOrCycle(graph, start, end, isAsk)
+	return tradingRoute, err
+}
+
+func (spfaSolver) bestRouteOrCycle(graph Graph, start, end string, isAsk bool) (TradingRoute, bool, error) {
+	distances, tracer, cycle := spfa(graph, []string{start}, isAsk)
+	return tracedRoute(graph, tracer, distances, end, isAsk), cycle, nil
+}
+
+// spfa relaxes the edges out of improved tokens from every source at distance 0 until nothing
+// improves, or until a token is queued more than |V| times, which only a negative cycle does
+func spfa(graph Graph, sources []string, isAsk bool) (map[string]float64, map[string]string, bool) {
+	distances := make(map[string]float64, len(graph))
+	tracer := make(map[string]string)
+	queued := make(map[string]bool, len(graph))
+	enqueued := make(map[string]int, len(graph))
+	queue := make([]string, 0, len(sources))
+	for _, source := range sources {
+		distances[source] = 0
+		queued[source] = true
+		enqueued[source] = 1
+		queue = append(queue, source)
+	}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		queued[u] = false
+		for v, edges := range graph[u] {
+			distance := distances[u] + logWeight(bestEdge(edges, isAsk), isAsk)
+			if current, ok := distances[v]; ok && distance >= current {
+				continue
+			}
+			distances[v] = distance
+			tracer[v] = u
+			if queued[v] {
+				continue
+			}
+			if enqueued[v] > len(graph) {
+				return distances, tracer, true
+			}
+			queued[v] = true
+			enqueued[v]++
+			queue = append(queue, v)
+		}
+	}
+	return distances, tracer, false
+}
+
+type dijkstraSolver struct{}
+
+func (dijkstraSolver) String() string { return "dijkstra" }
+
+// BestRoute runs on the weights w(u,v) + h(u) - h(v), where h are the SPFA distances from a
+// virtual source tied at 0 to every token start reaches. They are never negative, so a settled
+// token stays right whatever the rates, and every path from start to end shifts by the same
+// h(start) - h(end).
+// NOTE: an arbitrage cycle start reaches leaves no potentials and returns ErrSolverNotApplicable
+func (dijkstraSolver) BestRoute(graph Graph, start, end string, isAsk bool) (TradingRoute, error) {
+	tradingRoute, _, err := dijkstraSolver{}.bestRouteOrCycle(graph, start, end, isAsk)
+	return tradingRoute, err
+}
+
+func (dijkstraSolver) bestRouteOrCycle(graph Graph, start, end string, isAsk bool) (TradingRoute, bool, error) {
+	potentials, _, cycle := spfa(graph, reachable(graph, start), isAsk)
+	if cycle {
+		return TradingRoute{}, true, fmt.Errorf("%w: dijkstra has no potentials on a graph with a negative cycle", ErrSolverNotApplicable)
+	}
+
+	distances := map[string]float64{start: 0}
+	tracer := make(map[string]string)
+	settled := make(map[string]bool)
+	frontier := &tokenHeap{{token: start}}
+	for frontier.Len() > 0 {
+		item := heap.Pop(frontier).(tokenDistance)
+		if settled[item.token] {
+			continue
+		}
+		settled[item.token] = true
+		if item.token == end {
+			break
+		}
+		for v, edges := range graph[item.token] {
+			if settled[v] {
+				continue
+			}
+			// NOTE: float noise can take a tight edge a few ulps below 0
+			weight := math.Max(0, logWeight(bestEdge(edges, isAsk), isAsk)+potentials[item.token]-potentials[v])
+			distance := item.distance + weight
+			if current, ok := distances[v]; !ok || distance < current {
+				distances[v] = distance
+				tracer[v] = item.token
+				heap.Push(frontier, tokenDistance{token: v, distance: distance})
+			}
+		}
+	}
+	return tracedRoute(graph, tracer, distances, end, isAsk), false, nil
+}
+
+// reachable lists the tokens a walk from start can reach, start first
+func reachable(graph Graph, start string) []string {
+	tokens := []string{start}
+	seen := map[string]bool{start: true}
+	for i := 0; i < len(tokens); i++ {
+		for _, v := range sortedTokens(graph[tokens[i]]) {
+			if !seen[v] {
+				seen[v] = true
+				tokens = append(tokens, v)
+			}
+		}
+	}
+	return tokens
+}
+
+type tokenDistance struct {
+	token    string
+	distance float64
+}
+
+// tokenHeap is a min-heap on distance, stale entries are skipped when popped
+type tokenHeap []tokenDistance
+
+func (h tokenHeap) Len() int           { return len(h) }
+func (h tokenHeap) Less(i, j int) bool { return h[i].distance < h[j].distance }
+func (h tokenHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *tokenHeap) Push(x any)        { *h = append(*h, x.(tokenDistance)) }
+func (h *tokenHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+type floydWarshallSolver struct{}
+
+func (floydWarshallSolver) String() string { return "floyd-warshall" }
+
+func (floydWarshallSolver) BestRoute(graph Graph, start, end string, isAsk bool) (TradingRoute, error) {
+	return newAllPairsPaths(graph, isAsk).route(graph, start, end, isAsk), nil
+}
+
+// bestRouteOrCycle reports any negative cycle of the graph: a token whose distance to itself
+// fell below 0
+func (floydWarshallSolver) bestRouteOrCycle(graph Graph, start, end string, isAsk bool) (TradingRoute, bool, error) {
+	paths := newAllPairsPaths(graph, isAsk)
+	cycle := false
+	for i := range paths.tokens {
+		cycle = cycle || paths.distances[i][i] < -arbitrageEpsilon
+	}
+	return paths.route(graph, start, end, isAsk), cycle, nil
+}
+
+// allPairsPaths holds the Floyd-Warshall distances of one side between every pair of tokens,
+// next[i][j] is the token after i on the best path to j, -1 when j can't be reached
+type allPairsPaths struct {
+	tokens    []string
+	index     map[string]int
+	distances [][]float64
+	next      [][]int
+}
+
+func newAllPairsPaths(graph Graph, isAsk bool) allPairsPaths {
+	tokens := sortedTokens(graph)
+	paths := allPairsPaths{
+		tokens:    tokens,
+		index:     make(map[string]int, len(tokens)),
+		distances: make([][]float64, len(tokens)),
+		next:      make([][]int, len(tokens)),
+	}
+	for i, token := range tokens {
+		paths.index[token] = i
+	}
+	for i, u := range tokens {
+		paths.distances[i] = make([]float64, len(tokens))
+		paths.next[i] = make([]int, len(tokens))
+		for j := range tokens {
+			paths.distances[i][j] = math.Inf(1)
+			paths.next[i][j] = -1
+		}
+		paths.distances[i][i] = 0
+		paths.next[i][i] = i
+		for v, edges := range graph[u] {
+			j := paths.index[v]
+			if weight := logWeight(bestEdge(edges, isAsk), isAsk); weight < paths.distances[i][j] {
+				paths.distances[i][j] = weight
+				paths.next[i][j] = j
+			}
+		}
+	}
+	for k := range tokens {
+		distancesK := paths.distances[k]
+		for i := range tokens {
+			distanceIK := paths.distances[i][k]
+			if distanceIK == math.Inf(1) {
+				continue
+			}
+			distancesI, nextI := paths.distances[i], paths.next[i]
+			for j, distanceKJ := range distancesK {
+				if distance := distanceIK + distanceKJ; distance < distancesI[j] {
+					distancesI[j] = distance
+					nextI[j] = nextI[k]
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// path lists the tokens from start to end, nil when end can't be reached. A path through
+// an arbitrage cycle is cut after |V| tokens like tracedRoute does.
+func (p allPairsPaths) path(start, end string) []string {
+	i, ok := p.index[start]
+	j, ok2 := p.index[end]
+	if !ok || !ok2 || p.next[i][j] < 0 {
+		return nil
+	}
+	path := []string{start}
+	for i != j && len(path) < len(p.tokens) {
+		i = p.next[i][j]
+		path = append(path, p.tokens[i])
+	}
+	return path
+}
+
+func (p allPairsPaths) route(graph Graph, start, end string, isAsk bool) TradingRoute {
+	path := p.path(start, end)
+	if path == nil {
+		return TradingRoute{Route: []string{}, Price: 0}
+	}
+	return pathRoute(graph, path, isAsk)
+}
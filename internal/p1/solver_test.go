@@ -0,0 +1,108 @@
+package p1
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"orderbook-pathfinder/internal/route/routetest"
+	"strconv"
+	"testing"
+)
+
+// The largest graphs the slow solvers are benchmarked on: Floyd-Warshall is O(V³), Bellman-Ford
+// always runs |V|-1 passes over every edge, over a minute on 5000 tokens
+const (
+	floydWarshallMax = 500
+	bellmanFordMax   = 1000
+)
+
+// hubPairs is shaped like a market: every token trades against T0 and degree random others.
+// Mids derive from one value per token so the graph has no arbitrage, pegged graphs value every
+// token at 1 like a book of stablecoins.
+func hubPairs(tokens, degree int, pegged bool, r *rand.Rand) []TradingPair {
+	values := make([]float64, tokens)
+	for i := range values {
+		values[i] = 1
+		if !pegged {
+			values[i] = math.Exp(4 * (r.Float64() - 0.5))
+		}
+	}
+	quote := func(i, j int) TradingPair {
+		mid := values[i] / values[j]
+		spread := 0.0005 + 0.005*r.Float64()
+		return TradingPair{Base: routetest.Token(i), Quote: routetest.Token(j), Ask: mid * (1 + spread), Bid: mid * (1 - spread)}
+	}
+	seen := make(map[[2]int]bool)
+	var pairs []TradingPair
+	for i := 1; i < tokens; i++ {
+		pairs = append(pairs, quote(i, 0))
+		for d := 0; d < degree; d++ {
+			j := 1 + r.Intn(tokens-1)
+			key := [2]int{min(i, j), max(i, j)}
+			if j == i || seen[key] {
+				continue
+			}
+			seen[key] = true
+			pairs = append(pairs, quote(i, j))
+		}
+	}
+	return pairs
+}
+
+func graphName(tokens int, pegged bool) string {
+	if pegged {
+		return "pegged/tokens=" + strconv.Itoa(tokens)
+	}
+	return "market/tokens=" + strconv.Itoa(tokens)
+}
+
+func TestSolversAgree(t *testing.T) {
+	for _, tokens := range []int{10, 100, 300} {
+		for _, pegged := range []bool{false, true} {
+			graph := BuildGraph(hubPairs(tokens, 4, pegged, rand.New(rand.NewSource(1))))
+			base, quote := routetest.Token(1), routetest.Token(tokens-1)
+			wantAsk, wantBid, err := FindOptimalTradingRoutesWithSolver(graph, base, quote, BellmanFord)
+			if err != nil {
+				t.Fatalf("%s %s: %v", graphName(tokens, pegged), BellmanFord, err)
+			}
+			for _, solver := range Solvers() {
+				if solver == FloydWarshall && tokens > floydWarshallMax {
+					continue
+				}
+				ask, bid, err := FindOptimalTradingRoutesWithSolver(graph, base, quote, solver)
+				if errors.Is(err, ErrSolverNotApplicable) {
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s %s: %v", graphName(tokens, pegged), solver, err)
+				}
+				if math.Abs(ask.Price-wantAsk.Price) > 1e-9*wantAsk.Price || math.Abs(bid.Price-wantBid.Price) > 1e-9*wantBid.Price {
+					t.Errorf("%s %s: ask %.12f bid %.12f, %s finds %.12f %.12f",
+						graphName(tokens, pegged), solver, ask.Price, bid.Price, BellmanFord, wantAsk.Price, wantBid.Price)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkSolvers times every solver on hub graphs of 10 to 5000 tokens. Each run goes
+// through FindOptimalTradingRoutesWithSolver, both sides and the arbitrage check included.
+func BenchmarkSolvers(b *testing.B) {
+	for _, tokens := range []int{10, 100, 1000, 5000} {
+		for _, pegged := range []bool{false, true} {
+			graph := BuildGraph(hubPairs(tokens, 4, pegged, rand.New(rand.NewSource(1))))
+			base, quote := routetest.Token(1), routetest.Token(tokens-1)
+			for _, solver := range Solvers() {
+				if (solver == FloydWarshall && tokens > floydWarshallMax) || (solver == BellmanFord && tokens > bellmanFordMax) {
+					continue
+				}
+				b.Run(graphName(tokens, pegged)+"/"+solver.String(), func(b *testing.B) {
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						FindOptimalTradingRoutesWithSolver(graph, base, quote, solver)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package p2
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// candidateEnumerator yields the level combinations of several paths best price first without
+// building their Cartesian product. Each hop's levels are ranked best first, so moving any hop
+// to a worse level never improves the route price and a combination is only queued once its
+// parent (the same ranks with the last non-zero rank lowered by one) has been yielded.
+type candidateEnumerator struct {
+	paths   []enumeratedPath
+	queue   candidateQueue
+	yielded int
+}
+
+type enumeratedPath struct {
+	path      []string
+	hopLevels [][]Level
+	order     [][]int // level indices of each hop, best price first
+	dropped   bool
+}
+
+// queuedCandidate is a combination of level ranks of one path, ranks index order not the levels
+type queuedCandidate struct {
+	pathIndex int
+	ranks     []int
+	price     float64
+}
+
+func newCandidateEnumerator(graph Graph, paths [][]string, isAsk bool) *candidateEnumerator {
+	e := &candidateEnumerator{
+		paths: make([]enumeratedPath, len(paths)),
+		queue: candidateQueue{isAsk: isAsk},
+	}
+	for pathIdx, path := range paths {
+		e.paths[pathIdx].path = path
+		allHopLevels := getHopLevels(graph, path, isAsk)
+		if len(allHopLevels) == 0 {
+			continue
+		}
+		e.paths[pathIdx].hopLevels = allHopLevels
+		empty := false
+		for _, levels := range allHopLevels {
+			order := make([]int, len(levels))
+			for i := range order {
+				order[i] = i
+			}
+			// NOTE: books from a single venue keep their input order, don't trust it
+			sort.SliceStable(order, func(i, j int) bool {
+				if isAsk {
+					return levels[order[i]].Price < levels[order[j]].Price
+				}
+				return levels[order[i]].Price > levels[order[j]].Price
+			})
+			e.paths[pathIdx].order = append(e.paths[pathIdx].order, order)
+			empty = empty || len(levels) == 0
+		}
+		if !empty {
+			e.push(pathIdx, make([]int, len(allHopLevels)))
+		}
+	}
+	return e
+}
+
+func (e *candidateEnumerator) push(pathIdx int, ranks []int) {
+	price := 1.0
+	for hopIdx, rank := range ranks {
+		price *= e.paths[pathIdx].hopLevels[hopIdx][e.paths[pathIdx].order[hopIdx][rank]].Price
+	}
+	heap.Push(&e.queue, queuedCandidate{pathIndex: pathIdx, ranks: ranks, price: price})
+}
+
+// next returns the best remaining candidate whose levels all have volume left in ledger, false
+// once every path is exhausted or dropped. A combination using an empty level is not returned,
+// and as the descendants raising only later hops keep that level they are never queued.
+func (e *candidateEnumerator) next(ledger volumeLedger) (RouteCandidate, bool) {
+	for e.queue.Len() > 0 {
+		queued := heap.Pop(&e.queue).(queuedCandidate)
+		path := &e.paths[queued.pathIndex]
+		if path.dropped {
+			continue
+		}
+		emptyHop := -1
+		for hopIdx, rank := range queued.ranks {
+			key := levelKey{path.path[hopIdx], path.path[hopIdx+1], e.queue.isAsk, path.order[hopIdx][rank]}
+			if ledger[key] <= 0 {
+				emptyHop = hopIdx
+				break
+			}
+		}
+		// Children raise one rank at or after the last non-zero rank, so each is queued once
+		last, end := 0, len(queued.ranks)-1
+		for hopIdx, rank := range queued.ranks {
+			if rank > 0 {
+				last = hopIdx
+			}
+		}
+		if emptyHop >= 0 {
+			end = emptyHop
+		}
+		for hopIdx := last; hopIdx <= end; hopIdx++ {
+			if queued.ranks[hopIdx]+1 < len(path.order[hopIdx]) {
+				ranks := append([]int{}, queued.ranks...)
+				ranks[hopIdx]++
+				e.push(queued.pathIndex, ranks)
+			}
+		}
+		if emptyHop >= 0 {
+			if ledger.exhausted(path.path[emptyHop:emptyHop+2], e.queue.isAsk, len(path.order[emptyHop])) {
+				path.dropped = true
+			}
+			continue
+		}
+		e.yielded++
+		return e.candidate(queued), true
+	}
+	return RouteCandidate{}, false
+}
+
+// pending reports whether a path still has candidates to yield
+func (e *candidateEnumerator) pending() bool {
+	for _, queued := range e.queue.items {
+		if !e.paths[queued.pathIndex].dropped {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *candidateEnumerator) candidate(queued queuedCandidate) RouteCandidate {
+	path := e.paths[queued.pathIndex]
+	candidate := RouteCandidate{
+		path:         path.path,
+		pathIndex:    queued.pathIndex,
+		prices:       make([]float64, len(queued.ranks)),
+		venues:       make([]string, len(queued.ranks)),
+		feesBps:      make([]float64, len(queued.ranks)),
+		levelIndices: make([]int, len(queued.ranks)),
+		finalPrice:   queued.price,
+	}
+	// NOTE: level amounts are in each hop's From token, convert back to base units
+	candidate.maxVolume = math.Inf(1)
+	conversion := 1.0
+	for hopIdx, rank := range queued.ranks {
+		levelIdx := path.order[hopIdx][rank]
+		level := path.hopLevels[hopIdx][levelIdx]
+		candidate.prices[hopIdx] = level.Price
+		candidate.venues[hopIdx] = level.Venue
+		candidate.feesBps[hopIdx] = level.FeeBps
+		candidate.levelIndices[hopIdx] = levelIdx
+		candidate.maxVolume = math.Min(candidate.maxVolume, level.Amount/conversion)
+		conversion *= level.Price
+	}
+	return candidate
+}
+
+// candidateQueue orders candidates best price first, ties by path then ranks so the order is deterministic
+type candidateQueue struct {
+	items []queuedCandidate
+	isAsk bool
+}
+
+func (q *candidateQueue) Len() int { return len(q.items) }
+
+func (q *candidateQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.price != b.price {
+		if q.isAsk {
+			return a.price < b.price
+		}
+		return a.price > b.price
+	}
+	if a.pathIndex != b.pathIndex {
+		return a.pathIndex < b.pathIndex
+	}
+	for k := range a.ranks {
+		if a.ranks[k] != b.ranks[k] {
+			return a.ranks[k] < b.ranks[k]
+		}
+	}
+	return false
+}
+
+func (q *candidateQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *candidateQueue) Push(x any) { q.items = append(q.items, x.(queuedCandidate)) }
+
+func (q *candidateQueue) Pop() any {
+	item := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return item
+}
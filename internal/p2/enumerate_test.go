@@ -0,0 +1,84 @@
+package p2
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// completePairs quotes every token against every other one, so routes exist at any depth
+func completePairs(tokens int, r *rand.Rand) []TradingPair {
+	values := make([]float64, tokens)
+	for i := range values {
+		values[i] = 0.5 + r.Float64()
+	}
+	var pairs []TradingPair
+	for i := 0; i < tokens; i++ {
+		for j := i + 1; j < tokens; j++ {
+			pairs = append(pairs, marketPair(i, j, values[i]/values[j], r))
+		}
+	}
+	return pairs
+}
+
+// sortedLevels orders levels by price then amount, candidates of equal price may be allocated
+// in another order
+func sortedLevels(levels []VirtualLevel) []VirtualLevel {
+	sorted := append([]VirtualLevel{}, levels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Price != sorted[j].Price {
+			return sorted[i].Price < sorted[j].Price
+		}
+		return sorted[i].Amount < sorted[j].Amount
+	})
+	return sorted
+}
+
+func TestLazyCandidatesMatchExhaustive(t *testing.T) {
+	graph := buildGraph(completePairs(7, rand.New(rand.NewSource(1))))
+	for depth := 3; depth <= 5; depth++ {
+		lazy := Options{PathDepth: depth}
+		exhaustive := lazy
+		exhaustive.Exhaustive = true
+		lazyBook := BuildVirtualOrderbookWithOptions(graph, "T0", "T6", lazy)
+		exhaustiveBook := BuildVirtualOrderbookWithOptions(graph, "T0", "T6", exhaustive)
+		for _, side := range []struct {
+			name string
+			a, b []VirtualLevel
+		}{{"asks", lazyBook.AskOrders, exhaustiveBook.AskOrders}, {"bids", lazyBook.BidOrders, exhaustiveBook.BidOrders}} {
+			if len(side.a) != len(side.b) {
+				t.Fatalf("depth %d %s: %d lazy levels, %d exhaustive", depth, side.name, len(side.a), len(side.b))
+			}
+			a, b := sortedLevels(side.a), sortedLevels(side.b)
+			for i := range a {
+				if math.Abs(a[i].Price-b[i].Price) > 1e-12*b[i].Price || math.Abs(a[i].Amount-b[i].Amount) > 1e-9*b[i].Amount {
+					t.Fatalf("depth %d %s level %d: lazy %v @ %v, exhaustive %v @ %v",
+						depth, side.name, i+1, a[i].Amount, a[i].Price, b[i].Amount, b[i].Price)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkVirtualOrderbook compares lazy best-first enumeration with ranking the full
+// Cartesian product of levels per path depth, on a complete graph of 7 tokens.
+func BenchmarkVirtualOrderbook(b *testing.B) {
+	graph := buildGraph(completePairs(7, rand.New(rand.NewSource(1))))
+	for depth := 3; depth <= 5; depth++ {
+		for _, exhaustive := range []bool{false, true} {
+			name := "depth=" + strconv.Itoa(depth) + "/lazy"
+			if exhaustive {
+				name = "depth=" + strconv.Itoa(depth) + "/exhaustive"
+			}
+			options := Options{PathDepth: depth, Exhaustive: exhaustive}
+			b.Run(name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					BuildVirtualOrderbookWithOptions(graph, "T0", "T6", options)
+				}
+			})
+		}
+	}
+}
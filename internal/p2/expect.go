@@ -0,0 +1,299 @@
+package p2
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultTolerance covers values copied from the runner output, which prints 8 decimals
+const DefaultTolerance = 1e-8
+
+// Expectation is one "expect" line of a test case:
+//
+//	expect ask|bid price <price> [tol=<abs>]                      executed price
+//	expect ask|bid worst <price> [tol=<abs>]                      worst level touched
+//	expect ask|bid impact|slippage <bps> [tol=<abs>]              against the mid or top of book
+//	expect ask|bid fill <n> <route> <amount> [tol=<abs>]          n-th executed level
+//	expect ask|bid levels <count>                                 virtual book depth
+//	expect ask|bid order <n> buy|sell <BASE>/<QUOTE> <base amount> [tol=<abs>] n-th per-pair order
+//	expect ask|bid total <base amount> <quote amount> [tol=<abs>] both sides of the fill
+//	expect ask|bid level <n> <price> <amount> [<route>] [tol=<abs>]
+//	expect ask|bid child <n> buy|sell <BASE>/<QUOTE> <base amount> [tol=<abs>] n-th rounded child order
+//	expect ask|bid dust <TOKEN> <amount> [tol=<abs>]              rounding leftover in a token
+//	expect ask|bid rejected <n> <route>                           n-th fill route no market accepts
+//	expect error <text>
+//
+// Routes are written as printed, e.g. ETH->USDT->KNC, and n counts from 1.
+type Expectation struct {
+	Line      int
+	Side      string
+	Kind      string
+	Index     int
+	Route     []string
+	Order     string // side and pair of an order, e.g. "buy KNC/USDT"
+	Token     string // token of a dust amount
+	Price     float64
+	Amount    float64
+	Quote     float64 // quote amount of a total
+	Count     int
+	Tolerance float64
+	Error     string
+}
+
+func parseExpectation(line string) (Expectation, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 || parts[0] != "expect" {
+		return Expectation{}, errors.New("expected an expect line")
+	}
+	if parts[1] == "error" {
+		if len(parts) < 3 {
+			return Expectation{}, errors.New("expect error needs the error text")
+		}
+		return Expectation{Side: "error", Error: strings.Join(parts[2:], " ")}, nil
+	}
+	if parts[1] != "ask" && parts[1] != "bid" {
+		return Expectation{}, fmt.Errorf("unknown expectation: %s", parts[1])
+	}
+	if len(parts) < 4 {
+		return Expectation{}, fmt.Errorf("incomplete expectation: %s", line)
+	}
+	expectation := Expectation{Side: parts[1], Kind: parts[2], Tolerance: DefaultTolerance}
+	args := parts[3:]
+	if tol, ok := strings.CutPrefix(args[len(args)-1], "tol="); ok {
+		value, err := strconv.ParseFloat(tol, 64)
+		if err != nil || value < 0 {
+			return Expectation{}, fmt.Errorf("invalid tolerance: %s", tol)
+		}
+		expectation.Tolerance = value
+		args = args[:len(args)-1]
+	}
+
+	var err error
+	switch expectation.Kind {
+	case "price", "worst", "impact", "slippage":
+		if len(args) != 1 {
+			return Expectation{}, fmt.Errorf("expect %s needs a value", expectation.Kind)
+		}
+		expectation.Price, err = strconv.ParseFloat(args[0], 64)
+	case "total":
+		if len(args) != 2 {
+			return Expectation{}, errors.New("expect total needs a base and a quote amount")
+		}
+		if expectation.Amount, err = strconv.ParseFloat(args[0], 64); err == nil {
+			expectation.Quote, err = strconv.ParseFloat(args[1], 64)
+		}
+	case "levels":
+		if len(args) != 1 {
+			return Expectation{}, errors.New("expect levels needs a count")
+		}
+		expectation.Count, err = strconv.Atoi(args[0])
+	case "fill":
+		if len(args) != 3 {
+			return Expectation{}, errors.New("expect fill needs an index, a route and an amount")
+		}
+		expectation.Route = strings.Split(args[1], "->")
+		if expectation.Index, err = strconv.Atoi(args[0]); err == nil {
+			expectation.Amount, err = strconv.ParseFloat(args[2], 64)
+		}
+	case "order", "child":
+		if len(args) != 4 || (args[1] != "buy" && args[1] != "sell") || !strings.Contains(args[2], "/") {
+			return Expectation{}, fmt.Errorf("expect %s needs an index, buy or sell, a BASE/QUOTE pair and a base amount", expectation.Kind)
+		}
+		expectation.Order = args[1] + " " + args[2]
+		if expectation.Index, err = strconv.Atoi(args[0]); err == nil {
+			expectation.Amount, err = strconv.ParseFloat(args[3], 64)
+		}
+	case "dust":
+		if len(args) != 2 {
+			return Expectation{}, errors.New("expect dust needs a token and an amount")
+		}
+		expectation.Token = args[0]
+		expectation.Amount, err = strconv.ParseFloat(args[1], 64)
+	case "rejected":
+		if len(args) != 2 {
+			return Expectation{}, errors.New("expect rejected needs an index and a route")
+		}
+		expectation.Route = strings.Split(args[1], "->")
+		expectation.Index, err = strconv.Atoi(args[0])
+	case "level":
+		if len(args) != 3 && len(args) != 4 {
+			return Expectation{}, errors.New("expect level needs an index, a price, an amount and an optional route")
+		}
+		if len(args) == 4 {
+			expectation.Route = strings.Split(args[3], "->")
+		}
+		if expectation.Index, err = strconv.Atoi(args[0]); err == nil {
+			if expectation.Price, err = strconv.ParseFloat(args[1], 64); err == nil {
+				expectation.Amount, err = strconv.ParseFloat(args[2], 64)
+			}
+		}
+	default:
+		return Expectation{}, fmt.Errorf("unknown expectation: %s %s", expectation.Side, expectation.Kind)
+	}
+	if err != nil {
+		return Expectation{}, fmt.Errorf("invalid expectation: %s", line)
+	}
+	indexed := map[string]bool{"fill": true, "level": true, "order": true, "child": true, "rejected": true}
+	if indexed[expectation.Kind] && expectation.Index < 1 {
+		return Expectation{}, fmt.Errorf("level index starts at 1: %s", line)
+	}
+	return expectation, nil
+}
+
+// Check compares the outcome of Solve with the scenario's expectations and returns one
+// message per mismatch. An error is a mismatch unless an "expect error" line matches it,
+// the partial result of ErrInsufficientLiquidity and ErrLimitReached is still checked.
+func (s Scenario) Check(result Result, err error) []string {
+	var mismatches []string
+	mismatch := func(expectation Expectation, format string, args ...any) {
+		prefix := fmt.Sprintf("line %d: %s ", expectation.Line, expectation.Side)
+		mismatches = append(mismatches, prefix+fmt.Sprintf(format, args...))
+	}
+	resultUsable := partialFill(err)
+	errorExpected := false
+
+	for _, expectation := range s.Expect {
+		if expectation.Side == "error" {
+			errorExpected = true
+			if err == nil {
+				mismatches = append(mismatches, fmt.Sprintf("line %d: expected error %q, got none", expectation.Line, expectation.Error))
+			} else if !strings.Contains(err.Error(), expectation.Error) {
+				mismatches = append(mismatches, fmt.Sprintf("line %d: expected error %q, got %q", expectation.Line, expectation.Error, err.Error()))
+			}
+			continue
+		}
+		if !resultUsable {
+			continue
+		}
+		isAsk := expectation.Side == "ask"
+		price, fills, levels, fill, impact, orders := result.BidPrice, result.BidRoute, result.Orderbook.BidOrders, result.BidFill, result.BidImpact, result.BidOrders
+		if isAsk {
+			price, fills, levels, fill, impact, orders = result.AskPrice, result.AskRoute, result.Orderbook.AskOrders, result.AskFill, result.AskImpact, result.AskOrders
+		}
+
+		switch expectation.Kind {
+		case "price":
+			if !withinTolerance(price, expectation.Price, expectation.Tolerance) {
+				mismatch(expectation, "price: expected %.8f, got %.8f (tol %g)", expectation.Price, price, expectation.Tolerance)
+			}
+		case "worst", "impact", "slippage":
+			actual := map[string]float64{"worst": impact.WorstPrice, "impact": impact.ImpactBps, "slippage": impact.SlippageBps}[expectation.Kind]
+			if !withinTolerance(actual, expectation.Price, expectation.Tolerance) {
+				mismatch(expectation, "%s: expected %.8f, got %.8f (tol %g)", expectation.Kind, expectation.Price, actual, expectation.Tolerance)
+			}
+		case "order":
+			if expectation.Index > len(orders.Pairs) {
+				mismatch(expectation, "order %d: missing, got %d", expectation.Index, len(orders.Pairs))
+				continue
+			}
+			order := orders.Pairs[expectation.Index-1]
+			if got := order.Side + " " + order.Base + "/" + order.Quote; got != expectation.Order {
+				mismatch(expectation, "order %d: expected %s, got %s", expectation.Index, expectation.Order, got)
+			}
+			if !withinTolerance(order.BaseAmount, expectation.Amount, expectation.Tolerance) {
+				mismatch(expectation, "order %d amount: expected %.8f, got %.8f (tol %g)", expectation.Index, expectation.Amount, order.BaseAmount, expectation.Tolerance)
+			}
+		case "child":
+			if expectation.Index > len(orders.Children) {
+				mismatch(expectation, "child %d: missing, got %d", expectation.Index, len(orders.Children))
+				continue
+			}
+			child := orders.Children[expectation.Index-1]
+			if got := child.Side + " " + child.Base + "/" + child.Quote; got != expectation.Order {
+				mismatch(expectation, "child %d: expected %s, got %s", expectation.Index, expectation.Order, got)
+			}
+			if !withinTolerance(child.BaseAmount, expectation.Amount, expectation.Tolerance) {
+				mismatch(expectation, "child %d amount: expected %.8f, got %.8f (tol %g)", expectation.Index, expectation.Amount, child.BaseAmount, expectation.Tolerance)
+			}
+		case "dust":
+			dust := 0.0
+			for _, leftover := range orders.Dust {
+				if leftover.Token == expectation.Token {
+					dust += leftover.Amount
+				}
+			}
+			if !withinTolerance(dust, expectation.Amount, expectation.Tolerance) {
+				mismatch(expectation, "dust %s: expected %.8f, got %.8f (tol %g)", expectation.Token, expectation.Amount, dust, expectation.Tolerance)
+			}
+		case "rejected":
+			if expectation.Index > len(orders.Rejected) {
+				mismatch(expectation, "rejected %d: missing, got %d", expectation.Index, len(orders.Rejected))
+				continue
+			}
+			if rejected := orders.Rejected[expectation.Index-1]; formatRoute(rejected.Route) != formatRoute(expectation.Route) {
+				mismatch(expectation, "rejected %d route: expected %s, got %s", expectation.Index, formatRoute(expectation.Route), formatRoute(rejected.Route))
+			}
+		case "total":
+			if !withinTolerance(fill.BaseAmount, expectation.Amount, expectation.Tolerance) {
+				mismatch(expectation, "total base: expected %.8f, got %.8f (tol %g)", expectation.Amount, fill.BaseAmount, expectation.Tolerance)
+			}
+			if !withinTolerance(fill.QuoteAmount, expectation.Quote, expectation.Tolerance) {
+				mismatch(expectation, "total quote: expected %.8f, got %.8f (tol %g)", expectation.Quote, fill.QuoteAmount, expectation.Tolerance)
+			}
+		case "levels":
+			if len(levels) != expectation.Count {
+				mismatch(expectation, "levels: expected %d, got %d", expectation.Count, len(levels))
+			}
+		case "fill", "level":
+			candidates := fills
+			if expectation.Kind == "level" {
+				candidates = levels
+			}
+			if expectation.Index > len(candidates) {
+				mismatch(expectation, "%s %d: missing, got %d", expectation.Kind, expectation.Index, len(candidates))
+				continue
+			}
+			level := candidates[expectation.Index-1]
+			if expectation.Route != nil && formatRoute(expectation.Route) != formatRoute(level.Route) {
+				mismatch(expectation, "%s %d route: expected %s, got %s", expectation.Kind, expectation.Index, formatRoute(expectation.Route), formatRoute(level.Route))
+			}
+			if expectation.Kind == "level" && !withinTolerance(level.Price, expectation.Price, expectation.Tolerance) {
+				mismatch(expectation, "%s %d price: expected %.8f, got %.8f (tol %g)", expectation.Kind, expectation.Index, expectation.Price, level.Price, expectation.Tolerance)
+			}
+			if !withinTolerance(level.Amount, expectation.Amount, expectation.Tolerance) {
+				mismatch(expectation, "%s %d amount: expected %.8f, got %.8f (tol %g)", expectation.Kind, expectation.Index, expectation.Amount, level.Amount, expectation.Tolerance)
+			}
+		}
+	}
+	if err != nil && !errorExpected {
+		mismatches = append(mismatches, fmt.Sprintf("unexpected error: %v", err))
+	}
+	// NOTE: every usable result also checks the orientation of its routes, see route.Route.Check
+	if resultUsable {
+		for _, side := range []struct {
+			name   string
+			levels []VirtualLevel
+		}{
+			{"ask level", result.Orderbook.AskOrders}, {"bid level", result.Orderbook.BidOrders},
+			{"ask fill", result.AskRoute}, {"bid fill", result.BidRoute},
+		} {
+			for i, level := range side.levels {
+				if checkErr := checkLevelRoute(level, s.Base, s.Quote); checkErr != nil {
+					mismatches = append(mismatches, fmt.Sprintf("%s %d: %v", side.name, i+1, checkErr))
+				}
+			}
+		}
+	}
+	return mismatches
+}
+
+// checkLevelRoute checks the route of a level, or of each level merged into it since the
+// merged level keeps one of their routes at a price only equal within samePrice
+func checkLevelRoute(level VirtualLevel, base, quote string) error {
+	if len(level.Parts) == 0 {
+		return level.Path.Check(base, quote, level.Price)
+	}
+	for _, part := range level.Parts {
+		if err := part.Path.Check(base, quote, part.Price); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func withinTolerance(actual, expected, tolerance float64) bool {
+	return math.Abs(actual-expected) <= tolerance
+}
@@ -0,0 +1,198 @@
+package p2
+
+import (
+	"fmt"
+	"math"
+	"orderbook-pathfinder/internal/decimal"
+)
+
+// Denomination is the token a target amount is counted in
+type Denomination int
+
+const (
+	BaseAmount  Denomination = iota // e.g. sell 100 KNC
+	QuoteAmount                     // e.g. how much KNC for 10 ETH
+)
+
+func (d Denomination) String() string {
+	if d == QuoteAmount {
+		return "quote"
+	}
+	return "base"
+}
+
+func ParseDenomination(text string) (Denomination, error) {
+	switch text {
+	case "base":
+		return BaseAmount, nil
+	case "quote":
+		return QuoteAmount, nil
+	}
+	return BaseAmount, fmt.Errorf("amount should be in base or quote, got %q", text)
+}
+
+// Fill is the execution of a target amount on one side of a virtual orderbook, reported in
+// both tokens. Price is quote per base like the levels, NaN when the side is empty.
+type Fill struct {
+	Price       float64
+	BaseAmount  float64
+	QuoteAmount float64
+	Unfilled    float64 // what is left of the target, in its denomination
+	Limited     bool    // a Limit stopped the walk before the target was met
+	Levels      []VirtualLevel
+}
+
+// Limit bounds the marginal price of a fill, zero fields are not checked. Both are checked
+// against each level's price, the effective price stays better than the worst level.
+type Limit struct {
+	Price          float64 // worst level price accepted: highest for asks, lowest for bids
+	MaxSlippageBps float64 // worst level price against the top of book
+}
+
+func (l Limit) IsZero() bool {
+	return l.Price == 0 && l.MaxSlippageBps == 0
+}
+
+func (l Limit) Validate() error {
+	if l.Price < 0 || math.IsNaN(l.Price) || math.IsInf(l.Price, 0) {
+		return fmt.Errorf("invalid limit price: %v", l.Price)
+	}
+	if l.MaxSlippageBps < 0 || math.IsNaN(l.MaxSlippageBps) || math.IsInf(l.MaxSlippageBps, 0) {
+		return fmt.Errorf("invalid max slippage: %v bps", l.MaxSlippageBps)
+	}
+	return nil
+}
+
+// FillVirtualOrderbook executes amount, counted in base or quote, on levels best first
+func FillVirtualOrderbook(levels []VirtualLevel, amount float64, denomination Denomination) Fill {
+	var fill Fill
+	if denomination == QuoteAmount {
+		fill.Price, fill.Levels = findBestRouteForQuoteAmount(levels, amount)
+	} else {
+		fill.Price, fill.Levels = findBestRouteFromVirtualOrderbook(levels, amount)
+	}
+	for _, level := range fill.Levels {
+		fill.BaseAmount += level.Amount
+		fill.QuoteAmount += level.Amount * level.Price
+	}
+	filled := fill.BaseAmount
+	if denomination == QuoteAmount {
+		filled = fill.QuoteAmount
+	}
+	fill.Unfilled = math.Max(amount-filled, 0)
+	return fill
+}
+
+// FillVirtualOrderbookWithLimit is FillVirtualOrderbook stopping at the first level beyond
+// limit, the remainder is left in Unfilled
+func FillVirtualOrderbookWithLimit(levels []VirtualLevel, amount float64, denomination Denomination, isAsk bool, limit Limit) Fill {
+	allowed := limitLevels(levels, isAsk, limit)
+	fill := FillVirtualOrderbook(allowed, amount, denomination)
+	fill.Limited = len(allowed) < len(levels) && fill.Unfilled > amount*1e-9
+	return fill
+}
+
+// limitLevels keeps the levels within limit, levels are best first so the first one beyond
+// it ends the walk
+func limitLevels(levels []VirtualLevel, isAsk bool, limit Limit) []VirtualLevel {
+	if limit.IsZero() || len(levels) == 0 {
+		return levels
+	}
+	worst := limit.Price
+	if limit.MaxSlippageBps > 0 {
+		top := bestPrice(levels, isAsk)
+		slipped := top * (1 - limit.MaxSlippageBps/10000)
+		if isAsk {
+			slipped = top * (1 + limit.MaxSlippageBps/10000)
+		}
+		if worst == 0 || (isAsk && slipped < worst) || (!isAsk && slipped > worst) {
+			worst = slipped
+		}
+	}
+	for i, level := range levels {
+		if (isAsk && level.Price > worst) || (!isAsk && level.Price < worst) {
+			return levels[:i]
+		}
+	}
+	return levels
+}
+
+// findBestRouteForQuoteAmount is findBestRouteFromVirtualOrderbook for a target in quote units.
+// Levels are quote per base with base amounts on both sides, also for the routes through
+// inverted books, so a level holds Amount*Price quote.
+func findBestRouteForQuoteAmount(levels []VirtualLevel, quoteAmount float64) (float64, []VirtualLevel) {
+	if len(levels) == 0 {
+		return math.NaN(), []VirtualLevel{}
+	}
+	bestRoute := make([]VirtualLevel, 0)
+	remaining := quoteAmount
+	var executedBase, spent float64
+	for _, level := range levels {
+		if remaining <= 0 {
+			break
+		}
+		executed := level.Amount
+		cost := level.Amount * level.Price
+		if cost >= remaining {
+			// NOTE: the last level is cut by division, don't leave float dust for the next one
+			executed, cost = remaining/level.Price, remaining
+		}
+		executedBase += executed
+		spent += cost
+		remaining -= cost
+
+		bestRoute = append(bestRoute, VirtualLevel{
+			Route:        level.Route,
+			Path:         level.Path,
+			Price:        level.Price,
+			Amount:       executed,
+			LevelPrices:  level.LevelPrices,
+			LevelVenues:  level.LevelVenues,
+			LevelFeesBps: level.LevelFeesBps,
+			LevelIndices: level.LevelIndices,
+			Parts:        level.Parts,
+		})
+	}
+	if executedBase <= 0 {
+		return 0, bestRoute
+	}
+	return spent / executedBase, bestRoute
+}
+
+// findBestRouteForQuoteAmountExact settles findBestRouteForQuoteAmount exactly, the base
+// amount of the last level is quote/price as a rational so the quote target is met exactly
+func findBestRouteForQuoteAmountExact(levels []VirtualLevel, quoteAmount decimal.Decimal) (decimal.Decimal, []VirtualLevel) {
+	bestRoute := make([]VirtualLevel, 0)
+	remaining := quoteAmount
+	executedBase := decimal.New(0)
+	spent := decimal.New(0)
+	for _, level := range levels {
+		if remaining.Sign() <= 0 {
+			break
+		}
+		executed := decimal.Min(level.ExactAmount, remaining.Quo(level.ExactPrice))
+		cost := executed.Mul(level.ExactPrice)
+		executedBase = executedBase.Add(executed)
+		spent = spent.Add(cost)
+		remaining = remaining.Sub(cost)
+
+		filled := level
+		filled.Amount = executed.Float64()
+		filled.ExactAmount = executed
+		bestRoute = append(bestRoute, filled)
+	}
+	if executedBase.Sign() <= 0 {
+		return decimal.Decimal{}, bestRoute
+	}
+	return spent.Quo(executedBase), bestRoute
+}
+
+// exactFill settles amount on levels with the exact prices and amounts, see findBestRouteFromVirtualOrderbookExact
+func exactFill(levels []VirtualLevel, amount decimal.Decimal, denomination Denomination) decimal.Decimal {
+	if denomination == QuoteAmount {
+		price, _ := findBestRouteForQuoteAmountExact(levels, amount)
+		return price
+	}
+	price, _ := findBestRouteFromVirtualOrderbookExact(levels, amount)
+	return price
+}
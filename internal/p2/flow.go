@@ -0,0 +1,382 @@
+package p2
+
+import (
+	"math"
+	"orderbook-pathfinder/internal/route"
+	"sort"
+)
+
+// HopFill is the part of one hop's level consumed by a split route, in execution order:
+// Amount of Hop.From is paid and Proceeds of Hop.To received. Hop.Price is fee-inclusive
+// and oriented like every route.Hop, so a buy pays Proceeds * Price.
+type HopFill struct {
+	route.Hop
+	LevelIndex int // in the merged book of the hop, see VirtualLevel.LevelIndices
+	Amount     float64
+	Proceeds   float64
+}
+
+// SplitRoute is one path of the split with the base amount pushed through it.
+// Hops follow Path, one level per hop.
+type SplitRoute struct {
+	Path   route.Route
+	Price  float64
+	Amount float64
+	Hops   []HopFill
+}
+
+// ExecutionPlan distributes a target base amount across paths so that every
+// (hop, level) is consumed at most once, even when several paths share a book.
+type ExecutionPlan struct {
+	Base         string
+	Quote        string
+	IsAsk        bool
+	TargetAmount float64
+	FilledAmount float64
+	TotalCost    float64
+	Price        float64
+	Routes       []SplitRoute
+	Hops         []HopFill // per (hop, level) totals across all routes
+	Truncation   Truncation
+}
+
+// SplitOrder fills amount of base against quote with a min-cost flow over the levels:
+// every level of a pair on the enumerated paths is an edge whose capacity is its amount and
+// whose gain is its price. Successive shortest paths push volume along the best residual path,
+// whose reverse edges move volume already pushed onto another level when that frees a better
+// one, so paths sharing a book (e.g. USDT/ETH) split it at the lowest total cost. The flow is
+// then decomposed into routes, one level per hop.
+// NOTE: the residual paths combine the pairs of every enumerated path, a decomposed route may
+// be longer than PathDepth when the enumerated paths cross.
+func SplitOrder(pairs []TradingPair, baseCurrency, quoteCurrency string, amount float64, isAsk bool) ExecutionPlan {
+	return splitOrder(buildGraph(pairs), baseCurrency, quoteCurrency, amount, isAsk, newSearch(Options{}))
+}
+
+func splitOrder(graph Graph, baseCurrency, quoteCurrency string, amount float64, isAsk bool, s *search) ExecutionPlan {
+	plan := ExecutionPlan{
+		Base:         baseCurrency,
+		Quote:        quoteCurrency,
+		IsAsk:        isAsk,
+		TargetAmount: amount,
+	}
+	paths := findAllPaths(graph, baseCurrency, quoteCurrency, s)
+	network := newFlowNetwork(graph, paths, isAsk)
+	remainingAmount := amount
+	for remainingAmount > 1e-12 && !s.expired() {
+		path, cycle := network.shortestPath(baseCurrency, quoteCurrency)
+		if cycle {
+			// NOTE: crossed books have no min-cost flow, the split falls back to the best
+			// enumerated path that still has volume, as if no volume could be rerouted
+			path = network.bestEnumeratedPath(paths)
+		}
+		if path == nil {
+			break
+		}
+		pushed := network.push(path, remainingAmount)
+		if pushed <= 0 {
+			break
+		}
+		remainingAmount -= pushed
+	}
+
+	hopTotals := make(map[levelKey]*HopFill)
+	var hopOrder []levelKey
+	for _, flow := range network.decompose(baseCurrency, quoteCurrency, isAsk) {
+		path := levelPath(graph, flow.path, flow.levels, exactLevelPrices(graph, flow.path, flow.levels, isAsk), isAsk)
+		split := SplitRoute{
+			Path:   path,
+			Price:  flow.price,
+			Amount: flow.amount,
+		}
+		// flows[i] enters search hop i, in base->quote order starting from the base pushed
+		flows := []float64{flow.amount}
+		for i := range flow.levels {
+			flows = append(flows, flows[i]*flow.prices[i])
+		}
+		for hop := range path.Hops {
+			i, paid, received := hop, flows[hop], flows[hop+1]
+			if isAsk {
+				// NOTE: a buy runs the search hops backwards, it pays what the search hop receives
+				i = len(flow.levels) - 1 - hop
+				paid, received = flows[i+1], flows[i]
+			}
+			fill := HopFill{Hop: path.Hops[hop], LevelIndex: flow.levels[i], Amount: paid, Proceeds: received}
+			split.Hops = append(split.Hops, fill)
+
+			key := levelKey{flow.path[i], flow.path[i+1], isAsk, flow.levels[i]}
+			if total, ok := hopTotals[key]; ok {
+				total.Amount += fill.Amount
+				total.Proceeds += fill.Proceeds
+			} else {
+				hopTotals[key] = &fill
+				hopOrder = append(hopOrder, key)
+			}
+		}
+
+		plan.Routes = append(plan.Routes, split)
+		plan.FilledAmount += flow.amount
+		plan.TotalCost += flow.amount * flow.price
+	}
+
+	for _, key := range hopOrder {
+		plan.Hops = append(plan.Hops, *hopTotals[key])
+	}
+	if plan.FilledAmount > 0 {
+		plan.Price = plan.TotalCost / plan.FilledAmount
+	}
+	plan.Truncation = s.truncation
+	return plan
+}
+
+// flowArc is one level of one graph edge, Flow and Capacity are in the edge's From units
+type flowArc struct {
+	key      levelKey
+	price    float64 // To per From, fee-inclusive
+	weight   float64 // log of the price, negated for bids, lower is better
+	capacity float64
+	flow     float64
+}
+
+// residualArc walks arc forward (more volume onto its level) or backward (volume taken off it)
+type residualArc struct {
+	arc      int
+	backward bool
+}
+
+// flowNetwork is the residual network of one side's split, arcs in graph order
+type flowNetwork struct {
+	arcs []flowArc
+	out  map[string][]residualArc // residual arcs leaving each token
+}
+
+func newFlowNetwork(graph Graph, paths [][]string, isAsk bool) *flowNetwork {
+	network := &flowNetwork{out: make(map[string][]residualArc)}
+	seen := make(map[[2]string]bool)
+	for _, path := range paths {
+		for i := 0; i < len(path)-1; i++ {
+			from, to := path[i], path[i+1]
+			if seen[[2]string{from, to}] {
+				continue
+			}
+			seen[[2]string{from, to}] = true
+			for levelIdx, level := range hopOrders(graph, from, to, isAsk) {
+				weight := math.Log(level.Price)
+				if !isAsk {
+					weight = -weight
+				}
+				arc := len(network.arcs)
+				network.arcs = append(network.arcs, flowArc{
+					key:      levelKey{from, to, isAsk, levelIdx},
+					price:    level.Price,
+					weight:   weight,
+					capacity: level.Amount,
+				})
+				network.out[from] = append(network.out[from], residualArc{arc: arc})
+				network.out[to] = append(network.out[to], residualArc{arc: arc, backward: true})
+			}
+		}
+	}
+	return network
+}
+
+// ends is the token a residual arc leaves and the one it enters
+func (n *flowNetwork) ends(r residualArc) (string, string) {
+	key := n.arcs[r.arc].key
+	if r.backward {
+		return key.to, key.from
+	}
+	return key.from, key.to
+}
+
+// residual is what r can still carry in the units of the token it leaves, its gain and weight
+func (n *flowNetwork) residual(r residualArc) (float64, float64, float64) {
+	arc := n.arcs[r.arc]
+	if r.backward {
+		return arc.flow * arc.price, 1 / arc.price, -arc.weight
+	}
+	return arc.capacity - arc.flow, arc.price, arc.weight
+}
+
+// shortestPath is the best residual path from base to quote, Bellman-Ford since backward arcs
+// weigh less than nothing. cycle is set when books crossed into an arbitrage cycle leave no
+// shortest path to trace.
+func (n *flowNetwork) shortestPath(base, quote string) ([]residualArc, bool) {
+	distances := map[string]float64{base: 0}
+	tracer := make(map[string]residualArc)
+	tokens := sortedTokens(n.out)
+	changed := true
+	for pass := 0; pass < len(tokens) && changed; pass++ {
+		changed = false
+		for _, u := range tokens {
+			distance, ok := distances[u]
+			if !ok {
+				continue
+			}
+			for _, r := range n.out[u] {
+				available, _, weight := n.residual(r)
+				if available <= 1e-12 {
+					continue
+				}
+				_, v := n.ends(r)
+				if current, ok := distances[v]; ok && distance+weight >= current-1e-12 {
+					continue
+				}
+				distances[v] = distance + weight
+				tracer[v] = r
+				changed = true
+			}
+		}
+	}
+	if changed {
+		return nil, true
+	}
+	if _, ok := distances[quote]; !ok {
+		return nil, false
+	}
+
+	var path []residualArc
+	visited := map[string]bool{quote: true}
+	for token := quote; token != base; {
+		r := tracer[token]
+		from, _ := n.ends(r)
+		if visited[from] {
+			return nil, true
+		}
+		visited[from] = true
+		path = append([]residualArc{r}, path...)
+		token = from
+	}
+	return path, false
+}
+
+// bestEnumeratedPath is the best of paths over the best level with volume left on each hop,
+// forward arcs only, nil when every path has a hop used up
+func (n *flowNetwork) bestEnumeratedPath(paths [][]string) []residualArc {
+	var best []residualArc
+	bestWeight := 0.0
+	for _, path := range paths {
+		var arcs []residualArc
+		weight := 0.0
+		for i := 0; i < len(path)-1; i++ {
+			hop := -1
+			for _, r := range n.out[path[i]] {
+				arc := n.arcs[r.arc]
+				if r.backward || arc.key.to != path[i+1] || arc.capacity-arc.flow <= 1e-12 {
+					continue
+				}
+				if hop == -1 || arc.weight < n.arcs[hop].weight {
+					hop = r.arc
+				}
+			}
+			if hop == -1 {
+				arcs = nil
+				break
+			}
+			arcs = append(arcs, residualArc{arc: hop})
+			weight += n.arcs[hop].weight
+		}
+		if arcs != nil && (best == nil || weight < bestWeight) {
+			best, bestWeight = arcs, weight
+		}
+	}
+	return best
+}
+
+// push sends up to amount of base along path and returns the base amount pushed: the
+// bottleneck, each arc's residual converted back through the gains before it
+func (n *flowNetwork) push(path []residualArc, amount float64) float64 {
+	pushed, conversion := amount, 1.0
+	for _, r := range path {
+		available, gain, _ := n.residual(r)
+		pushed = math.Min(pushed, available/conversion)
+		conversion *= gain
+	}
+	conversion = 1.0
+	for _, r := range path {
+		_, gain, _ := n.residual(r)
+		arc := &n.arcs[r.arc]
+		if r.backward {
+			// NOTE: pushed * conversion of To comes off the level, that is pushed * conversion / price of From
+			arc.flow = math.Max(0, arc.flow-pushed*conversion*gain)
+		} else {
+			arc.flow += pushed * conversion
+		}
+		conversion *= gain
+	}
+	return pushed
+}
+
+// levelFlow is one route of a decomposed flow, one level per hop of path
+type levelFlow struct {
+	path   []string
+	levels []int
+	prices []float64
+	price  float64
+	amount float64 // base
+}
+
+// decompose splits the flow on the arcs into base->quote routes, best price first. Each route
+// takes the best level with volume left out of every token and carries its bottleneck.
+func (n *flowNetwork) decompose(base, quote string, isAsk bool) []levelFlow {
+	var flows []levelFlow
+	for {
+		flow := levelFlow{path: []string{base}, price: 1}
+		var arcs []int
+		visited := map[string]bool{base: true}
+		for token := base; token != quote; {
+			best := -1
+			for _, r := range n.out[token] {
+				arc := n.arcs[r.arc]
+				if r.backward || arc.flow <= 1e-12 || visited[arc.key.to] {
+					continue
+				}
+				if best == -1 || arc.weight < n.arcs[best].weight {
+					best = r.arc
+				}
+			}
+			if best == -1 {
+				break
+			}
+			arc := n.arcs[best]
+			arcs = append(arcs, best)
+			flow.path = append(flow.path, arc.key.to)
+			flow.levels = append(flow.levels, arc.key.level)
+			flow.prices = append(flow.prices, arc.price)
+			flow.price *= arc.price
+			visited[arc.key.to] = true
+			token = arc.key.to
+		}
+		if len(arcs) == 0 || flow.path[len(flow.path)-1] != quote {
+			break
+		}
+
+		flow.amount = math.Inf(1)
+		conversion := 1.0
+		for _, arc := range arcs {
+			flow.amount = math.Min(flow.amount, n.arcs[arc].flow/conversion)
+			conversion *= n.arcs[arc].price
+		}
+		conversion = 1.0
+		for _, arc := range arcs {
+			n.arcs[arc].flow -= flow.amount * conversion
+			conversion *= n.arcs[arc].price
+		}
+		if flow.amount > 1e-12 {
+			flows = append(flows, flow)
+		}
+	}
+	sort.SliceStable(flows, func(i, j int) bool {
+		if isAsk {
+			return flows[i].price < flows[j].price
+		}
+		return flows[i].price > flows[j].price
+	})
+	return flows
+}
+
+func hopOrders(graph Graph, from, to string, isAsk bool) []Level {
+	if isAsk {
+		return graph[from][to].AskOrders
+	}
+	return graph[from][to].BidOrders
+}
@@ -0,0 +1,80 @@
+package p2
+
+import (
+	"fmt"
+	"math"
+)
+
+// Impact is the slippage report of one side of a quote, what risk checks look at before
+// sending the order. Prices are quote per base, NaN when there is nothing to report, and
+// the bps are positive when the fill is worse than the reference price.
+type Impact struct {
+	MidPrice    float64 // halfway between the best virtual ask and bid
+	TopPrice    float64 // best level of the side
+	Price       float64 // effective price of the fill
+	WorstPrice  float64 // worst level the fill touched
+	ImpactBps   float64 // effective price against the mid
+	SlippageBps float64 // effective price against the top of book
+	Target      float64 // in the fill's denomination
+	Filled      float64
+	Fillable    bool // the whole target was filled, within the Limit if any
+}
+
+// MeasureImpact reports fill, executed for target on one side of book, against the book's prices
+func MeasureImpact(book VirtualTradingPair, fill Fill, target float64, denomination Denomination, isAsk bool) Impact {
+	impact := Impact{
+		MidPrice:   math.NaN(),
+		TopPrice:   math.NaN(),
+		Price:      math.NaN(),
+		WorstPrice: math.NaN(),
+		Target:     target,
+		Filled:     fill.BaseAmount,
+	}
+	if denomination == QuoteAmount {
+		impact.Filled = fill.QuoteAmount
+	}
+	// NOTE: tolerate float drift from the base-unit conversions in the ledger
+	impact.Fillable = impact.Filled >= target*(1-1e-9)
+
+	bestAsk, bestBid := bestPrice(book.AskOrders, true), bestPrice(book.BidOrders, false)
+	if !math.IsNaN(bestAsk) && !math.IsNaN(bestBid) {
+		impact.MidPrice = (bestAsk + bestBid) / 2
+	}
+	impact.TopPrice = bestBid
+	if isAsk {
+		impact.TopPrice = bestAsk
+	}
+	if len(fill.Levels) == 0 || fill.BaseAmount <= 0 {
+		impact.ImpactBps, impact.SlippageBps = math.NaN(), math.NaN()
+		return impact
+	}
+	impact.Price = fill.Price
+	impact.WorstPrice = bestPrice(fill.Levels, !isAsk)
+	impact.ImpactBps = adverseBps(impact.Price, impact.MidPrice, isAsk)
+	impact.SlippageBps = adverseBps(impact.Price, impact.TopPrice, isAsk)
+	return impact
+}
+
+func (i Impact) String() string {
+	return fmt.Sprintf("mid %.8f, top %.8f, effective %.8f, worst %.8f, impact %.2f bps, slippage %.2f bps, filled %.8f / %.8f (fillable: %t)",
+		i.MidPrice, i.TopPrice, i.Price, i.WorstPrice, i.ImpactBps, i.SlippageBps, i.Filled, i.Target, i.Fillable)
+}
+
+// bestPrice is the lowest price of levels when lowest is set, the highest otherwise
+func bestPrice(levels []VirtualLevel, lowest bool) float64 {
+	best := math.NaN()
+	for _, level := range levels {
+		if math.IsNaN(best) || (lowest && level.Price < best) || (!lowest && level.Price > best) {
+			best = level.Price
+		}
+	}
+	return best
+}
+
+// adverseBps is how much worse price is than reference for the side, in basis points
+func adverseBps(price, reference float64, isAsk bool) float64 {
+	if isAsk {
+		return (price - reference) / reference * 10000
+	}
+	return (reference - price) / reference * 10000
+}
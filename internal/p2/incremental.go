@@ -0,0 +1,212 @@
+package p2
+
+type pairKey struct {
+	base  string
+	quote string
+	venue string
+}
+
+type edgeKey struct {
+	from string
+	to   string
+}
+
+// IncrementalOrderbook keeps the virtual orderbook of one base/quote up to date.
+// Every VirtualLevel belongs to exactly one path and the volume ledgers of both sides outlive
+// a rebuild, so an update to a pair gives back what the paths through it consumed, reloads
+// the pair's levels and allocates those paths again against what the other paths left.
+// NOTE: the other paths keep their levels, so a path whose price improved only gets shared
+// volume they left over. No level is ever counted twice, but the book can be worse than a
+// full rebuild, which Rebuild restores.
+type IncrementalOrderbook struct {
+	base        string
+	quote       string
+	options     Options
+	pairs       []TradingPair
+	index       map[pairKey]int
+	graph       Graph
+	paths       [][]string
+	pathsByEdge map[edgeKey][]int
+	askByPath   [][]VirtualLevel
+	bidByPath   [][]VirtualLevel
+	askLedger   volumeLedger
+	bidLedger   volumeLedger
+	askExact    exactVolumeLedger
+	bidExact    exactVolumeLedger
+	// truncation holds the books and paths cut, pathCuts whether the last allocation of
+	// each path ran out of candidates or time, so an update clears what it reallocates
+	truncation Truncation
+	pathCuts   []Truncation
+}
+
+func NewIncrementalOrderbook(pairs []TradingPair, baseCurrency, quoteCurrency string) *IncrementalOrderbook {
+	return NewIncrementalOrderbookWithOptions(pairs, baseCurrency, quoteCurrency, Options{})
+}
+
+// NewIncrementalOrderbookWithOptions applies the options to every rebuild, the timeout to each one separately
+func NewIncrementalOrderbookWithOptions(pairs []TradingPair, baseCurrency, quoteCurrency string, options Options) *IncrementalOrderbook {
+	o := &IncrementalOrderbook{
+		base:    baseCurrency,
+		quote:   quoteCurrency,
+		options: options.withDefaults(),
+		index:   make(map[pairKey]int),
+	}
+	for _, pair := range pairs {
+		o.index[pairKey{pair.Base, pair.Quote, pair.Venue}] = len(o.pairs)
+		o.pairs = append(o.pairs, pair)
+	}
+	o.Rebuild()
+	return o
+}
+
+// UpdatePair replaces the book of pair on its venue, or adds it when it is new
+func (o *IncrementalOrderbook) UpdatePair(pair TradingPair) {
+	key := pairKey{pair.Base, pair.Quote, pair.Venue}
+	if idx, exists := o.index[key]; exists {
+		o.pairs[idx] = pair
+	} else {
+		o.index[key] = len(o.pairs)
+		o.pairs = append(o.pairs, pair)
+	}
+
+	// NOTE: a new token pair changes the set of paths, only a full rebuild can find them
+	if _, exists := o.graph[pair.Base][pair.Quote]; !exists {
+		o.Rebuild()
+		return
+	}
+	affected := o.affectedPaths(pair.Base, pair.Quote)
+	subPaths := make([][]string, len(affected))
+	for i, pathIdx := range affected {
+		subPaths[i] = o.paths[pathIdx]
+	}
+
+	// Refunds need the prices the levels were allocated at, before the edge is rebuilt
+	o.refund(affected, o.askByPath, o.askLedger, o.askExact, true)
+	o.refund(affected, o.bidByPath, o.bidLedger, o.bidExact, false)
+	previousLevels := make(map[levelSide]int)
+	for _, side := range edgeSides(pair.Base, pair.Quote) {
+		previousLevels[side] = len(hopOrders(o.graph, side.from, side.to, side.isAsk))
+	}
+	o.rebuildEdge(pair.Base, pair.Quote)
+	o.truncation.Books = truncatedBooks(o.pairs, o.options.LevelsPerPair)
+	for side, levels := range previousLevels {
+		ledger, exactLedger := o.bidLedger, o.bidExact
+		if side.isAsk {
+			ledger, exactLedger = o.askLedger, o.askExact
+		}
+		ledger.reset(o.graph, side.from, side.to, side.isAsk, levels)
+		exactLedger.reset(side.from, side.to, side.isAsk, levels)
+	}
+	if len(affected) == 0 {
+		return
+	}
+
+	s := newSearch(o.options)
+	askByPath := allocateOrdersByPath(o.graph, subPaths, true, s, o.askLedger, o.askExact)
+	bidByPath := allocateOrdersByPath(o.graph, subPaths, false, s, o.bidLedger, o.bidExact)
+	for i, pathIdx := range affected {
+		o.askByPath[pathIdx] = askByPath[i]
+		o.bidByPath[pathIdx] = bidByPath[i]
+		o.pathCuts[pathIdx] = allocationCut(s)
+	}
+}
+
+// Orderbook merges the per-path levels into the same book buildVirtualOrderbook returns
+func (o *IncrementalOrderbook) Orderbook() VirtualTradingPair {
+	virtualPair := VirtualTradingPair{
+		Base:      o.base,
+		Quote:     o.quote,
+		AskOrders: []VirtualLevel{},
+		BidOrders: []VirtualLevel{},
+	}
+	virtualPair.Truncation = o.truncation
+	for _, cut := range o.pathCuts {
+		virtualPair.Truncation.merge(cut)
+	}
+	for i := range o.paths {
+		virtualPair.AskOrders = append(virtualPair.AskOrders, o.askByPath[i]...)
+		virtualPair.BidOrders = append(virtualPair.BidOrders, o.bidByPath[i]...)
+	}
+	sortVirtualLevels(&virtualPair.AskOrders, true)
+	sortVirtualLevels(&virtualPair.BidOrders, false)
+	virtualPair.AskOrders = mergeVirtualLevels(virtualPair.AskOrders)
+	virtualPair.BidOrders = mergeVirtualLevels(virtualPair.BidOrders)
+	dropUnplaceable(o.graph, &virtualPair)
+	return virtualPair
+}
+
+// Rebuild recomputes every path from scratch, the book is then the one buildVirtualOrderbook returns
+func (o *IncrementalOrderbook) Rebuild() {
+	s := newSearch(o.options)
+	o.graph, s.truncation.Books = buildGraphWithOptions(o.pairs, o.options)
+	o.paths = findAllPaths(o.graph, o.base, o.quote, s)
+	o.truncation = s.truncation
+	o.pathsByEdge = make(map[edgeKey][]int)
+	for pathIdx, path := range o.paths {
+		for i := 0; i < len(path)-1; i++ {
+			key := edgeKey{path[i], path[i+1]}
+			o.pathsByEdge[key] = append(o.pathsByEdge[key], pathIdx)
+		}
+	}
+	o.askLedger, o.askExact = newVolumeLedger(o.graph, o.paths, true), make(exactVolumeLedger)
+	o.bidLedger, o.bidExact = newVolumeLedger(o.graph, o.paths, false), make(exactVolumeLedger)
+	o.askByPath = allocateOrdersByPath(o.graph, o.paths, true, s, o.askLedger, o.askExact)
+	o.bidByPath = allocateOrdersByPath(o.graph, o.paths, false, s, o.bidLedger, o.bidExact)
+	o.pathCuts = make([]Truncation, len(o.paths))
+	for i := range o.pathCuts {
+		o.pathCuts[i] = allocationCut(s)
+	}
+}
+
+// allocationCut is what cut an allocation short, it applies to every path allocated together
+func allocationCut(s *search) Truncation {
+	return Truncation{CandidatesLimited: s.truncation.CandidatesLimited, TimedOut: s.truncation.TimedOut}
+}
+
+// rebuildEdge recomputes both directions of a token pair from every venue quoting it
+func (o *IncrementalOrderbook) rebuildEdge(tokenA, tokenB string) {
+	delete(o.graph[tokenA], tokenB)
+	delete(o.graph[tokenB], tokenA)
+	for _, pair := range o.pairs {
+		if (pair.Base == tokenA && pair.Quote == tokenB) || (pair.Base == tokenB && pair.Quote == tokenA) {
+			addPair(o.graph, pair, o.options.LevelsPerPair)
+		}
+	}
+}
+
+// levelSide is one side of one direction of an edge, the books an update to a pair replaces
+type levelSide struct {
+	from  string
+	to    string
+	isAsk bool
+}
+
+func edgeSides(tokenA, tokenB string) []levelSide {
+	return []levelSide{{tokenA, tokenB, true}, {tokenA, tokenB, false}, {tokenB, tokenA, true}, {tokenB, tokenA, false}}
+}
+
+// affectedPaths returns the paths using tokenA/tokenB in either direction, ordered like paths.
+// A simple path can't take both directions, so the two lists don't overlap.
+func (o *IncrementalOrderbook) affectedPaths(tokenA, tokenB string) []int {
+	forward, backward := o.pathsByEdge[edgeKey{tokenA, tokenB}], o.pathsByEdge[edgeKey{tokenB, tokenA}]
+	affected := make([]int, 0, len(forward)+len(backward))
+	for len(forward) > 0 || len(backward) > 0 {
+		if len(backward) == 0 || (len(forward) > 0 && forward[0] < backward[0]) {
+			affected, forward = append(affected, forward[0]), forward[1:]
+		} else {
+			affected, backward = append(affected, backward[0]), backward[1:]
+		}
+	}
+	return affected
+}
+
+// refund gives the volume of every level of the affected paths back to the ledgers of one side
+func (o *IncrementalOrderbook) refund(affected []int, byPath [][]VirtualLevel, ledger volumeLedger, exactLedger exactVolumeLedger, isAsk bool) {
+	for _, pathIdx := range affected {
+		path := o.paths[pathIdx]
+		for _, level := range byPath[pathIdx] {
+			ledger.refund(path, level.LevelIndices, level.LevelPrices, isAsk, level.Amount)
+			exactLedger.refund(o.graph, path, level.LevelIndices, exactLevelPrices(o.graph, path, level.LevelIndices, isAsk), isAsk, level.ExactAmount)
+		}
+	}
+}
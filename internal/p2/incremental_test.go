@@ -0,0 +1,214 @@
+package p2
+
+import (
+	"math"
+	"math/rand"
+	"orderbook-pathfinder/internal/route/routetest"
+	"testing"
+)
+
+// marketPairs quotes every token against T0 and degree random others, five levels per side
+// around one value per token
+func marketPairs(tokens, degree int, r *rand.Rand) []TradingPair {
+	values := make([]float64, tokens)
+	for i := range values {
+		values[i] = 0.5 + r.Float64()
+	}
+	seen := make(map[[2]int]bool)
+	var pairs []TradingPair
+	for i := 1; i < tokens; i++ {
+		for d := 0; d <= degree; d++ {
+			j := 0
+			if d > 0 {
+				j = 1 + r.Intn(tokens-1)
+			}
+			key := [2]int{min(i, j), max(i, j)}
+			if j == i || seen[key] {
+				continue
+			}
+			seen[key] = true
+			pairs = append(pairs, marketPair(i, j, values[i]/values[j], r))
+		}
+	}
+	return pairs
+}
+
+func marketPair(i, j int, mid float64, r *rand.Rand) TradingPair {
+	pair := TradingPair{Base: routetest.Token(i), Quote: routetest.Token(j)}
+	for level := 0; level < MAX_LEVELS_PER_PAIR; level++ {
+		spread := 0.001 * float64(level+1) * (1 + r.Float64())
+		pair.AskOrders = append(pair.AskOrders, Level{Price: mid * (1 + spread), Amount: 10 + 100*r.Float64()})
+		pair.BidOrders = append(pair.BidOrders, Level{Price: mid * (1 - spread), Amount: 10 + 100*r.Float64()})
+	}
+	return pair
+}
+
+// ticked moves every level of pair by up to 10 bps and resizes it
+func ticked(pair TradingPair, r *rand.Rand) TradingPair {
+	tick := func(levels []Level) []Level {
+		moved := make([]Level, len(levels))
+		for i, level := range levels {
+			level.Price *= 1 + 0.001*(r.Float64()-0.5)
+			level.Amount = 10 + 100*r.Float64()
+			moved[i] = level
+		}
+		return moved
+	}
+	pair.AskOrders, pair.BidOrders = tick(pair.AskOrders), tick(pair.BidOrders)
+	return pair
+}
+
+// checkLedgers replays the allocated levels of every path on fresh ledgers: no level may be
+// consumed beyond its volume, and the ledgers the orderbook keeps must hold what is left
+func checkLedgers(t *testing.T, o *IncrementalOrderbook) {
+	t.Helper()
+	for _, side := range []struct {
+		isAsk  bool
+		byPath [][]VirtualLevel
+		kept   volumeLedger
+	}{{true, o.askByPath, o.askLedger}, {false, o.bidByPath, o.bidLedger}} {
+		replayed := newVolumeLedger(o.graph, o.paths, side.isAsk)
+		exactReplayed := make(exactVolumeLedger)
+		for pathIdx, levels := range side.byPath {
+			path := o.paths[pathIdx]
+			for _, level := range levels {
+				replayed.consume(path, level.LevelIndices, level.LevelPrices, side.isAsk, level.Amount)
+				exactPrices := exactLevelPrices(o.graph, path, level.LevelIndices, side.isAsk)
+				exactReplayed.consume(o.graph, path, level.LevelIndices, exactPrices, side.isAsk, level.ExactAmount)
+			}
+		}
+		for key, remaining := range replayed {
+			if remaining < -1e-9 {
+				t.Fatalf("level %v consumed %.12f beyond its volume", key, -remaining)
+			}
+			if kept := side.kept[key]; math.Abs(kept-remaining) > 1e-9 {
+				t.Fatalf("level %v: ledger keeps %.12f, the levels leave %.12f", key, kept, remaining)
+			}
+		}
+		for key, remaining := range exactReplayed {
+			if remaining.Sign() < 0 {
+				t.Fatalf("level %v consumed beyond its exact volume by %s", key, remaining)
+			}
+		}
+	}
+}
+
+func TestIncrementalOrderbookKeepsLedgersConsistent(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	pairs := marketPairs(12, 3, r)
+	o := NewIncrementalOrderbook(pairs, "T1", "T11")
+	checkLedgers(t, o)
+	for i := 0; i < 200; i++ {
+		idx := r.Intn(len(pairs))
+		pairs[idx] = ticked(pairs[idx], r)
+		o.UpdatePair(pairs[idx])
+		checkLedgers(t, o)
+	}
+}
+
+func TestIncrementalOrderbookReflectsUpdatedPair(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	pairs := marketPairs(12, 3, r)
+	o := NewIncrementalOrderbook(pairs, "T1", "T0")
+	direct := -1
+	for i, pair := range pairs {
+		if pair.Base == "T1" && pair.Quote == "T0" {
+			direct = i
+		}
+	}
+	if direct < 0 {
+		t.Fatal("no direct T1/T0 pair")
+	}
+	// An ask far below every route only exists on the direct pair, it must be the best level
+	pairs[direct].AskOrders[0].Price /= 2
+	o.UpdatePair(pairs[direct])
+	book := o.Orderbook()
+	if best := book.AskOrders[0]; best.Price != pairs[direct].AskOrders[0].Price || best.Amount != pairs[direct].AskOrders[0].Amount {
+		t.Fatalf("best ask %.8f x %.8f, want the updated direct level %.8f x %.8f",
+			best.Price, best.Amount, pairs[direct].AskOrders[0].Price, pairs[direct].AskOrders[0].Amount)
+	}
+}
+
+func TestIncrementalOrderbookRebuildMatchesFullBuild(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	pairs := marketPairs(12, 3, r)
+	o := NewIncrementalOrderbook(pairs, "T1", "T11")
+	for i := 0; i < 50; i++ {
+		idx := r.Intn(len(pairs))
+		pairs[idx] = ticked(pairs[idx], r)
+		o.UpdatePair(pairs[idx])
+	}
+	o.Rebuild()
+	incremental := o.Orderbook()
+	full := BuildVirtualOrderbook(BuildGraph(pairs), "T1", "T11")
+	for _, side := range []struct {
+		name string
+		a, b []VirtualLevel
+	}{{"asks", incremental.AskOrders, full.AskOrders}, {"bids", incremental.BidOrders, full.BidOrders}} {
+		if len(side.a) != len(side.b) {
+			t.Fatalf("%s: %d levels after Rebuild, full build has %d", side.name, len(side.a), len(side.b))
+		}
+		for i := range side.a {
+			if side.a[i].Price != side.b[i].Price || side.a[i].Amount != side.b[i].Amount {
+				t.Fatalf("%s level %d: %v @ %v after Rebuild, full build %v @ %v",
+					side.name, i+1, side.a[i].Amount, side.a[i].Price, side.b[i].Amount, side.b[i].Price)
+			}
+		}
+	}
+}
+
+func TestIncrementalOrderbookRecomputesTruncation(t *testing.T) {
+	twoLevels := TradingPair{
+		Base:      "KNC",
+		Quote:     "USDT",
+		AskOrders: []Level{{Price: 1.1, Amount: 1}, {Price: 1.2, Amount: 1}},
+		BidOrders: []Level{{Price: 0.9, Amount: 1}, {Price: 0.8, Amount: 1}},
+	}
+	oneLevel := twoLevels
+	oneLevel.AskOrders, oneLevel.BidOrders = oneLevel.AskOrders[:1], oneLevel.BidOrders[:1]
+
+	// One candidate per side is enough for one level, a second level is left out
+	o := NewIncrementalOrderbookWithOptions([]TradingPair{twoLevels}, "KNC", "USDT", Options{MaxCandidates: 1})
+	for i, step := range []struct {
+		pair    TradingPair
+		limited bool
+	}{{twoLevels, true}, {oneLevel, false}, {twoLevels, true}} {
+		if i > 0 {
+			o.UpdatePair(step.pair)
+		}
+		if got := o.Orderbook().Truncation.CandidatesLimited; got != step.limited {
+			t.Fatalf("step %d: candidates limited %v, want %v", i, got, step.limited)
+		}
+	}
+}
+
+// BenchmarkIncrementalUpdatePair times one pair tick on a 50-token graph of about 1800 paths
+// at the default path depth, the target is below a millisecond. BenchmarkFullRebuild is the
+// same graph rebuilt from scratch.
+func BenchmarkIncrementalUpdatePair(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	pairs := marketPairs(50, 10, r)
+	o := NewIncrementalOrderbook(pairs, "T1", "T49")
+	ticks := make([]TradingPair, 1024)
+	for i := range ticks {
+		ticks[i] = ticked(pairs[r.Intn(len(pairs))], r)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o.UpdatePair(ticks[i%len(ticks)])
+	}
+	b.ReportMetric(float64(len(o.paths)), "paths")
+}
+
+func BenchmarkFullRebuild(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	pairs := marketPairs(50, 10, r)
+	o := NewIncrementalOrderbook(pairs, "T1", "T49")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o.Rebuild()
+	}
+	b.ReportMetric(float64(len(o.paths)), "paths")
+}
@@ -0,0 +1,143 @@
+package p2
+
+import "orderbook-pathfinder/internal/decimal"
+
+// levelKey identifies one level of one side of a graph edge. Within a side the edge
+// direction identifies the book: KNC->USDT asks are KNC/USDT asks, USDT->KNC asks
+// are the inverted KNC/USDT bids.
+type levelKey struct {
+	from  string
+	to    string
+	isAsk bool
+	level int
+}
+
+// volumeLedger tracks the remaining volume of every level, in the edge's From units
+type volumeLedger map[levelKey]float64
+
+func newVolumeLedger(graph Graph, paths [][]string, isAsk bool) volumeLedger {
+	ledger := make(volumeLedger)
+	for _, path := range paths {
+		for i := 0; i < len(path)-1; i++ {
+			for levelIdx, level := range hopOrders(graph, path[i], path[i+1], isAsk) {
+				ledger[levelKey{path[i], path[i+1], isAsk, levelIdx}] = level.Amount
+			}
+		}
+	}
+	return ledger
+}
+
+// capacity is the base amount that can still flow through the given levels of path
+func (l volumeLedger) capacity(path []string, levelIndices []int, prices []float64, isAsk bool) float64 {
+	capacity := -1.0
+	conversion := 1.0
+	for i, levelIdx := range levelIndices {
+		available := l[levelKey{path[i], path[i+1], isAsk, levelIdx}] / conversion
+		if capacity < 0 || available < capacity {
+			capacity = available
+		}
+		conversion *= prices[i]
+	}
+	if capacity < 0 {
+		return 0
+	}
+	return capacity
+}
+
+// consume deducts a base amount from every level it flows through
+func (l volumeLedger) consume(path []string, levelIndices []int, prices []float64, isAsk bool, amount float64) {
+	flow := amount
+	for i, levelIdx := range levelIndices {
+		l[levelKey{path[i], path[i+1], isAsk, levelIdx}] -= flow
+		flow *= prices[i]
+	}
+}
+
+// refund returns a base amount consume took to every level it flowed through
+func (l volumeLedger) refund(path []string, levelIndices []int, prices []float64, isAsk bool, amount float64) {
+	l.consume(path, levelIndices, prices, isAsk, -amount)
+}
+
+// reset reloads the levels of one edge from graph, the edge had previousLevels before
+func (l volumeLedger) reset(graph Graph, from, to string, isAsk bool, previousLevels int) {
+	for levelIdx := 0; levelIdx < previousLevels; levelIdx++ {
+		delete(l, levelKey{from, to, isAsk, levelIdx})
+	}
+	for levelIdx, level := range hopOrders(graph, from, to, isAsk) {
+		l[levelKey{from, to, isAsk, levelIdx}] = level.Amount
+	}
+}
+
+// exhausted reports whether the first hop of path has no volume left on any of its levels
+func (l volumeLedger) exhausted(path []string, isAsk bool, levels int) bool {
+	for levelIdx := 0; levelIdx < levels; levelIdx++ {
+		if l[levelKey{path[0], path[1], isAsk, levelIdx}] > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// exactVolumeLedger mirrors volumeLedger with exact amounts, levels are loaded from the graph on first use
+type exactVolumeLedger map[levelKey]decimal.Decimal
+
+func (l exactVolumeLedger) remaining(graph Graph, key levelKey) decimal.Decimal {
+	if volume, ok := l[key]; ok {
+		return volume
+	}
+	volume := hopOrders(graph, key.from, key.to, key.isAsk)[key.level].exactAmount()
+	l[key] = volume
+	return volume
+}
+
+func (l exactVolumeLedger) capacity(graph Graph, path []string, levelIndices []int, prices []decimal.Decimal, isAsk bool) decimal.Decimal {
+	var capacity decimal.Decimal
+	conversion := decimal.New(1)
+	for i, levelIdx := range levelIndices {
+		available := l.remaining(graph, levelKey{path[i], path[i+1], isAsk, levelIdx}).Quo(conversion)
+		if !capacity.IsSet() || available.Cmp(capacity) < 0 {
+			capacity = available
+		}
+		conversion = conversion.Mul(prices[i])
+	}
+	return capacity
+}
+
+// usedUp lists the levels of path with less than one unit of amountDecimals of base left
+func (l exactVolumeLedger) usedUp(graph Graph, path []string, levelIndices []int, prices []decimal.Decimal, isAsk bool) []levelKey {
+	var keys []levelKey
+	conversion := decimal.New(1)
+	for i, levelIdx := range levelIndices {
+		key := levelKey{path[i], path[i+1], isAsk, levelIdx}
+		if l.remaining(graph, key).Quo(conversion).Truncate(amountDecimals).Sign() <= 0 {
+			keys = append(keys, key)
+		}
+		conversion = conversion.Mul(prices[i])
+	}
+	return keys
+}
+
+func (l exactVolumeLedger) consume(graph Graph, path []string, levelIndices []int, prices []decimal.Decimal, isAsk bool, amount decimal.Decimal) {
+	flow := amount
+	for i, levelIdx := range levelIndices {
+		key := levelKey{path[i], path[i+1], isAsk, levelIdx}
+		l[key] = l.remaining(graph, key).Sub(flow)
+		flow = flow.Mul(prices[i])
+	}
+}
+
+func (l exactVolumeLedger) refund(graph Graph, path []string, levelIndices []int, prices []decimal.Decimal, isAsk bool, amount decimal.Decimal) {
+	flow := amount
+	for i, levelIdx := range levelIndices {
+		key := levelKey{path[i], path[i+1], isAsk, levelIdx}
+		l[key] = l.remaining(graph, key).Add(flow)
+		flow = flow.Mul(prices[i])
+	}
+}
+
+// reset forgets the levels of one edge, they are loaded again from the graph on next use
+func (l exactVolumeLedger) reset(from, to string, isAsk bool, previousLevels int) {
+	for levelIdx := 0; levelIdx < previousLevels; levelIdx++ {
+		delete(l, levelKey{from, to, isAsk, levelIdx})
+	}
+}
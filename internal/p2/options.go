@@ -0,0 +1,212 @@
+package p2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Options bounds the work of graph building and virtual orderbook construction.
+// PathDepth counts the tokens of a path, base and quote included. A zero LevelsPerPair or
+// PathDepth falls back to the defaults, the other zero limits mean no limit.
+type Options struct {
+	LevelsPerPair int
+	PathDepth     int
+	MaxCandidates int // level combinations ranked per side
+	MaxPaths      int
+	Timeout       time.Duration
+	Exhaustive    bool // rank every level combination up front instead of lazily, for comparison
+}
+
+func DefaultOptions() Options {
+	return Options{
+		LevelsPerPair: MAX_LEVELS_PER_PAIR,
+		PathDepth:     MAX_PATH_DEPTH,
+	}
+}
+
+func (o Options) withDefaults() Options {
+	if o.LevelsPerPair == 0 {
+		o.LevelsPerPair = MAX_LEVELS_PER_PAIR
+	}
+	if o.PathDepth == 0 {
+		o.PathDepth = MAX_PATH_DEPTH
+	}
+	return o
+}
+
+func (o Options) Validate() error {
+	switch {
+	case o.LevelsPerPair < 0:
+		return fmt.Errorf("levelsPerPair must not be negative, got %d", o.LevelsPerPair)
+	case o.PathDepth < 0 || o.PathDepth == 1:
+		return fmt.Errorf("pathDepth must be at least 2 tokens, got %d", o.PathDepth)
+	case o.MaxCandidates < 0:
+		return fmt.Errorf("maxCandidates must not be negative, got %d", o.MaxCandidates)
+	case o.MaxPaths < 0:
+		return fmt.Errorf("maxPaths must not be negative, got %d", o.MaxPaths)
+	case o.Timeout < 0:
+		return fmt.Errorf("timeout must not be negative, got %s", o.Timeout)
+	}
+	return nil
+}
+
+// optionsFile is the JSON config, keys left out keep their default
+type optionsFile struct {
+	LevelsPerPair *int   `json:"levelsPerPair"`
+	PathDepth     *int   `json:"pathDepth"`
+	MaxCandidates *int   `json:"maxCandidates"`
+	MaxPaths      *int   `json:"maxPaths"`
+	Timeout       string `json:"timeout"` // Go duration, e.g. "250ms"
+}
+
+// LoadOptions reads a JSON config such as {"levelsPerPair": 10, "timeout": "250ms"}
+func LoadOptions(r io.Reader) (Options, error) {
+	var file optionsFile
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return Options{}, fmt.Errorf("invalid options: %v", err)
+	}
+	options := DefaultOptions()
+	for _, field := range []struct {
+		value  *int
+		target *int
+	}{
+		{file.LevelsPerPair, &options.LevelsPerPair},
+		{file.PathDepth, &options.PathDepth},
+		{file.MaxCandidates, &options.MaxCandidates},
+		{file.MaxPaths, &options.MaxPaths},
+	} {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	if file.Timeout != "" {
+		timeout, err := time.ParseDuration(file.Timeout)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid options: timeout: %v", err)
+		}
+		options.Timeout = timeout
+	}
+	if err := options.Validate(); err != nil {
+		return Options{}, fmt.Errorf("invalid options: %v", err)
+	}
+	return options, nil
+}
+
+func LoadOptionsFile(path string) (Options, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Options{}, err
+	}
+	defer file.Close()
+	return LoadOptions(file)
+}
+
+// BookTruncation is a book that had more levels than LevelsPerPair, the counts are before the cut
+type BookTruncation struct {
+	Base      string
+	Quote     string
+	Venue     string
+	AskLevels int
+	BidLevels int
+}
+
+// Truncation records which limits cut the search short, the zero value means nothing was cut
+type Truncation struct {
+	Books             []BookTruncation
+	DepthLimited      bool // a path one token longer than PathDepth exists
+	PathsLimited      bool
+	CandidatesLimited bool
+	TimedOut          bool
+}
+
+func (t Truncation) Truncated() bool {
+	return len(t.Books) > 0 || t.DepthLimited || t.PathsLimited || t.CandidatesLimited || t.TimedOut
+}
+
+func (t Truncation) String() string {
+	var reasons []string
+	for _, book := range t.Books {
+		reasons = append(reasons, fmt.Sprintf("%s/%s%s book cut (%d asks, %d bids)", book.Base, book.Quote, formatVenue(book.Venue), book.AskLevels, book.BidLevels))
+	}
+	if t.DepthLimited {
+		reasons = append(reasons, "longer paths beyond path depth")
+	}
+	if t.PathsLimited {
+		reasons = append(reasons, "max paths reached")
+	}
+	if t.CandidatesLimited {
+		reasons = append(reasons, "max candidates reached")
+	}
+	if t.TimedOut {
+		reasons = append(reasons, "timed out")
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func (t *Truncation) merge(other Truncation) {
+	t.Books = append(t.Books, other.Books...)
+	t.DepthLimited = t.DepthLimited || other.DepthLimited
+	t.PathsLimited = t.PathsLimited || other.PathsLimited
+	t.CandidatesLimited = t.CandidatesLimited || other.CandidatesLimited
+	t.TimedOut = t.TimedOut || other.TimedOut
+}
+
+// search carries the limits and deadline of one virtual orderbook construction
+type search struct {
+	options    Options
+	deadline   time.Time
+	truncation Truncation
+}
+
+func newSearch(options Options) *search {
+	s := &search{options: options.withDefaults()}
+	if s.options.Timeout > 0 {
+		s.deadline = time.Now().Add(s.options.Timeout)
+	}
+	return s
+}
+
+// stage is a search for the next stage of the same request: the same limits and deadline,
+// so the stages together never run past Timeout, with a truncation of its own
+func (s *search) stage() *search {
+	return &search{options: s.options, deadline: s.deadline}
+}
+
+func (s *search) expired() bool {
+	if s.truncation.TimedOut {
+		return true
+	}
+	if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		s.truncation.TimedOut = true
+	}
+	return s.truncation.TimedOut
+}
+
+func truncatedBooks(pairs []TradingPair, levelsPerPair int) []BookTruncation {
+	var books []BookTruncation
+	for _, pair := range pairs {
+		if book, ok := truncatedBook(pair, levelsPerPair); ok {
+			books = append(books, book)
+		}
+	}
+	return books
+}
+
+func truncatedBook(pair TradingPair, levelsPerPair int) (BookTruncation, bool) {
+	if len(pair.AskOrders) <= levelsPerPair && len(pair.BidOrders) <= levelsPerPair {
+		return BookTruncation{}, false
+	}
+	return BookTruncation{
+		Base:      pair.Base,
+		Quote:     pair.Quote,
+		Venue:     pair.Venue,
+		AskLevels: len(pair.AskOrders),
+		BidLevels: len(pair.BidOrders),
+	}, true
+}
@@ -0,0 +1,199 @@
+package p2
+
+import (
+	"fmt"
+	"math"
+	"orderbook-pathfinder/internal/route"
+)
+
+// ChildOrder is one hop of a filled virtual level as an order on the market the level came
+// from: Side buys or sells Base of Base/Quote. Price is the venue price without the fee,
+// QuoteAmount = BaseAmount * Price and the fee is charged on top of it.
+type ChildOrder struct {
+	Fill        int // index of the filled virtual level
+	Hop         int // in execution order, the first hop starts from the token paid
+	Base        string
+	Quote       string
+	Venue       string
+	Side        string
+	Price       float64
+	FeeBps      float64
+	BaseAmount  float64
+	QuoteAmount float64
+	LevelIndex  int // in the merged book of the hop, see VirtualLevel.LevelIndices
+	Rules       PairRules
+}
+
+// PairOrder aggregates the child orders of one market, venue and side. Price is their
+// volume-weighted average and LimitPrice the worst of them, so one order at LimitPrice
+// takes every level the children use.
+type PairOrder struct {
+	Base        string
+	Quote       string
+	Venue       string
+	Side        string
+	Price       float64
+	LimitPrice  float64
+	FeeBps      float64
+	BaseAmount  float64
+	QuoteAmount float64
+	Children    int
+}
+
+// OrderPlan is a fill of a virtual orderbook as orders for an execution system. With market
+// rules the orders are rounded to valid sizes: BaseAmount is the virtual pair's base they
+// execute, Dust what is left in intermediate tokens and Rejected the routes no market accepts.
+type OrderPlan struct {
+	Children   []ChildOrder
+	Pairs      []PairOrder
+	BaseAmount float64
+	Dust       []TokenAmount
+	Rejected   []RejectedFill
+}
+
+// RejectedFill is a route of a filled level dropped because a market would reject one of its orders
+type RejectedFill struct {
+	Fill   int
+	Route  []string
+	Amount float64
+	Reason string
+}
+
+// BuildOrderPlan expands fills, levels executed on one side of the virtual orderbook of
+// graph, into per-hop child orders and aggregates them per market
+func BuildOrderPlan(graph Graph, fills []VirtualLevel, isAsk bool) OrderPlan {
+	var plan OrderPlan
+	pairIndex := make(map[[4]string]int)
+	dustIndex := make(map[string]int)
+	for fillIdx, fill := range fills {
+		var children []ChildOrder
+		for _, part := range fillParts(fill) {
+			chain := routeChildOrders(graph, part, isAsk)
+			if !chainHasRules(chain) {
+				children = append(children, chain...)
+				plan.BaseAmount += part.Amount
+				continue
+			}
+			rounded, dust, baseAmount, reason := roundChain(chain, isAsk)
+			if reason != "" {
+				plan.Rejected = append(plan.Rejected, RejectedFill{Fill: fillIdx, Route: part.Route, Amount: part.Amount, Reason: reason})
+				continue
+			}
+			children = append(children, rounded...)
+			plan.BaseAmount += baseAmount
+			for _, leftover := range dust {
+				if idx, ok := dustIndex[leftover.Token]; ok {
+					plan.Dust[idx].Amount += leftover.Amount
+				} else {
+					dustIndex[leftover.Token] = len(plan.Dust)
+					plan.Dust = append(plan.Dust, leftover)
+				}
+			}
+		}
+		for _, child := range children {
+			child.Fill = fillIdx
+			plan.Children = append(plan.Children, child)
+
+			key := [4]string{child.Base, child.Quote, child.Venue, child.Side}
+			idx, ok := pairIndex[key]
+			if !ok {
+				idx = len(plan.Pairs)
+				pairIndex[key] = idx
+				plan.Pairs = append(plan.Pairs, PairOrder{
+					Base:       child.Base,
+					Quote:      child.Quote,
+					Venue:      child.Venue,
+					Side:       child.Side,
+					LimitPrice: child.Price,
+					FeeBps:     child.FeeBps,
+				})
+			}
+			pair := &plan.Pairs[idx]
+			pair.BaseAmount += child.BaseAmount
+			pair.QuoteAmount += child.QuoteAmount
+			pair.Children++
+			if (child.Side == "buy" && child.Price > pair.LimitPrice) || (child.Side == "sell" && child.Price < pair.LimitPrice) {
+				pair.LimitPrice = child.Price
+			}
+		}
+	}
+	for i := range plan.Pairs {
+		if plan.Pairs[i].BaseAmount > 0 {
+			plan.Pairs[i].Price = plan.Pairs[i].QuoteAmount / plan.Pairs[i].BaseAmount
+		}
+	}
+	return plan
+}
+
+// fillParts splits a filled level over the levels merged into it, one per route
+func fillParts(fill VirtualLevel) []VirtualLevel {
+	if len(fill.Parts) == 0 {
+		return []VirtualLevel{fill}
+	}
+	var parts []VirtualLevel
+	remaining := fill.Amount
+	for _, part := range fill.Parts {
+		if remaining <= 0 {
+			break
+		}
+		part.Amount = math.Min(part.Amount, remaining)
+		remaining -= part.Amount
+		part.Parts = nil
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// routeChildOrders turns every hop of a level's route into a child order, in execution order.
+// Amounts chain in search order, base->quote: a hop's From amount times its Price is the To
+// amount passed to the next hop, fee included.
+func routeChildOrders(graph Graph, fill VirtualLevel, isAsk bool) []ChildOrder {
+	hops := fill.Path.Hops
+	children := make([]ChildOrder, len(hops))
+	flow := fill.Amount
+	for i, levelIdx := range fill.LevelIndices {
+		// NOTE: a buy route pays the quote first, backwards its hops are in search order
+		idx, from, to := i, hops[i].From, hops[i].To
+		if fill.Path.Direction == route.Buy {
+			idx = len(hops) - 1 - i
+			from, to = hops[idx].To, hops[idx].From
+		}
+		hop := hops[idx]
+		proceeds := flow * hop.Price
+		child := ChildOrder{
+			Hop:        idx,
+			Base:       hop.Base,
+			Quote:      hop.Quote,
+			Venue:      hop.Venue,
+			Side:       hop.Side,
+			FeeBps:     hop.FeeBps,
+			Price:      hop.Price,
+			BaseAmount: flow,
+			LevelIndex: levelIdx,
+			Rules:      hopOrders(graph, from, to, isAsk)[levelIdx].Rules,
+		}
+		// A level of the reverse market trades that market's base, which is the hop's To token
+		if hop.Base == to {
+			child.Price = 1 / hop.Price
+			child.BaseAmount = proceeds
+		}
+		child.Price = withoutFee(child.Price, child.FeeBps, hop.Side == "buy")
+		child.QuoteAmount = child.BaseAmount * child.Price
+		children[idx] = child
+		flow = proceeds
+	}
+	return children
+}
+
+// withoutFee undoes applyVenue: buyers pay price*(1+fee), sellers receive price*(1-fee)
+func withoutFee(price, feeBps float64, buys bool) float64 {
+	if buys {
+		return price / (1 + feeBps/10000)
+	}
+	return price / (1 - feeBps/10000)
+}
+
+func (o PairOrder) String() string {
+	return fmt.Sprintf("%s %.8f %s/%s%s @ %.8f (limit %.8f, fee %.2f bps) = %.8f %s, %d child orders",
+		o.Side, o.BaseAmount, o.Base, o.Quote, formatVenue(o.Venue), o.Price, o.LimitPrice, o.FeeBps, o.QuoteAmount, o.Quote, o.Children)
+}
@@ -1,21 +1,33 @@
 package p2
 
 import (
-	"bufio"
 	"fmt"
 	"math"
+	"orderbook-pathfinder/internal/decimal"
+	"orderbook-pathfinder/internal/fees"
+	"orderbook-pathfinder/internal/route"
 	"os"
 	"sort"
-	"strconv"
 	"strings"
 )
 
 const MAX_LEVELS_PER_PAIR = 5
 const MAX_PATH_DEPTH = 5
 
+// amountDecimals is the precision of exact virtual level amounts, twice the 18 decimals of the
+// finest token units so settlement values printed to 18 decimals don't move
+const amountDecimals = 36
+
 type Level struct {
 	Price  float64
 	Amount float64
+	Venue  string
+	FeeBps float64
+	// Optional exact Price/Amount for settlement, derived from the floats when unset
+	ExactPrice  decimal.Decimal
+	ExactAmount decimal.Decimal
+	Inverted    bool      // set by invertOrders: the level comes from the book of the reverse market
+	Rules       PairRules // of the market the level comes from, set by buildGraph
 }
 
 type TradingPair struct {
@@ -23,53 +35,81 @@ type TradingPair struct {
 	Quote     string
 	AskOrders []Level
 	BidOrders []Level
+	Venue     string
+	FeeBps    float64 // taker fee, buildGraph makes level prices fee-inclusive
+	Rules     PairRules
 }
 
+// Graph merges the books of every venue quoting the same pair, each level keeps its venue
 type Graph map[string]map[string]TradingPair
 
 type VirtualLevel struct {
-	Price       float64
-	Amount      float64
-	Route       []string
-	LevelPrices []float64 // Price of each level in each pair of the route
+	Price        float64
+	Amount       float64
+	Route        []string    // Path.Tokens(), asks are reported quote->base
+	Path         route.Route // hops in execution order, the Level* fields below are in search order
+	LevelPrices  []float64   // Price of each level in each pair of the route
+	LevelVenues  []string    // Venue of each level in each pair of the route
+	LevelFeesBps []float64   // Fee charged by each level of the route, already included in LevelPrices
+	LevelIndices []int       // Level used in each hop's merged book (search order, base->quote)
+	ExactPrice   decimal.Decimal
+	ExactAmount  decimal.Decimal
+	Parts        []VirtualLevel // the same-price levels merged into this one, nil when nothing was merged
+}
+
+// ApplyFees fills FeeBps from model for every pair that has no explicit fee
+func ApplyFees(pairs []TradingPair, model fees.Model) []TradingPair {
+	withFees := make([]TradingPair, len(pairs))
+	for i, pair := range pairs {
+		if pair.FeeBps == 0 {
+			pair.FeeBps = model.TakerFeeBps(pair.Venue, pair.Base, pair.Quote)
+		}
+		withFees[i] = pair
+	}
+	return withFees
 }
 
 type VirtualTradingPair struct {
-	Base      string
-	Quote     string
-	AskOrders []VirtualLevel
-	BidOrders []VirtualLevel
+	Base       string
+	Quote      string
+	AskOrders  []VirtualLevel
+	BidOrders  []VirtualLevel
+	Truncation Truncation // path and candidate limits hit while building the book
+}
+
+// BuildGraph, BuildVirtualOrderbook and FindBestRouteFromVirtualOrderbook expose the
+// quoting pipeline to callers that keep one graph in memory across many quotes.
+func BuildGraph(pairs []TradingPair) Graph {
+	return buildGraph(pairs)
+}
+
+// BuildGraphWithOptions also reports the books cut to options.LevelsPerPair
+func BuildGraphWithOptions(pairs []TradingPair, options Options) (Graph, []BookTruncation) {
+	return buildGraphWithOptions(pairs, options)
+}
+
+func BuildVirtualOrderbook(graph Graph, baseCurrency, quoteCurrency string) VirtualTradingPair {
+	return buildVirtualOrderbook(graph, baseCurrency, quoteCurrency, newSearch(Options{}))
 }
 
-type PriceVolumeCombo struct {
-	prices []float64
-	depth  float64
+func BuildVirtualOrderbookWithOptions(graph Graph, baseCurrency, quoteCurrency string, options Options) VirtualTradingPair {
+	return buildVirtualOrderbook(graph, baseCurrency, quoteCurrency, newSearch(options))
+}
+
+func FindBestRouteFromVirtualOrderbook(levels []VirtualLevel, targetAmount float64) (float64, []VirtualLevel) {
+	return findBestRouteFromVirtualOrderbook(levels, targetAmount)
 }
 
 func buildGraph(pairs []TradingPair) Graph {
+	graph, _ := buildGraphWithOptions(pairs, Options{})
+	return graph
+}
+
+func buildGraphWithOptions(pairs []TradingPair, options Options) (Graph, []BookTruncation) {
+	levelsPerPair := options.withDefaults().LevelsPerPair
 	graph := make(Graph)
 	for _, pair := range pairs {
-		if graph[pair.Base] == nil {
-			graph[pair.Base] = make(map[string]TradingPair)
-		}
-		if graph[pair.Quote] == nil {
-			graph[pair.Quote] = make(map[string]TradingPair)
-		}
-		limitedAskOrders := pair.AskOrders[:min(len(pair.AskOrders), MAX_LEVELS_PER_PAIR)]
-		limitedBidOrders := pair.BidOrders[:min(len(pair.BidOrders), MAX_LEVELS_PER_PAIR)]
-		graph[pair.Base][pair.Quote] = TradingPair{
-			Base:      pair.Base,
-			Quote:     pair.Quote,
-			AskOrders: limitedAskOrders,
-			BidOrders: limitedBidOrders,
-		}
-		reversePair := TradingPair{
-			Base:      pair.Quote,
-			Quote:     pair.Base,
-			AskOrders: invertOrders(limitedBidOrders),
-			BidOrders: invertOrders(limitedAskOrders),
-		}
-		graph[pair.Quote][pair.Base] = reversePair
+		addPair(graph, pair, levelsPerPair)
 	}
 	// fmt.Println("Trading Graph Visualization:")
 	// for base, neighbors := range graph {
@@ -85,7 +125,71 @@ func buildGraph(pairs []TradingPair) Graph {
 	// 	}
 	// }
 	// fmt.Println(strings.Repeat("-", 50))
-	return graph
+	return graph, truncatedBooks(pairs, levelsPerPair)
+}
+
+// addPair adds both directions of pair to graph, merging with books of other venues
+func addPair(graph Graph, pair TradingPair, levelsPerPair int) {
+	if graph[pair.Base] == nil {
+		graph[pair.Base] = make(map[string]TradingPair)
+	}
+	if graph[pair.Quote] == nil {
+		graph[pair.Quote] = make(map[string]TradingPair)
+	}
+	limitedAskOrders := applyVenue(pair.AskOrders[:min(len(pair.AskOrders), levelsPerPair)], pair, true)
+	limitedBidOrders := applyVenue(pair.BidOrders[:min(len(pair.BidOrders), levelsPerPair)], pair, false)
+	// NOTE: books of the same pair on other venues are merged level by level, each level keeps its venue
+	forwardPair := graph[pair.Base][pair.Quote]
+	graph[pair.Base][pair.Quote] = TradingPair{
+		Base:      pair.Base,
+		Quote:     pair.Quote,
+		AskOrders: mergeLevels(forwardPair.AskOrders, limitedAskOrders, true),
+		BidOrders: mergeLevels(forwardPair.BidOrders, limitedBidOrders, false),
+	}
+	reversePair := graph[pair.Quote][pair.Base]
+	graph[pair.Quote][pair.Base] = TradingPair{
+		Base:      pair.Quote,
+		Quote:     pair.Base,
+		AskOrders: mergeLevels(reversePair.AskOrders, invertOrders(limitedBidOrders), true),
+		BidOrders: mergeLevels(reversePair.BidOrders, invertOrders(limitedAskOrders), false),
+	}
+}
+
+// applyVenue tags levels with the pair's venue, fee and rules.
+// NOTE: amounts stay in base units, only the price moves by the fee
+func applyVenue(levels []Level, pair TradingPair, isAsk bool) []Level {
+	venue, feeBps := pair.Venue, pair.FeeBps
+	withFee := make([]Level, len(levels))
+	for i, level := range levels {
+		withFee[i] = level
+		withFee[i].Venue = venue
+		withFee[i].FeeBps = feeBps
+		withFee[i].Rules = pair.Rules
+		withFee[i].ExactAmount = level.exactAmount()
+		if isAsk {
+			withFee[i].Price = fees.AskPrice(level.Price, feeBps)
+			withFee[i].ExactPrice = fees.ExactAskPrice(level.exactPrice(), feeBps)
+		} else {
+			withFee[i].Price = fees.BidPrice(level.Price, feeBps)
+			withFee[i].ExactPrice = fees.ExactBidPrice(level.exactPrice(), feeBps)
+		}
+	}
+	return withFee
+}
+
+// mergeLevels combines two books of the same side, best price first
+func mergeLevels(levels, other []Level, isAsk bool) []Level {
+	if len(levels) == 0 {
+		return other
+	}
+	merged := append(append([]Level{}, levels...), other...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		if isAsk {
+			return merged[i].Price < merged[j].Price
+		}
+		return merged[i].Price > merged[j].Price
+	})
+	return merged
 }
 
 func invertOrders(levels []Level) []Level {
@@ -93,204 +197,320 @@ func invertOrders(levels []Level) []Level {
 	for _, level := range levels {
 		if level.Price > 0 {
 			invertedOrders = append(invertedOrders, Level{
-				Price:  1.0 / level.Price,
-				Amount: level.Amount * level.Price,
+				Price:       1.0 / level.Price,
+				Amount:      level.Amount * level.Price,
+				Venue:       level.Venue,
+				FeeBps:      level.FeeBps,
+				ExactPrice:  level.exactPrice().Inv(),
+				ExactAmount: level.exactAmount().Mul(level.exactPrice()),
+				Inverted:    !level.Inverted,
+				Rules:       level.Rules,
 			})
 		}
 	}
 	return invertedOrders
 }
 
-func findAllPaths(graph Graph, start, end string, maxDepth int) [][]string {
+func (l Level) exactPrice() decimal.Decimal {
+	if l.ExactPrice.IsSet() {
+		return l.ExactPrice
+	}
+	return decimal.FromFloat(l.Price)
+}
+
+func (l Level) exactAmount() decimal.Decimal {
+	if l.ExactAmount.IsSet() {
+		return l.ExactAmount
+	}
+	return decimal.FromFloat(l.Amount)
+}
+
+// findAllPaths lists the loop-free paths of at most PathDepth tokens, neighbours are visited
+// in sorted order so MaxPaths and the timeout always keep the same paths
+func findAllPaths(graph Graph, start, end string, s *search) [][]string {
 	visited := make(map[string]bool)
 	startPath := []string{start}
-	return findPathsRecursive(graph, start, end, visited, startPath, maxDepth)
+	var allPaths [][]string
+	findPathsRecursive(graph, start, end, visited, startPath, s, &allPaths)
+	return allPaths
 }
 
-func findPathsRecursive(graph Graph, currentToken, targetToken string, visited map[string]bool, currentPath []string, maxDepth int) [][]string {
-	var allPaths [][]string
-	if len(currentPath) > maxDepth {
-		return allPaths
-	}
+func findPathsRecursive(graph Graph, currentToken, targetToken string, visited map[string]bool, currentPath []string, s *search, allPaths *[][]string) {
 	if currentToken == targetToken && len(currentPath) > 1 {
+		// NOTE: only flag the limit once a path is actually dropped
+		if s.options.MaxPaths > 0 && len(*allPaths) >= s.options.MaxPaths {
+			s.truncation.PathsLimited = true
+			return
+		}
 		pathCopy := make([]string, len(currentPath))
 		copy(pathCopy, currentPath)
-		allPaths = append(allPaths, pathCopy)
-		return allPaths
+		*allPaths = append(*allPaths, pathCopy)
+		return
 	}
 
 	visited[currentToken] = true
-	for nextToken := range graph[currentToken] {
-		if !visited[nextToken] {
-			newPath := make([]string, len(currentPath))
-			copy(newPath, currentPath)
-			newPath = append(newPath, nextToken)
-
-			pathsFromNext := findPathsRecursive(graph, nextToken, targetToken, visited, newPath, maxDepth)
-			allPaths = append(allPaths, pathsFromNext...)
+	for _, nextToken := range sortedTokens(graph[currentToken]) {
+		if visited[nextToken] {
+			continue
 		}
+		if s.truncation.PathsLimited || s.expired() {
+			break
+		}
+		if len(currentPath) >= s.options.PathDepth {
+			if nextToken == targetToken {
+				s.truncation.DepthLimited = true
+			}
+			continue
+		}
+		newPath := make([]string, len(currentPath))
+		copy(newPath, currentPath)
+		newPath = append(newPath, nextToken)
+		findPathsRecursive(graph, nextToken, targetToken, visited, newPath, s, allPaths)
 	}
 
 	visited[currentToken] = false
-	return allPaths
 }
 
-func buildVirtualOrderbook(graph Graph, baseCurrency, quoteCurrency string) VirtualTradingPair {
+func sortedTokens[V any](m map[string]V) []string {
+	tokens := make([]string, 0, len(m))
+	for token := range m {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+func buildVirtualOrderbook(graph Graph, baseCurrency, quoteCurrency string, s *search) VirtualTradingPair {
 	virtualPair := VirtualTradingPair{
 		Base:      baseCurrency,
 		Quote:     quoteCurrency,
 		AskOrders: []VirtualLevel{},
 		BidOrders: []VirtualLevel{},
 	}
-	paths := findAllPaths(graph, baseCurrency, quoteCurrency, MAX_PATH_DEPTH)
-	fmt.Printf("Found %d paths for %s->%s\n: %v\n", len(paths), baseCurrency, quoteCurrency, paths)
-	for _, path := range paths {
-		askOrders := calculateOrdersFromPath(graph, path, true)
-		bidOrders := calculateOrdersFromPath(graph, path, false)
-		virtualPair.AskOrders = append(virtualPair.AskOrders, askOrders...)
-		virtualPair.BidOrders = append(virtualPair.BidOrders, bidOrders...)
-	}
+	paths := findAllPaths(graph, baseCurrency, quoteCurrency, s)
+	virtualPair.AskOrders = append(virtualPair.AskOrders, calculateOrdersFromPaths(graph, paths, true, s)...)
+	virtualPair.BidOrders = append(virtualPair.BidOrders, calculateOrdersFromPaths(graph, paths, false, s)...)
+	virtualPair.Truncation = s.truncation
 	sortVirtualLevels(&virtualPair.AskOrders, true)
 	sortVirtualLevels(&virtualPair.BidOrders, false)
 	virtualPair.AskOrders = mergeVirtualLevels(virtualPair.AskOrders)
 	virtualPair.BidOrders = mergeVirtualLevels(virtualPair.BidOrders)
+	dropUnplaceable(graph, &virtualPair)
 	return virtualPair
 }
 
-func calculateOrdersFromPath(graph Graph, path []string, isAsk bool) []VirtualLevel {
+// calculateOrdersFromPaths ranks the level combinations of every path together and
+// allocates volume greedily from one ledger, so a book shared by several paths
+// (e.g. USDT/ETH in KNC->USDT->ETH and KNC->BTC->USDT->ETH) is only counted once.
+func calculateOrdersFromPaths(graph Graph, paths [][]string, isAsk bool, s *search) []VirtualLevel {
 	var levels []VirtualLevel
-	if len(path) < 2 {
-		return levels
+	for _, pathLevels := range calculateOrdersByPath(graph, paths, isAsk, s) {
+		levels = append(levels, pathLevels...)
 	}
-	priceVolumeCombos := getAllPriceVolumeCombinations(graph, path, isAsk)
-	truePath := make([]string, len(path))
-	// NOTE: for ask, the path needs to be reversed
-	if isAsk {
-		for i, token := range path {
-			truePath[len(path)-1-i] = token
+	return levels
+}
+
+// calculateOrdersByPath is calculateOrdersFromPaths keeping each path's levels apart, indexed like paths
+func calculateOrdersByPath(graph Graph, paths [][]string, isAsk bool, s *search) [][]VirtualLevel {
+	return allocateOrdersByPath(graph, paths, isAsk, s, newVolumeLedger(graph, paths, isAsk), make(exactVolumeLedger))
+}
+
+// allocateOrdersByPath is calculateOrdersByPath drawing from ledgers other paths may already
+// have consumed from, the volume it allocates is left consumed in them
+func allocateOrdersByPath(graph Graph, paths [][]string, isAsk bool, s *search, ledger volumeLedger, exactLedger exactVolumeLedger) [][]VirtualLevel {
+	if s.options.Exhaustive {
+		return allocateCandidates(graph, paths, isAsk, ledger, exactLedger, exhaustiveCandidates(graph, paths, isAsk, s))
+	}
+	return allocateCandidates(graph, paths, isAsk, ledger, exactLedger, lazyCandidates(graph, paths, isAsk, s))
+}
+
+// lazyCandidates feeds allocateCandidates best price first, skipping combinations through
+// used up levels, so the Cartesian product of levels is only walked as far as volume lasts
+func lazyCandidates(graph Graph, paths [][]string, isAsk bool, s *search) func(ledger volumeLedger) (RouteCandidate, bool) {
+	enumerator := newCandidateEnumerator(graph, paths, isAsk)
+	return func(ledger volumeLedger) (RouteCandidate, bool) {
+		if s.options.MaxCandidates > 0 && enumerator.yielded >= s.options.MaxCandidates {
+			s.truncation.CandidatesLimited = enumerator.pending()
+			return RouteCandidate{}, false
 		}
-	} else {
-		truePath = path
-	}
-	for _, combo := range priceVolumeCombos {
-		effectivePrice := 1.0
-		for _, price := range combo.prices {
-			effectivePrice *= price
-		}
-		if combo.depth > 0 {
-			levels = append(levels, VirtualLevel{
-				Price:       effectivePrice,
-				Amount:      combo.depth,
-				Route:       truePath,
-				LevelPrices: combo.prices, // save level prices for each pair in the route
-			})
+		if s.expired() {
+			return RouteCandidate{}, false
+		}
+		return enumerator.next(ledger)
+	}
+}
+
+// exhaustiveCandidates ranks the full Cartesian product up front, kept to compare against lazyCandidates
+func exhaustiveCandidates(graph Graph, paths [][]string, isAsk bool, s *search) func(ledger volumeLedger) (RouteCandidate, bool) {
+	var candidates []RouteCandidate
+	for pathIdx, path := range paths {
+		allHopLevels := getHopLevels(graph, path, isAsk)
+		if len(allHopLevels) == 0 {
+			continue
+		}
+		first := len(candidates)
+		generateAllRouteCandidates(path, allHopLevels, 0, []float64{}, []int{}, &candidates, s)
+		for i := first; i < len(candidates); i++ {
+			candidates[i].pathIndex = pathIdx
+		}
+	}
+	sortCandidatesByPrice(candidates, isAsk)
+	return func(volumeLedger) (RouteCandidate, bool) {
+		if len(candidates) == 0 {
+			return RouteCandidate{}, false
+		}
+		candidate := candidates[0]
+		candidates = candidates[1:]
+		return candidate, true
+	}
+}
+
+// allocateCandidates takes candidates best first and gives each the volume its levels have left
+// NOTE: floats rank and skip candidates, the exact ledger is only touched for levels that are kept
+func allocateCandidates(graph Graph, paths [][]string, isAsk bool, ledger volumeLedger, exactLedger exactVolumeLedger, nextCandidate func(ledger volumeLedger) (RouteCandidate, bool)) [][]VirtualLevel {
+	levels := make([][]VirtualLevel, len(paths))
+	for {
+		candidate, ok := nextCandidate(ledger)
+		if !ok {
+			break
+		}
+		maxUsableVolume := math.Min(candidate.maxVolume, ledger.capacity(candidate.path, candidate.levelIndices, candidate.prices, isAsk))
+		if maxUsableVolume <= 0 {
+			continue
 		}
+		exactPrices := exactLevelPrices(graph, candidate.path, candidate.levelIndices, isAsk)
+		// NOTE: truncated to settlement precision, the ledger would otherwise carry the
+		// denominators of every price the volume was converted through
+		exactVolume := exactLedger.capacity(graph, candidate.path, candidate.levelIndices, exactPrices, isAsk).Truncate(amountDecimals)
+		if exactVolume.Sign() <= 0 {
+			// NOTE: float rounding can leave dust on levels the exact ledger has used up, empty
+			// them so the enumerator stops offering every combination through them
+			for _, key := range exactLedger.usedUp(graph, candidate.path, candidate.levelIndices, exactPrices, isAsk) {
+				ledger[key] = 0
+			}
+			continue
+		}
+		ledger.consume(candidate.path, candidate.levelIndices, candidate.prices, isAsk, maxUsableVolume)
+		exactLedger.consume(graph, candidate.path, candidate.levelIndices, exactPrices, isAsk, exactVolume)
+		exactPrice := decimal.New(1)
+		for _, price := range exactPrices {
+			exactPrice = exactPrice.Mul(price)
+		}
+		path := levelPath(graph, candidate.path, candidate.levelIndices, exactPrices, isAsk)
+		levels[candidate.pathIndex] = append(levels[candidate.pathIndex], VirtualLevel{
+			Price:        candidate.finalPrice,
+			Amount:       maxUsableVolume,
+			Route:        path.Tokens(),
+			Path:         path,
+			LevelPrices:  candidate.prices, // save level prices for each pair in the route
+			LevelVenues:  candidate.venues,
+			LevelFeesBps: candidate.feesBps,
+			LevelIndices: candidate.levelIndices,
+			ExactPrice:   exactPrice,
+			ExactAmount:  exactVolume,
+		})
 	}
 	return levels
 }
 
+// levelPath is the route of one level per hop of path, both in search order
+func levelPath(graph Graph, path []string, levelIndices []int, exactPrices []decimal.Decimal, isAsk bool) route.Route {
+	hops := make([]route.Hop, len(levelIndices))
+	for i, levelIdx := range levelIndices {
+		level := hopOrders(graph, path[i], path[i+1], isAsk)[levelIdx]
+		base, quote := path[i], path[i+1]
+		if level.Inverted {
+			base, quote = quote, base
+		}
+		hops[i] = route.Hop{
+			From:       path[i],
+			To:         path[i+1],
+			Base:       base,
+			Quote:      quote,
+			Venue:      level.Venue,
+			FeeBps:     level.FeeBps,
+			Price:      level.Price,
+			ExactPrice: exactPrices[i],
+		}
+	}
+	return route.New(route.DirectionOf(isAsk), hops)
+}
+
+func exactLevelPrices(graph Graph, path []string, levelIndices []int, isAsk bool) []decimal.Decimal {
+	prices := make([]decimal.Decimal, len(levelIndices))
+	for i, levelIdx := range levelIndices {
+		prices[i] = hopOrders(graph, path[i], path[i+1], isAsk)[levelIdx].exactPrice()
+	}
+	return prices
+}
+
 // RouteCandidate represents a potential trading route with tracking info
 type RouteCandidate struct {
+	path         []string
+	pathIndex    int
 	prices       []float64
+	venues       []string
+	feesBps      []float64
 	levelIndices []int // track which level index in each hop
 	finalPrice   float64
-	maxVolume    float64
+	maxVolume    float64 // in base units
 }
 
-func getAllPriceVolumeCombinations(graph Graph, path []string, isAsk bool) []PriceVolumeCombo {
+func getHopLevels(graph Graph, path []string, isAsk bool) [][]Level {
 	if len(path) < 2 {
-		return []PriceVolumeCombo{}
+		return nil
 	}
 	// Get all order levels for each hop in the path
 	var allHopLevels [][]Level
 	for i := 0; i < len(path)-1; i++ {
-		fromToken := path[i]
-		toToken := path[i+1]
-		pair, exists := graph[fromToken][toToken]
-		if !exists {
-			return []PriceVolumeCombo{}
+		if _, exists := graph[path[i]][path[i+1]]; !exists {
+			return nil
 		}
-
-		var hopLevels []Level
-
-		if isAsk {
-			for _, order := range pair.AskOrders {
-				hopLevels = append(hopLevels, Level{order.Price, order.Amount})
-			}
-		} else {
-			for _, order := range pair.BidOrders {
-				hopLevels = append(hopLevels, Level{order.Price, order.Amount})
-			}
-		}
-		allHopLevels = append(allHopLevels, hopLevels)
+		allHopLevels = append(allHopLevels, hopOrders(graph, path[i], path[i+1], isAsk))
 	}
-
-	return generateCombinationsWithVolumeTracking(allHopLevels, isAsk)
+	return allHopLevels
 }
 
-func generateCombinationsWithVolumeTracking(allHopLevels [][]Level, isAsk bool) []PriceVolumeCombo {
-
-	if len(allHopLevels) == 0 {
-		return []PriceVolumeCombo{}
+func generateAllRouteCandidates(path []string, allHopLevels [][]Level, hopIndex int, currentPrices []float64, currentIndices []int, candidates *[]RouteCandidate, s *search) {
+	if s.options.MaxCandidates > 0 && len(*candidates) >= s.options.MaxCandidates {
+		s.truncation.CandidatesLimited = true
+		return
 	}
-	// Generate all possible route candidates first
-	var candidates []RouteCandidate
-	generateAllRouteCandidates(allHopLevels, 0, []float64{}, []int{}, &candidates)
-	sortCandidatesByPrice(candidates, isAsk)
-
-	remainingVolumes := make([][]float64, len(allHopLevels))
-	for i, hopLevels := range allHopLevels {
-		remainingVolumes[i] = make([]float64, len(hopLevels))
-		for j, level := range hopLevels {
-			remainingVolumes[i][j] = level.Amount
-		}
-	}
-	// Apply greedy selection with volume tracking
-	var result []PriceVolumeCombo
-	for _, candidate := range candidates {
-		// Calculate maximum volume we can use for this route
-		maxUsableVolume := candidate.maxVolume
-		for hopIdx, levelIdx := range candidate.levelIndices {
-			available := remainingVolumes[hopIdx][levelIdx]
-			if available < maxUsableVolume {
-				maxUsableVolume = available
-			}
-		}
-
-		if maxUsableVolume > 0 {
-			for hopIdx, levelIdx := range candidate.levelIndices {
-				remainingVolumes[hopIdx][levelIdx] -= maxUsableVolume
-			}
-			result = append(result, PriceVolumeCombo{
-				prices: candidate.prices,
-				depth:  maxUsableVolume,
-			})
-		}
+	if s.expired() {
+		return
 	}
-	return result
-}
-
-func generateAllRouteCandidates(allHopLevels [][]Level, hopIndex int, currentPrices []float64, currentIndices []int, candidates *[]RouteCandidate) {
 	if hopIndex >= len(allHopLevels) {
 		finalPrice := 1.0
 		for _, price := range currentPrices {
 			finalPrice *= price
 		}
+		// NOTE: level amounts are in each hop's From token, convert back to base units
 		maxVolume := math.Inf(1)
+		conversion := 1.0
 		for hopIdx, levelIdx := range currentIndices {
-			levelVolume := allHopLevels[hopIdx][levelIdx].Amount
+			levelVolume := allHopLevels[hopIdx][levelIdx].Amount / conversion
 			if levelVolume < maxVolume {
 				maxVolume = levelVolume
 			}
+			conversion *= currentPrices[hopIdx]
 		}
 		if maxVolume != math.Inf(1) && maxVolume > 0 {
 			pricesCopy := make([]float64, len(currentPrices))
 			copy(pricesCopy, currentPrices)
 			indicesCopy := make([]int, len(currentIndices))
 			copy(indicesCopy, currentIndices)
+			venues := make([]string, len(currentIndices))
+			feesBps := make([]float64, len(currentIndices))
+			for hopIdx, levelIdx := range currentIndices {
+				venues[hopIdx] = allHopLevels[hopIdx][levelIdx].Venue
+				feesBps[hopIdx] = allHopLevels[hopIdx][levelIdx].FeeBps
+			}
 
 			*candidates = append(*candidates, RouteCandidate{
+				path:         path,
 				prices:       pricesCopy,
+				venues:       venues,
+				feesBps:      feesBps,
 				levelIndices: indicesCopy,
 				finalPrice:   finalPrice,
 				maxVolume:    maxVolume,
@@ -307,7 +527,7 @@ func generateAllRouteCandidates(allHopLevels [][]Level, hopIndex int, currentPri
 		copy(newIndices, currentIndices)
 		newIndices = append(newIndices, levelIdx)
 
-		generateAllRouteCandidates(allHopLevels, hopIndex+1, newPrices, newIndices, candidates)
+		generateAllRouteCandidates(path, allHopLevels, hopIndex+1, newPrices, newIndices, candidates, s)
 	}
 }
 
@@ -338,11 +558,17 @@ func mergeVirtualLevels(levels []VirtualLevel) []VirtualLevel {
 	var merged []VirtualLevel
 	current := levels[0]
 	for i := 1; i < len(levels); i++ {
-		if math.Abs(levels[i].Price-current.Price) < 1e-8 {
+		if samePrice(levels[i], current) {
 			// Same price, merge quantities
+			// NOTE: the parts keep each route's levels and amount for the per-hop orders
+			if current.Parts == nil {
+				current.Parts = []VirtualLevel{current}
+			}
+			current.Parts = append(current.Parts, levels[i])
 			current.Amount += levels[i].Amount
+			current.ExactAmount = current.ExactAmount.Add(levels[i].ExactAmount)
 			if levels[i].Price < current.Price {
-				current.Route = levels[i].Route
+				current.Route, current.Path = levels[i].Route, levels[i].Path
 			}
 		} else {
 			merged = append(merged, current)
@@ -353,6 +579,14 @@ func mergeVirtualLevels(levels []VirtualLevel) []VirtualLevel {
 	return merged
 }
 
+// NOTE: exact prices must match exactly, otherwise merging would misprice the exact amount
+func samePrice(a, b VirtualLevel) bool {
+	if a.ExactPrice.IsSet() && b.ExactPrice.IsSet() {
+		return a.ExactPrice.Cmp(b.ExactPrice) == 0
+	}
+	return math.Abs(a.Price-b.Price) < 1e-8
+}
+
 func findBestRouteFromVirtualOrderbook(levels []VirtualLevel, targetAmount float64) (float64, []VirtualLevel) {
 	if len(levels) == 0 {
 		return math.NaN(), []VirtualLevel{}
@@ -378,10 +612,15 @@ func findBestRouteFromVirtualOrderbook(levels []VirtualLevel, targetAmount float
 
 		remainingAmount -= executed
 		bestRoute = append(bestRoute, VirtualLevel{
-			Route:       level.Route,
-			Price:       level.Price,
-			Amount:      executed,
-			LevelPrices: level.LevelPrices,
+			Route:        level.Route,
+			Path:         level.Path,
+			Price:        level.Price,
+			Amount:       executed,
+			LevelPrices:  level.LevelPrices,
+			LevelVenues:  level.LevelVenues,
+			LevelFeesBps: level.LevelFeesBps,
+			LevelIndices: level.LevelIndices,
+			Parts:        level.Parts,
 		})
 	}
 
@@ -393,20 +632,47 @@ func findBestRouteFromVirtualOrderbook(levels []VirtualLevel, targetAmount float
 	return effectivePrice, bestRoute
 }
 
+// findBestRouteFromVirtualOrderbookExact walks the book like findBestRouteFromVirtualOrderbook
+// but settles with the exact level prices and amounts. An unset result means no level was executed.
+func findBestRouteFromVirtualOrderbookExact(levels []VirtualLevel, targetAmount decimal.Decimal) (decimal.Decimal, []VirtualLevel) {
+	bestRoute := make([]VirtualLevel, 0)
+	remainingAmount := targetAmount
+	totalCost := decimal.New(0)
+	executedAmount := decimal.New(0)
+	for _, level := range levels {
+		if remainingAmount.Sign() <= 0 {
+			break
+		}
+		executed := decimal.Min(remainingAmount, level.ExactAmount)
+		executedAmount = executedAmount.Add(executed)
+		totalCost = totalCost.Add(executed.Mul(level.ExactPrice))
+		remainingAmount = remainingAmount.Sub(executed)
+
+		filled := level
+		filled.Amount = executed.Float64()
+		filled.ExactAmount = executed
+		bestRoute = append(bestRoute, filled)
+	}
+	if executedAmount.Sign() <= 0 {
+		return decimal.Decimal{}, bestRoute
+	}
+	return totalCost.Quo(executedAmount), bestRoute
+}
+
 func printVirtualOrderbook(virtualPair VirtualTradingPair) {
 	fmt.Printf("%s %s\n", virtualPair.Base, virtualPair.Quote)
 	fmt.Printf("%d\n", len(virtualPair.AskOrders))
 
 	for _, order := range virtualPair.AskOrders {
-		fmt.Printf("%.8f %.0f (%s) [Level Prices: %v]\n",
-			order.Price, order.Amount, formatRoute(order.Route), order.LevelPrices)
+		fmt.Printf("%.8f %.0f (%s) [Level Prices: %v]%s\n",
+			order.Price, order.Amount, formatRoute(order.Route), order.LevelPrices, formatFees(order))
 	}
 
 	fmt.Printf("%d\n", len(virtualPair.BidOrders))
 
 	for _, order := range virtualPair.BidOrders {
-		fmt.Printf("%.8f %.0f (%s) [Level Prices: %v]\n",
-			order.Price, order.Amount, formatRoute(order.Route), order.LevelPrices)
+		fmt.Printf("%.8f %.0f (%s) [Level Prices: %v]%s\n",
+			order.Price, order.Amount, formatRoute(order.Route), order.LevelPrices, formatFees(order))
 	}
 }
 
@@ -415,8 +681,8 @@ func printBestRouteOutput(bestBidRoute, bestAskRoute []VirtualLevel, askPrice, b
 	fmt.Println("ASK Routes:")
 	if len(bestAskRoute) > 0 {
 		for i, route := range bestAskRoute {
-			fmt.Printf("  %d. %s (Price: %.8f, Amount: %.8f %s) [Level Prices: %v]\n ",
-				i+1, formatRoute(route.Route), route.Price, route.Amount, route.Route[len(route.Route)-1], route.LevelPrices)
+			fmt.Printf("  %d. %s (Price: %.8f, Amount: %.8f %s) [Level Prices: %v]%s\n ",
+				i+1, formatRoute(route.Route), route.Price, route.Amount, route.Route[len(route.Route)-1], route.LevelPrices, formatFees(route))
 		}
 	} else {
 		fmt.Println("  NO_ROUTE")
@@ -425,8 +691,8 @@ func printBestRouteOutput(bestBidRoute, bestAskRoute []VirtualLevel, askPrice, b
 	fmt.Println("BID Routes:")
 	if len(bestBidRoute) > 0 {
 		for i, route := range bestBidRoute {
-			fmt.Printf("  %d. %s (Price: %.8f, Amount: %.8f %s) [Level Prices: %v]\n",
-				i+1, formatRoute(route.Route), route.Price, route.Amount, route.Route[0], route.LevelPrices)
+			fmt.Printf("  %d. %s (Price: %.8f, Amount: %.8f %s) [Level Prices: %v]%s\n",
+				i+1, formatRoute(route.Route), route.Price, route.Amount, route.Route[0], route.LevelPrices, formatFees(route))
 		}
 	} else {
 		fmt.Println("  NO_ROUTE")
@@ -435,179 +701,199 @@ func printBestRouteOutput(bestBidRoute, bestAskRoute []VirtualLevel, askPrice, b
 	fmt.Println("---")
 }
 
-func formatRoute(route []string) string {
-	return strings.Join(route, "->")
-}
-
-func parseOrderBook(lines []string, lineIdx *int, orderType, pairBase, pairQuote string) ([]Level, error) {
-	if *lineIdx >= len(lines) {
-		return nil, fmt.Errorf("missing %s orders count for pair %s/%s", orderType, pairBase, pairQuote)
+func printExecutionPlan(plan ExecutionPlan) {
+	side := "BID"
+	if plan.IsAsk {
+		side = "ASK"
 	}
-	count, err := strconv.Atoi(strings.TrimSpace(lines[*lineIdx]))
-	if err != nil {
-		return nil, fmt.Errorf("invalid %s orders count: %s", orderType, lines[*lineIdx])
+	fmt.Printf("%s Split (Filled: %.8f / %.8f %s):\n", side, plan.FilledAmount, plan.TargetAmount, plan.Base)
+	if len(plan.Routes) == 0 {
+		fmt.Println("  NO_ROUTE")
 	}
-	*lineIdx++
-	var levels []Level
-	for j := 0; j < count; j++ {
-		if *lineIdx >= len(lines) {
-			return nil, fmt.Errorf("missing %s order %d for pair %s/%s", orderType, j+1, pairBase, pairQuote)
-		}
-		orderParts := strings.Fields(lines[*lineIdx])
-		if len(orderParts) < 2 {
-			return nil, fmt.Errorf("invalid %s order format: %s", orderType, lines[*lineIdx])
-		}
-		price, _ := strconv.ParseFloat(orderParts[0], 64)
-		amount, _ := strconv.ParseFloat(orderParts[1], 64)
-		levels = append(levels, Level{Price: price, Amount: amount})
-		*lineIdx++
+	for i, split := range plan.Routes {
+		fmt.Printf("  %d. %s (Price: %.8f, Amount: %.8f %s)\n",
+			i+1, split.Path, split.Price, split.Amount, plan.Base)
 	}
-	return levels, nil
+	for _, hop := range plan.Hops {
+		fmt.Printf("    %s->%s level %d%s @ %.8f (fee %.2f bps): %.8f %s -> %.8f %s\n",
+			hop.From, hop.To, hop.LevelIndex, formatVenue(hop.Venue), hop.Price, hop.FeeBps, hop.Amount, hop.From, hop.Proceeds, hop.To)
+	}
+	fmt.Printf("%s Price: %.8f\n", side, plan.Price)
 }
 
-func runTestCase(input string) {
-	lines := strings.Split(strings.TrimSpace(input), "\n")
-	if len(lines) < 2 {
-		fmt.Printf("Invalid test case format: not enough lines\n")
-		return
+// printOrderPlan prints the child order of every hop, then the pair orders, dust and rejected fills
+func printOrderPlan(side string, plan OrderPlan) {
+	fmt.Printf("%s Orders:\n", side)
+	for _, child := range plan.Children {
+		fmt.Printf("  fill %d hop %d: %s %.8f %s/%s%s @ %.8f = %.8f %s\n",
+			child.Fill+1, child.Hop+1, child.Side, child.BaseAmount, child.Base, child.Quote, formatVenue(child.Venue), child.Price, child.QuoteAmount, child.Quote)
 	}
-	parts := strings.Fields(lines[0])
-	if len(parts) < 3 {
-		fmt.Printf("Invalid test case format: first line should have base quote amount\n")
-		return
+	for i, pair := range plan.Pairs {
+		fmt.Printf("  %d. %s\n", i+1, pair)
 	}
-	baseCurrency := parts[0]
-	quoteCurrency := parts[1]
-	amount, err := strconv.ParseFloat(parts[2], 64)
-	if err != nil {
-		fmt.Printf("Invalid amount: %s\n", parts[2])
-		return
+	for _, leftover := range plan.Dust {
+		fmt.Printf("  dust: %.8f %s\n", leftover.Amount, leftover.Token)
 	}
-	n, err := strconv.Atoi(lines[1])
-	if err != nil {
-		fmt.Printf("Invalid test case format: second line should be a number\n")
-		return
+	for _, rejected := range plan.Rejected {
+		fmt.Printf("  rejected fill %d %s (%.8f): %s\n", rejected.Fill+1, formatRoute(rejected.Route), rejected.Amount, rejected.Reason)
 	}
-	var pairs []TradingPair
-	lineIdx := 2
-	for i := 0; i < n; i++ {
-		if lineIdx >= len(lines) {
-			fmt.Printf("Not enough lines for pair %d\n", i+1)
-			return
-		}
-		pairParts := strings.Fields(lines[lineIdx])
-		if len(pairParts) < 2 {
-			fmt.Printf("Invalid pair format at line %d: %s\n", lineIdx+1, lines[lineIdx])
-			return
-		}
-		pairBase := pairParts[0]
-		pairQuote := pairParts[1]
-		lineIdx++
-		askOrders, err := parseOrderBook(lines, &lineIdx, "ask", pairBase, pairQuote)
-		if err != nil {
-			fmt.Printf("Error parsing ask levels: %v\n", err)
-			return
+}
+
+// formatFees is empty when the route pays no fees and has no venues so plain output is unchanged
+func formatFees(level VirtualLevel) string {
+	var out string
+	for _, venue := range level.LevelVenues {
+		if venue != "" {
+			out += fmt.Sprintf(" [Level Venues: %v]", level.LevelVenues)
+			break
 		}
-		bidOrders, err := parseOrderBook(lines, &lineIdx, "bid", pairBase, pairQuote)
-		if err != nil {
-			fmt.Printf("Error parsing bid levels: %v\n", err)
-			return
+	}
+	for _, bps := range level.LevelFeesBps {
+		if bps > 0 {
+			out += fmt.Sprintf(" [Level Fees (bps): %v]", level.LevelFeesBps)
+			break
 		}
-		pair := TradingPair{
-			Base:      pairBase,
-			Quote:     pairQuote,
-			AskOrders: askOrders,
-			BidOrders: bidOrders,
+	}
+	return out
+}
+
+func formatVenue(venue string) string {
+	if venue == "" {
+		return ""
+	}
+	return " on " + venue
+}
+
+func formatRoute(route []string) string {
+	return strings.Join(route, "->")
+}
+
+// parsePairOptions reads the optional trailing "fee=<bps>", "venue=<name>" and rule fields
+// ("tick=", "lot=", "min-qty=", "min-notional=") of a pair line
+func parsePairOptions(options []string) (string, float64, PairRules, error) {
+	var venue string
+	var feeBps float64
+	var rules PairRules
+	for _, option := range options {
+		if bps, ok, err := fees.ParseBps(option); ok {
+			if err != nil {
+				return "", 0, PairRules{}, err
+			}
+			feeBps = bps
+		} else if name, ok := strings.CutPrefix(option, "venue="); ok {
+			venue = name
+		} else if ok, err := parseRuleOption(option, &rules); ok {
+			if err != nil {
+				return "", 0, PairRules{}, err
+			}
+		} else {
+			return "", 0, PairRules{}, fmt.Errorf("unknown pair option: %s", option)
 		}
-		pairs = append(pairs, pair)
 	}
-	graph := buildGraph(pairs)
+	return venue, feeBps, rules, nil
+}
+
+// runTestCase prints the virtual orderbook and executions of a scenario and reports whether its expectations hold
+func runTestCase(scenario Scenario) bool {
+	baseCurrency := scenario.Base
+	quoteCurrency := scenario.Quote
 	fmt.Printf("Building virtual orderbook for %s/%s...\n", baseCurrency, quoteCurrency)
-	virtualOrderbook := buildVirtualOrderbook(graph, baseCurrency, quoteCurrency)
+	result, err := Solve(scenario)
+	if !partialFill(err) {
+		fmt.Printf("Test Case: %s -> %s (Amount: %.0f)\n", baseCurrency, quoteCurrency, scenario.Amount)
+		fmt.Printf("Error: %v\n", err)
+		return reportMismatches(scenario.Check(result, err))
+	}
+	fmt.Printf("Found %d paths for %s->%s\n: %v\n", len(result.Paths), baseCurrency, quoteCurrency, result.Paths)
+	if result.Truncation.Truncated() {
+		fmt.Printf("Truncated: %s\n", result.Truncation)
+	}
 	fmt.Println("=== Virtual Orderbook ===")
-	printVirtualOrderbook(virtualOrderbook)
+	printVirtualOrderbook(result.Orderbook)
 	fmt.Println("---")
 
 	// Execute on virtual orderbook to find best routes
-	fmt.Printf("Executing %.0f %s on virtual orderbook...\n", amount, baseCurrency)
-	askPrice, bestAskRoute := findBestRouteFromVirtualOrderbook(virtualOrderbook.AskOrders, amount)
-	bidPrice, bestBidRoute := findBestRouteFromVirtualOrderbook(virtualOrderbook.BidOrders, amount)
+	amountToken := baseCurrency
+	if scenario.Denomination == QuoteAmount {
+		amountToken = quoteCurrency
+	}
+	fmt.Printf("Executing %.0f %s on virtual orderbook...\n", scenario.Amount, amountToken)
 
 	// Print results
-	fmt.Printf("Test Case: %s -> %s (Amount: %.0f)\n", baseCurrency, quoteCurrency, amount)
-	printBestRouteOutput(bestBidRoute, bestAskRoute, askPrice, bidPrice)
+	fmt.Printf("Test Case: %s -> %s (Amount: %.0f)\n", baseCurrency, quoteCurrency, scenario.Amount)
+	printBestRouteOutput(result.BidRoute, result.AskRoute, result.AskPrice, result.BidPrice)
+	fmt.Printf("ASK Fill: %.8f %s for %.8f %s\n", result.AskFill.BaseAmount, baseCurrency, result.AskFill.QuoteAmount, quoteCurrency)
+	fmt.Printf("BID Fill: %.8f %s for %.8f %s\n", result.BidFill.BaseAmount, baseCurrency, result.BidFill.QuoteAmount, quoteCurrency)
+	fmt.Printf("ASK Impact: %s\n", result.AskImpact)
+	fmt.Printf("BID Impact: %s\n", result.BidImpact)
+	printOrderPlan("ASK", result.AskOrders)
+	printOrderPlan("BID", result.BidOrders)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+
+	fmt.Println("=== Exact Settlement ===")
+	fmt.Printf("ASK Price: %s\n", result.ExactAskPrice.StringFixed(18))
+	fmt.Printf("BID Price: %s\n", result.ExactBidPrice.StringFixed(18))
+
+	fmt.Println("=== Min-Cost Split ===")
+	printExecutionPlan(result.AskPlan)
+	printExecutionPlan(result.BidPlan)
+	return reportMismatches(scenario.Check(result, err))
+}
+
+func reportMismatches(mismatches []string) bool {
+	for _, mismatch := range mismatches {
+		fmt.Printf("FAIL %s\n", mismatch)
+	}
 	fmt.Println("---")
+	return len(mismatches) == 0
 }
 
-func RunTestCasesFromFile(filename string) {
+// RunTestCasesFromFile runs every test case of filename and returns false when any of them
+// fails its expectations. Cases without expectations only fail on an error.
+func RunTestCasesFromFile(filename string) bool {
+	return RunTestCasesWithOptions(filename, Options{})
+}
+
+func RunTestCasesWithOptions(filename string, options Options) bool {
 	file, err := os.Open(filename)
 	if err != nil {
 		fmt.Printf("Error opening file: %v\n", err)
-		return
+		return false
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	var currentTestCase strings.Builder
+	inputs, err := splitScenarios(file)
+	if err != nil {
+		fmt.Printf("Error reading file: %v\n", err)
+		return false
+	}
 	testCaseCount := 0
 	passedCount := 0
+	uncheckedCount := 0
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(strings.TrimSpace(line), "#") || strings.TrimSpace(line) == "" {
+	for _, input := range inputs {
+		testCaseCount++
+		fmt.Printf("=== Test Case %d ===\n", testCaseCount)
+		scenario, err := parseScenario(input)
+		if err != nil {
+			fmt.Printf("Invalid test case: %v\n", err)
+			fmt.Println("---")
 			continue
 		}
-		parts := strings.Fields(line)
-		if len(parts) == 3 {
-			isCurrencyPair := true
-			for i, part := range parts[:2] {
-				if i < 2 {
-					for _, char := range part {
-						if char < 'A' || char > 'Z' {
-							isCurrencyPair = false
-							break
-						}
-					}
-				}
-				if !isCurrencyPair {
-					break
-				}
-			}
-
-			// Check if third part is a valid number
-			if isCurrencyPair {
-				if _, err := strconv.ParseFloat(parts[2], 64); err != nil {
-					isCurrencyPair = false
-				}
-			}
-			if isCurrencyPair {
-				if currentTestCase.Len() > 0 {
-					testCaseCount++
-					fmt.Printf("=== Test Case %d ===\n", testCaseCount)
-					runTestCase(currentTestCase.String())
-					passedCount++
-				}
-
-				// Start new test case
-				currentTestCase.Reset()
-				currentTestCase.WriteString(line)
-				currentTestCase.WriteString("\n")
-				continue
+		scenario.Options = options
+		if runTestCase(scenario) {
+			passedCount++
+			if len(scenario.Expect) == 0 {
+				uncheckedCount++
 			}
 		}
-		currentTestCase.WriteString(line)
-		currentTestCase.WriteString("\n")
-	}
-
-	if currentTestCase.Len() > 0 {
-		testCaseCount++
-		fmt.Printf("=== Test Case %d ===\n", testCaseCount)
-		runTestCase(currentTestCase.String())
-		passedCount++
 	}
 
 	fmt.Printf("\n=== SUMMARY ===\n")
 	fmt.Printf("Total test cases: %d\n", testCaseCount)
-	fmt.Printf("Passed: %d\n", passedCount)
+	fmt.Printf("Passed: %d (%d without expectations)\n", passedCount, uncheckedCount)
 	fmt.Printf("Failed: %d\n", testCaseCount-passedCount)
+	return passedCount == testCaseCount
 }
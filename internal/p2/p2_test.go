@@ -0,0 +1,171 @@
+package p2
+
+import (
+	"math"
+	"math/rand"
+	"orderbook-pathfinder/internal/route"
+	"orderbook-pathfinder/internal/route/routetest"
+	"testing"
+	"time"
+)
+
+// randomBooks gives routetest's random markets five levels per side
+func randomBooks(tokens int, r *rand.Rand) []TradingPair {
+	var pairs []TradingPair
+	for _, market := range routetest.RandomMarkets(tokens, r) {
+		pair := marketPair(market.Base, market.Quote, market.Mid, r)
+		pair.Venue, pair.FeeBps = market.Venue, market.FeeBps
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// checkMarketPrices checks a route's hops against the levels of the markets they name
+func checkMarketPrices(t *testing.T, pairs []TradingPair, base, quote string, path route.Route, price float64) {
+	t.Helper()
+	routetest.CheckPrices(t, path, base, quote, price, func(hop route.Hop) ([]float64, float64, bool) {
+		for _, pair := range pairs {
+			if pair.Base == hop.Base && pair.Quote == hop.Quote && pair.Venue == hop.Venue {
+				levels := pair.AskOrders
+				if hop.Side == "sell" {
+					levels = pair.BidOrders
+				}
+				prices := make([]float64, len(levels))
+				for i, level := range levels {
+					prices[i] = level.Price
+				}
+				return prices, pair.FeeBps, true
+			}
+		}
+		return nil, 0, false
+	})
+}
+
+func TestVirtualLevelPricesAreTheProductOfTheirLevels(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		pairs := randomBooks(3+r.Intn(4), r)
+		book := BuildVirtualOrderbook(BuildGraph(pairs), "T0", "T1")
+		for _, levels := range [][]VirtualLevel{book.AskOrders, book.BidOrders} {
+			for _, level := range levels {
+				checkMarketPrices(t, pairs, "T0", "T1", level.Path, level.Price)
+				for _, part := range level.Parts {
+					checkMarketPrices(t, pairs, "T0", "T1", part.Path, part.Price)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitRoutePricesAreTheProductOfTheirLevels(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		pairs := randomBooks(3+r.Intn(4), r)
+		amount := 50 + 500*r.Float64()
+		for _, isAsk := range []bool{true, false} {
+			plan := SplitOrder(pairs, "T0", "T1", amount, isAsk)
+			for _, split := range plan.Routes {
+				checkMarketPrices(t, pairs, "T0", "T1", split.Path, split.Price)
+				if len(split.Hops) != len(split.Path.Hops) {
+					t.Fatalf("%s: %d hop fills for %d hops", split.Path, len(split.Hops), len(split.Path.Hops))
+				}
+				// Hops chain in execution order, the base end carries the amount of the route
+				baseAmount := split.Hops[0].Amount
+				if isAsk {
+					baseAmount = split.Hops[len(split.Hops)-1].Proceeds
+				}
+				if math.Abs(baseAmount-split.Amount) > 1e-9*split.Amount {
+					t.Fatalf("%s: base end carries %.12g, route amount %.12g", split.Path, baseAmount, split.Amount)
+				}
+				for k, fill := range split.Hops {
+					if fill.Hop != split.Path.Hops[k] {
+						t.Fatalf("%s hop %d: fill of %s->%s", split.Path, k+1, fill.From, fill.To)
+					}
+					// A sell receives Amount * Price, a buy pays Proceeds * Price, see route.Hop
+					priced, other := fill.Amount*fill.Price, fill.Proceeds
+					if isAsk {
+						priced, other = fill.Proceeds*fill.Price, fill.Amount
+					}
+					if math.Abs(priced-other) > 1e-9*other {
+						t.Fatalf("%s hop %d: pays %.12g for %.12g at %.12g", split.Path, k+1, fill.Amount, fill.Proceeds, fill.Price)
+					}
+					if k > 0 && math.Abs(split.Hops[k-1].Proceeds-fill.Amount) > 1e-9*fill.Amount {
+						t.Fatalf("%s hop %d pays %.12g, hop %d received %.12g", split.Path, k+1, fill.Amount, k, split.Hops[k-1].Proceeds)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSplitReroutesASharedLevel(t *testing.T) {
+	// KNC->BTC->USDT->ETH is the best path and takes the whole USDT/ETH bid, the virtual book is
+	// then left with paths through the wide asks. The best split sends 0.1 BTC on to USDT and
+	// 0.9 BTC to ETH, the USDT/ETH bid keeps room for the 0.9 USDT of KNC->USDT->ETH: 1.81 ETH.
+	quoted := func(base, quote string, bid float64) TradingPair {
+		return TradingPair{Base: base, Quote: quote,
+			BidOrders: []Level{{Price: bid, Amount: 1}},
+			AskOrders: []Level{{Price: 10, Amount: 1}}}
+	}
+	pairs := []TradingPair{
+		quoted("KNC", "BTC", 1),
+		quoted("BTC", "USDT", 1),
+		quoted("USDT", "ETH", 1),
+		quoted("BTC", "ETH", 0.9),
+		quoted("KNC", "USDT", 0.9),
+	}
+	plan := SplitOrder(pairs, "KNC", "ETH", 2, false)
+	if math.Abs(plan.FilledAmount-2) > 1e-9 || math.Abs(plan.TotalCost-1.81) > 1e-9 {
+		t.Fatalf("split sells %.8f KNC for %.8f ETH, want 2 for 1.81", plan.FilledAmount, plan.TotalCost)
+	}
+	for _, split := range plan.Routes {
+		checkMarketPrices(t, pairs, "KNC", "ETH", split.Path, split.Price)
+	}
+
+	book := BuildVirtualOrderbook(BuildGraph(pairs), "KNC", "ETH")
+	price, _ := FindBestRouteFromVirtualOrderbook(book.BidOrders, 2)
+	if plan.Price <= price {
+		t.Fatalf("split sells at %.8f, the virtual book at %.8f", plan.Price, price)
+	}
+}
+
+func TestSplitCostsNoMoreThanTheVirtualBook(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 200; i++ {
+		pairs := randomBooks(3+r.Intn(4), r)
+		book := BuildVirtualOrderbook(BuildGraph(pairs), "T0", "T1")
+		amount := 20 + 200*r.Float64()
+		for _, side := range []struct {
+			isAsk  bool
+			levels []VirtualLevel
+		}{{true, book.AskOrders}, {false, book.BidOrders}} {
+			price, fill := FindBestRouteFromVirtualOrderbook(side.levels, amount)
+			filled := 0.0
+			for _, level := range fill {
+				filled += level.Amount
+			}
+			cost := price * filled
+			plan := SplitOrder(pairs, "T0", "T1", amount, side.isAsk)
+			if filled < amount-1e-9*amount {
+				continue
+			}
+			if plan.FilledAmount < amount-1e-9*amount {
+				t.Fatalf("case %d ask=%v: split fills %.12g of %.12g, the virtual book all of it", i, side.isAsk, plan.FilledAmount, amount)
+			}
+			if (side.isAsk && plan.TotalCost > cost*(1+1e-9)) || (!side.isAsk && plan.TotalCost < cost*(1-1e-9)) {
+				t.Fatalf("case %d ask=%v: split trades %.12g for %.12g, the virtual book %.12g", i, side.isAsk, amount, plan.TotalCost, cost)
+			}
+		}
+	}
+}
+
+func TestSearchStagesShareTheDeadline(t *testing.T) {
+	s := newSearch(Options{Timeout: time.Millisecond})
+	time.Sleep(2 * time.Millisecond)
+	if stage := s.stage(); !stage.expired() {
+		t.Fatal("a later stage got a fresh timeout")
+	}
+	if s.truncation.TimedOut {
+		t.Fatal("the stage's timeout was reported on the first search")
+	}
+}
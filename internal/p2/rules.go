@@ -0,0 +1,230 @@
+package p2
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PairRules are the order rules of a market, a zero field is not enforced. Amounts are in the
+// market's base and MinNotional in its quote.
+type PairRules struct {
+	TickSize    float64
+	LotStep     float64
+	MinQty      float64
+	MinNotional float64
+}
+
+// IsZero reports whether no rule is set
+func (r PairRules) IsZero() bool {
+	return r == PairRules{}
+}
+
+// Validate rejects negative or non-finite rules
+func (r PairRules) Validate() error {
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{{"tick", r.TickSize}, {"lot", r.LotStep}, {"min-qty", r.MinQty}, {"min-notional", r.MinNotional}} {
+		if field.value < 0 || math.IsNaN(field.value) || math.IsInf(field.value, 0) {
+			return fmt.Errorf("invalid %s: %v", field.name, field.value)
+		}
+	}
+	return nil
+}
+
+// parseRuleOption reads a "tick=", "lot=", "min-qty=" or "min-notional=" pair option into rules,
+// ok is false for any other option
+func parseRuleOption(option string, rules *PairRules) (bool, error) {
+	name, value, _ := strings.Cut(option, "=")
+	var target *float64
+	switch name {
+	case "tick":
+		target = &rules.TickSize
+	case "lot":
+		target = &rules.LotStep
+	case "min-qty":
+		target = &rules.MinQty
+	case "min-notional":
+		target = &rules.MinNotional
+	default:
+		return false, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(number > 0) || math.IsInf(number, 0) {
+		return true, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	*target = number
+	return true, nil
+}
+
+// roundLot rounds a base amount down to the lot step
+func (r PairRules) roundLot(amount float64) float64 {
+	if r.LotStep <= 0 {
+		return amount
+	}
+	// NOTE: nudge by a relative epsilon so 0.3/0.1 doesn't floor to 2 lots
+	return math.Floor(amount/r.LotStep*(1+1e-12)) * r.LotStep
+}
+
+// roundTick snaps a book price to the tick, it only removes float noise from inverted levels
+func (r PairRules) roundTick(price float64) float64 {
+	if r.TickSize <= 0 {
+		return price
+	}
+	return math.Round(price/r.TickSize) * r.TickSize
+}
+
+// placeable reports why an order of amount base at price would be rejected, "" when it wouldn't
+func (r PairRules) placeable(amount, price float64) string {
+	switch {
+	case amount <= 0:
+		return "below one lot"
+	case r.MinQty > 0 && amount < r.MinQty:
+		return fmt.Sprintf("%v below min qty %v", amount, r.MinQty)
+	case r.MinNotional > 0 && amount*price < r.MinNotional*(1-1e-12):
+		return fmt.Sprintf("notional %v below min notional %v", amount*price, r.MinNotional)
+	}
+	return ""
+}
+
+// TokenAmount is an amount of one token, e.g. rounding dust left in an intermediate token
+type TokenAmount struct {
+	Token  string
+	Amount float64
+}
+
+// roundChain applies the market rules to the child orders of one route, in execution order.
+// Each hop spends what the previous one received: lots are rounded down, what a hop can't
+// spend is dust in its input token, and the route is unplaceable when any order is rejected.
+// baseAmount is the virtual pair's base the rounded route executes.
+func roundChain(chain []ChildOrder, isAsk bool) (rounded []ChildOrder, dust []TokenAmount, baseAmount float64, reason string) {
+	rounded = make([]ChildOrder, len(chain))
+	copy(rounded, chain)
+	input := hopInput(chain[0])
+	for hop := range rounded {
+		child := &rounded[hop]
+		child.Price = child.Rules.roundTick(child.Price)
+		var spent, output float64
+		if child.Side == "sell" {
+			child.BaseAmount = child.Rules.roundLot(input)
+			spent = child.BaseAmount
+			output = child.BaseAmount * child.Price * (1 - child.FeeBps/10000)
+		} else {
+			child.BaseAmount = child.Rules.roundLot(input / (child.Price * (1 + child.FeeBps/10000)))
+			spent = child.BaseAmount * child.Price * (1 + child.FeeBps/10000)
+			output = child.BaseAmount
+		}
+		child.QuoteAmount = child.BaseAmount * child.Price
+		if why := child.Rules.placeable(child.BaseAmount, child.Price); why != "" {
+			return nil, nil, 0, fmt.Sprintf("%s %s/%s%s: %s", child.Side, child.Base, child.Quote, formatVenue(child.Venue), why)
+		}
+		// NOTE: what the first hop doesn't spend never leaves the account, it is unfilled not dust
+		if hop > 0 && input-spent > 1e-12 {
+			dust = append(dust, TokenAmount{hopInputToken(*child), input - spent})
+		}
+		if hop == 0 && !isAsk {
+			baseAmount = spent
+		}
+		input = output
+	}
+	if isAsk {
+		baseAmount = input
+	}
+	return rounded, dust, baseAmount, ""
+}
+
+// hopInput is what a child order spends, fee included
+func hopInput(child ChildOrder) float64 {
+	if child.Side == "sell" {
+		return child.BaseAmount
+	}
+	return child.QuoteAmount * (1 + child.FeeBps/10000)
+}
+
+func hopInputToken(child ChildOrder) string {
+	if child.Side == "sell" {
+		return child.Base
+	}
+	return child.Quote
+}
+
+func chainHasRules(chain []ChildOrder) bool {
+	for _, child := range chain {
+		if !child.Rules.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// PlaceableLevels drops the virtual levels whose orders the markets would reject even at the
+// level's full amount, a merged level keeps the parts that are placeable
+func PlaceableLevels(graph Graph, levels []VirtualLevel, isAsk bool) []VirtualLevel {
+	placeable := make([]VirtualLevel, 0, len(levels))
+	for _, level := range levels {
+		if len(level.Parts) == 0 {
+			if levelPlaceable(graph, level, isAsk) {
+				placeable = append(placeable, level)
+			}
+			continue
+		}
+		var parts []VirtualLevel
+		for _, part := range level.Parts {
+			if levelPlaceable(graph, part, isAsk) {
+				parts = append(parts, part)
+			}
+		}
+		switch {
+		case len(parts) == len(level.Parts):
+			placeable = append(placeable, level)
+		case len(parts) > 0:
+			kept := parts[0]
+			if len(parts) > 1 {
+				kept.Parts = parts
+				for _, part := range parts[1:] {
+					kept.Amount += part.Amount
+					kept.ExactAmount = kept.ExactAmount.Add(part.ExactAmount)
+				}
+			}
+			placeable = append(placeable, kept)
+		}
+	}
+	return placeable
+}
+
+func levelPlaceable(graph Graph, level VirtualLevel, isAsk bool) bool {
+	chain := routeChildOrders(graph, level, isAsk)
+	if !chainHasRules(chain) {
+		return true
+	}
+	_, _, _, reason := roundChain(chain, isAsk)
+	return reason == ""
+}
+
+// dropUnplaceable filters both sides of a virtual orderbook with PlaceableLevels, books
+// without market rules are left as they are
+func dropUnplaceable(graph Graph, virtualPair *VirtualTradingPair) {
+	if !graphHasRules(graph) {
+		return
+	}
+	virtualPair.AskOrders = PlaceableLevels(graph, virtualPair.AskOrders, true)
+	virtualPair.BidOrders = PlaceableLevels(graph, virtualPair.BidOrders, false)
+}
+
+// graphHasRules reports whether any level of graph carries market rules
+func graphHasRules(graph Graph) bool {
+	for _, neighbors := range graph {
+		for _, pair := range neighbors {
+			for _, levels := range [][]Level{pair.AskOrders, pair.BidOrders} {
+				for _, level := range levels {
+					if !level.Rules.IsZero() {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,470 @@
+package p2
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"orderbook-pathfinder/internal/decimal"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrUnknownToken          = errors.New("unknown token")
+	ErrNoRoute               = errors.New("no route")
+	ErrInsufficientLiquidity = errors.New("insufficient liquidity")
+	ErrLimitReached          = errors.New("limit reached")
+	ErrMalformedLevel        = errors.New("malformed level")
+	ErrInvalidPrice          = errors.New("negative or zero price")
+)
+
+// ParseError points at the input line that could not be parsed
+type ParseError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v: %q", e.Line, e.Err, e.Text)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Scenario is one test case: the books to route through and the amount to execute,
+// counted in base unless Denomination says quote
+type Scenario struct {
+	Base         string
+	Quote        string
+	Amount       float64
+	ExactAmount  decimal.Decimal
+	Denomination Denomination
+	AskLimit     Limit
+	BidLimit     Limit
+	Pairs        []TradingPair
+	Options      Options
+	Expect       []Expectation
+	Line         int
+}
+
+type Result struct {
+	Paths         [][]string
+	Orderbook     VirtualTradingPair
+	AskPrice      float64
+	BidPrice      float64
+	AskRoute      []VirtualLevel
+	BidRoute      []VirtualLevel
+	AskFill       Fill // AskPrice and AskRoute with both the base and quote amounts
+	BidFill       Fill
+	AskImpact     Impact
+	BidImpact     Impact
+	AskOrders     OrderPlan // AskRoute as per-hop orders
+	BidOrders     OrderPlan
+	ExactAskPrice decimal.Decimal
+	ExactBidPrice decimal.Decimal
+	AskPlan       ExecutionPlan
+	BidPlan       ExecutionPlan
+	Truncation    Truncation // every limit of scenario.Options that cut the search short
+}
+
+// scenarioInput holds the non-comment lines of one test case with their line numbers
+type scenarioInput struct {
+	lines   []string
+	lineNos []int
+}
+
+func (in scenarioInput) errorAt(i int, err error) error {
+	if len(in.lines) == 0 {
+		return err
+	}
+	if i >= len(in.lines) {
+		i = len(in.lines) - 1
+	}
+	return &ParseError{Line: in.lineNos[i], Text: in.lines[i], Err: err}
+}
+
+// ParseScenario parses every test case of r. Malformed test cases are skipped and
+// their *ParseError joined into the returned error, the others are still returned.
+func ParseScenario(r io.Reader) ([]Scenario, error) {
+	inputs, err := splitScenarios(r)
+	if err != nil {
+		return nil, err
+	}
+	var scenarios []Scenario
+	var errs []error
+	for _, input := range inputs {
+		scenario, err := parseScenario(input)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		scenarios = append(scenarios, scenario)
+	}
+	return scenarios, errors.Join(errs...)
+}
+
+// Solve builds the virtual orderbook of a scenario and executes its amount on both sides.
+// When a side can't be filled the partial result is returned with ErrInsufficientLiquidity,
+// or ErrLimitReached when the side's Limit stopped it.
+func Solve(scenario Scenario) (Result, error) {
+	if err := scenario.Options.Validate(); err != nil {
+		return Result{}, err
+	}
+	for _, limit := range []Limit{scenario.AskLimit, scenario.BidLimit} {
+		if err := limit.Validate(); err != nil {
+			return Result{}, err
+		}
+	}
+	graph, books := buildGraphWithOptions(scenario.Pairs, scenario.Options)
+	for _, token := range []string{scenario.Base, scenario.Quote} {
+		if _, ok := graph[token]; !ok {
+			return Result{}, fmt.Errorf("%w: %s", ErrUnknownToken, token)
+		}
+	}
+	s := newSearch(scenario.Options)
+	result := Result{Paths: findAllPaths(graph, scenario.Base, scenario.Quote, s)}
+	result.Truncation.Books = books
+	result.Truncation.merge(s.truncation)
+	if len(result.Paths) == 0 {
+		return result, fmt.Errorf("%w: %s -> %s", ErrNoRoute, scenario.Base, scenario.Quote)
+	}
+	result.Orderbook = buildVirtualOrderbook(graph, scenario.Base, scenario.Quote, s.stage())
+	result.AskFill = FillVirtualOrderbookWithLimit(result.Orderbook.AskOrders, scenario.Amount, scenario.Denomination, true, scenario.AskLimit)
+	result.BidFill = FillVirtualOrderbookWithLimit(result.Orderbook.BidOrders, scenario.Amount, scenario.Denomination, false, scenario.BidLimit)
+	result.AskPrice, result.AskRoute = result.AskFill.Price, result.AskFill.Levels
+	result.BidPrice, result.BidRoute = result.BidFill.Price, result.BidFill.Levels
+	result.AskOrders = BuildOrderPlan(graph, result.AskRoute, true)
+	result.BidOrders = BuildOrderPlan(graph, result.BidRoute, false)
+	result.AskImpact = MeasureImpact(result.Orderbook, result.AskFill, scenario.Amount, scenario.Denomination, true)
+	result.BidImpact = MeasureImpact(result.Orderbook, result.BidFill, scenario.Amount, scenario.Denomination, false)
+
+	exactAmount := scenario.ExactAmount
+	if !exactAmount.IsSet() {
+		exactAmount = decimal.FromFloat(scenario.Amount)
+	}
+	result.ExactAskPrice = exactFill(limitLevels(result.Orderbook.AskOrders, true, scenario.AskLimit), exactAmount, scenario.Denomination)
+	result.ExactBidPrice = exactFill(limitLevels(result.Orderbook.BidOrders, false, scenario.BidLimit), exactAmount, scenario.Denomination)
+	// NOTE: the split works in base units, a quote target or a limited fill is split as the base it fills on the virtual book
+	askAmount, bidAmount := scenario.Amount, scenario.Amount
+	if scenario.Denomination == QuoteAmount || result.AskFill.Limited {
+		askAmount = result.AskFill.BaseAmount
+	}
+	if scenario.Denomination == QuoteAmount || result.BidFill.Limited {
+		bidAmount = result.BidFill.BaseAmount
+	}
+	result.AskPlan = splitOrder(graph, scenario.Base, scenario.Quote, askAmount, true, s.stage())
+	result.BidPlan = splitOrder(graph, scenario.Base, scenario.Quote, bidAmount, false, s.stage())
+	for _, truncation := range []Truncation{result.Orderbook.Truncation, result.AskPlan.Truncation, result.BidPlan.Truncation} {
+		result.Truncation.merge(Truncation{
+			DepthLimited:      truncation.DepthLimited,
+			PathsLimited:      truncation.PathsLimited,
+			CandidatesLimited: truncation.CandidatesLimited,
+			TimedOut:          truncation.TimedOut,
+		})
+	}
+
+	return result, errors.Join(
+		checkFilled("ask", result.AskFill, result.AskImpact, scenario),
+		checkFilled("bid", result.BidFill, result.BidImpact, scenario),
+	)
+}
+
+func checkFilled(side string, fill Fill, impact Impact, scenario Scenario) error {
+	if impact.Fillable {
+		return nil
+	}
+	token := scenario.Base
+	if scenario.Denomination == QuoteAmount {
+		token = scenario.Quote
+	}
+	if fill.Limited {
+		return fmt.Errorf("%w: %s side fills %.8f of %.8f %s, %.8f left", ErrLimitReached, side, impact.Filled, impact.Target, token, fill.Unfilled)
+	}
+	return fmt.Errorf("%w: %s side fills %.8f of %.8f %s", ErrInsufficientLiquidity, side, impact.Filled, impact.Target, token)
+}
+
+// partialFill reports whether err still comes with a usable, partially filled result
+func partialFill(err error) bool {
+	return err == nil || errors.Is(err, ErrInsufficientLiquidity) || errors.Is(err, ErrLimitReached)
+}
+
+// parseLimit reads a "limit" line of a test case, several options may share a line:
+//
+//	limit ask|bid price=<worst level price> max-slippage=<bps against the top of book>
+func parseLimit(line string, scenario *Scenario) error {
+	parts := strings.Fields(line)
+	if len(parts) < 3 || parts[0] != "limit" || (parts[1] != "ask" && parts[1] != "bid") {
+		return errors.New("limit should be: limit ask|bid price=<price> max-slippage=<bps>")
+	}
+	limit := &scenario.BidLimit
+	if parts[1] == "ask" {
+		limit = &scenario.AskLimit
+	}
+	for _, option := range parts[2:] {
+		name, value, _ := strings.Cut(option, "=")
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(number > 0) {
+			return fmt.Errorf("invalid limit: %s", option)
+		}
+		switch name {
+		case "price":
+			limit.Price = number
+		case "max-slippage":
+			limit.MaxSlippageBps = number
+		default:
+			return fmt.Errorf("unknown limit: %s", name)
+		}
+	}
+	return limit.Validate()
+}
+
+func splitScenarios(r io.Reader) ([]scenarioInput, error) {
+	scanner := bufio.NewScanner(r)
+	var inputs []scenarioInput
+	var current scenarioInput
+	lineNo := 0
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNo++
+		if strings.HasPrefix(strings.TrimSpace(line), "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// A new test case starts with "BASE QUOTE AMOUNT"
+		if isScenarioHeader(line) && len(current.lines) > 0 {
+			inputs = append(inputs, current)
+			current = scenarioInput{}
+		}
+		current.lines = append(current.lines, line)
+		current.lineNos = append(current.lineNos, lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current.lines) > 0 {
+		inputs = append(inputs, current)
+	}
+	return inputs, nil
+}
+
+// isScenarioHeader matches "BASE QUOTE AMOUNT [base|quote]"
+func isScenarioHeader(line string) bool {
+	parts := strings.Fields(line)
+	if len(parts) != 3 && len(parts) != 4 || parts[0] == "expect" || !ValidSymbol(parts[0]) || !ValidSymbol(parts[1]) {
+		return false
+	}
+	if len(parts) == 4 {
+		if _, err := ParseDenomination(parts[3]); err != nil {
+			return false
+		}
+	}
+	_, err := strconv.ParseFloat(parts[2], 64)
+	return err == nil
+}
+
+// ValidSymbol accepts exchange tickers such as KNC, 1INCH or USDC.e: letters, digits,
+// '.', '-' and '_' with at least one letter
+func ValidSymbol(symbol string) bool {
+	hasLetter := false
+	for _, char := range symbol {
+		switch {
+		case unicode.IsLetter(char):
+			hasLetter = true
+		case unicode.IsDigit(char) || char == '.' || char == '-' || char == '_':
+		default:
+			return false
+		}
+	}
+	return hasLetter
+}
+
+func parseScenario(input scenarioInput) (Scenario, error) {
+	parts := strings.Fields(input.lines[0])
+	if len(parts) < 3 || len(parts) > 4 {
+		return Scenario{}, input.errorAt(0, errors.New("first line should have base quote amount [base|quote]"))
+	}
+	denomination := BaseAmount
+	if len(parts) == 4 {
+		var err error
+		if denomination, err = ParseDenomination(parts[3]); err != nil {
+			return Scenario{}, input.errorAt(0, err)
+		}
+	}
+	amount, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || !(amount > 0) || math.IsInf(amount, 0) {
+		return Scenario{}, input.errorAt(0, fmt.Errorf("invalid amount: %s", parts[2]))
+	}
+	exactAmount, err := decimal.Parse(parts[2])
+	if err != nil {
+		return Scenario{}, input.errorAt(0, fmt.Errorf("invalid amount: %s", parts[2]))
+	}
+	if len(input.lines) < 2 {
+		return Scenario{}, input.errorAt(0, errors.New("missing pair count"))
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(input.lines[1]))
+	if err != nil || n < 0 {
+		return Scenario{}, input.errorAt(1, errors.New("second line should be a pair count"))
+	}
+	lineIdx := 2
+	pairs, err := parsePairs(input, &lineIdx, n)
+	if err != nil {
+		return Scenario{}, err
+	}
+	scenario := Scenario{
+		Base:         parts[0],
+		Quote:        parts[1],
+		Amount:       amount,
+		ExactAmount:  exactAmount,
+		Denomination: denomination,
+		Pairs:        pairs,
+		Line:         input.lineNos[0],
+	}
+	for ; lineIdx < len(input.lines); lineIdx++ {
+		if strings.HasPrefix(input.lines[lineIdx], "limit") {
+			if err := parseLimit(input.lines[lineIdx], &scenario); err != nil {
+				return Scenario{}, input.errorAt(lineIdx, err)
+			}
+			continue
+		}
+		expectation, err := parseExpectation(input.lines[lineIdx])
+		if err != nil {
+			return Scenario{}, input.errorAt(lineIdx, err)
+		}
+		expectation.Line = input.lineNos[lineIdx]
+		scenario.Expect = append(scenario.Expect, expectation)
+	}
+	return scenario, nil
+}
+
+func parsePairs(input scenarioInput, lineIdx *int, n int) ([]TradingPair, error) {
+	var pairs []TradingPair
+	for i := 0; i < n; i++ {
+		if *lineIdx >= len(input.lines) {
+			return nil, input.errorAt(*lineIdx, fmt.Errorf("not enough lines for pair %d", i+1))
+		}
+		pairParts := strings.Fields(input.lines[*lineIdx])
+		if len(pairParts) < 2 {
+			return nil, input.errorAt(*lineIdx, errors.New("invalid pair format"))
+		}
+		pairBase := pairParts[0]
+		pairQuote := pairParts[1]
+		venue, feeBps, rules, err := parsePairOptions(pairParts[2:])
+		if err != nil {
+			return nil, input.errorAt(*lineIdx, fmt.Errorf("invalid pair options: %v", err))
+		}
+		*lineIdx++
+		askOrders, err := parseOrderBook(input, lineIdx, "ask", pairBase, pairQuote)
+		if err != nil {
+			return nil, err
+		}
+		bidOrders, err := parseOrderBook(input, lineIdx, "bid", pairBase, pairQuote)
+		if err != nil {
+			return nil, err
+		}
+		pair := TradingPair{
+			Base:      pairBase,
+			Quote:     pairQuote,
+			AskOrders: askOrders,
+			BidOrders: bidOrders,
+			Venue:     venue,
+			FeeBps:    feeBps,
+			Rules:     rules,
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+func parseOrderBook(input scenarioInput, lineIdx *int, orderType, pairBase, pairQuote string) ([]Level, error) {
+	if *lineIdx >= len(input.lines) {
+		return nil, input.errorAt(*lineIdx, fmt.Errorf("missing %s orders count for pair %s/%s", orderType, pairBase, pairQuote))
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(input.lines[*lineIdx]))
+	if err != nil || count < 0 {
+		return nil, input.errorAt(*lineIdx, fmt.Errorf("invalid %s orders count for pair %s/%s", orderType, pairBase, pairQuote))
+	}
+	*lineIdx++
+	var levels []Level
+	for j := 0; j < count; j++ {
+		if *lineIdx >= len(input.lines) {
+			return nil, input.errorAt(*lineIdx, fmt.Errorf("missing %s order %d for pair %s/%s", orderType, j+1, pairBase, pairQuote))
+		}
+		level, err := parseLevel(input.lines[*lineIdx])
+		if err != nil {
+			return nil, input.errorAt(*lineIdx, fmt.Errorf("%s order %d for pair %s/%s: %w", orderType, j+1, pairBase, pairQuote, err))
+		}
+		levels = append(levels, level)
+		*lineIdx++
+	}
+	return levels, nil
+}
+
+// parseLevel parses "PRICE AMOUNT"
+func parseLevel(line string) (Level, error) {
+	orderParts := strings.Fields(line)
+	if len(orderParts) < 2 {
+		return Level{}, fmt.Errorf("%w: expected PRICE AMOUNT", ErrMalformedLevel)
+	}
+	return NewLevel(orderParts[0], orderParts[1])
+}
+
+// NewLevel parses a level from the price and amount text as published by the exchange,
+// keeping the exact values of the text alongside the floats
+func NewLevel(priceText, amountText string) (Level, error) {
+	price, err := strconv.ParseFloat(priceText, 64)
+	if err != nil {
+		return Level{}, fmt.Errorf("%w: price %q", ErrMalformedLevel, priceText)
+	}
+	amount, err := strconv.ParseFloat(amountText, 64)
+	if err != nil || amount < 0 || math.IsInf(amount, 0) {
+		return Level{}, fmt.Errorf("%w: amount %q", ErrMalformedLevel, amountText)
+	}
+	if !(price > 0) || math.IsInf(price, 0) {
+		return Level{}, fmt.Errorf("%w: %s", ErrInvalidPrice, priceText)
+	}
+	// NOTE: exact values come from the text itself, not from the parsed floats
+	exactPrice, err := decimal.Parse(priceText)
+	if err != nil {
+		return Level{}, fmt.Errorf("%w: price %q", ErrMalformedLevel, priceText)
+	}
+	exactAmount, err := decimal.Parse(amountText)
+	if err != nil {
+		return Level{}, fmt.Errorf("%w: amount %q", ErrMalformedLevel, amountText)
+	}
+	return Level{Price: price, Amount: amount, ExactPrice: exactPrice, ExactAmount: exactAmount}, nil
+}
+
+// LoadPairs reads an orderbook snapshot: the pair count followed by the pair blocks
+// of a test case (without the "BASE QUOTE AMOUNT" header). Comments and blank lines are skipped.
+func LoadPairs(r io.Reader) ([]TradingPair, error) {
+	var input scenarioInput
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineNo++
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		input.lines = append(input.lines, line)
+		input.lineNos = append(input.lineNos, lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(input.lines) == 0 {
+		return nil, fmt.Errorf("empty orderbook snapshot")
+	}
+	n, err := strconv.Atoi(input.lines[0])
+	if err != nil {
+		return nil, input.errorAt(0, errors.New("invalid pair count"))
+	}
+	lineIdx := 1
+	return parsePairs(input, &lineIdx, n)
+}
@@ -0,0 +1,175 @@
+// Package route is the route representation shared by p1 and p2: hops in execution order,
+// each naming the market it trades on and the side it takes there.
+package route
+
+import (
+	"fmt"
+	"math"
+	"orderbook-pathfinder/internal/decimal"
+	"strings"
+)
+
+// Direction is the side of the requested base/quote a route executes
+type Direction int
+
+const (
+	Sell Direction = iota // bid: pays the base, receives the quote
+	Buy                   // ask: pays the quote, receives the base
+)
+
+// DirectionOf maps the isAsk flag of the searches to a Direction
+func DirectionOf(isAsk bool) Direction {
+	if isAsk {
+		return Buy
+	}
+	return Sell
+}
+
+func (d Direction) String() string {
+	if d == Buy {
+		return "buy"
+	}
+	return "sell"
+}
+
+// Hop is one trade of a route: it pays From and receives To on the market Base/Quote,
+// taking Side ("buy" or "sell" of Base) of that market's book. Price is fee-inclusive and
+// oriented toward the requested quote, To per From when the route sells and From per To
+// when it buys, so the hop prices of a route multiply to its base/quote price either way.
+type Hop struct {
+	From       string
+	To         string
+	Base       string
+	Quote      string
+	Side       string
+	Venue      string
+	FeeBps     float64
+	Price      float64
+	ExactPrice decimal.Decimal
+	Size       float64 // available in Base of the hop's market, 0 when unknown
+}
+
+// Route is a path from the token paid to the token received
+type Route struct {
+	Direction Direction
+	Hops      []Hop
+}
+
+// New orients hops found by a search, which always runs base->quote with each hop priced
+// in To per From, into execution order and fills the side each hop takes on its market.
+// Base and Quote of every hop must name the market it comes from.
+func New(direction Direction, searchHops []Hop) Route {
+	hops := make([]Hop, len(searchHops))
+	for i, hop := range searchHops {
+		if direction == Buy {
+			hop.From, hop.To = hop.To, hop.From
+			hops[len(hops)-1-i] = hop
+		} else {
+			hops[i] = hop
+		}
+	}
+	for i := range hops {
+		hops[i].Side = "buy"
+		if hops[i].From == hops[i].Base {
+			hops[i].Side = "sell"
+		}
+	}
+	return Route{Direction: direction, Hops: hops}
+}
+
+// Tokens lists the tokens of the route from the one paid to the one received
+func (r Route) Tokens() []string {
+	if len(r.Hops) == 0 {
+		return nil
+	}
+	tokens := []string{r.Hops[0].From}
+	for _, hop := range r.Hops {
+		tokens = append(tokens, hop.To)
+	}
+	return tokens
+}
+
+// Price is the product of the hop prices, base/quote on both directions
+func (r Route) Price() float64 {
+	price := 1.0
+	for _, hop := range r.Hops {
+		price *= hop.Price
+	}
+	return price
+}
+
+// ExactPrice is Price with the exact hop prices, unset when a hop has none
+func (r Route) ExactPrice() decimal.Decimal {
+	price := decimal.New(1)
+	for _, hop := range r.Hops {
+		if !hop.ExactPrice.IsSet() {
+			return decimal.Decimal{}
+		}
+		price = price.Mul(hop.ExactPrice)
+	}
+	return price
+}
+
+// Capacity is the largest amount of the requested base the route can execute within the Size
+// of every hop, converted hop by hop through the hop prices. +Inf when no hop has a Size.
+func (r Route) Capacity() float64 {
+	capacity := math.Inf(1)
+	// NOTE: walked in search order, base->quote, where flow is the From amount per unit of base
+	flow := 1.0
+	for i := range r.Hops {
+		hop, from := r.Hops[i], r.Hops[i].From
+		if r.Direction == Buy {
+			hop = r.Hops[len(r.Hops)-1-i]
+			from = hop.To
+		}
+		baseAmount := flow
+		if hop.Base != from {
+			baseAmount = flow * hop.Price
+		}
+		if hop.Size > 0 {
+			capacity = math.Min(capacity, hop.Size/baseAmount)
+		}
+		flow *= hop.Price
+	}
+	return capacity
+}
+
+func (r Route) String() string {
+	return strings.Join(r.Tokens(), "->")
+}
+
+// Check verifies the orientation invariants of a route reported for base/quote at price:
+// hops chain from the token paid to the token received, each hop's side matches the market
+// it trades on, and the hop prices multiply to price.
+func (r Route) Check(base, quote string, price float64) error {
+	if len(r.Hops) == 0 {
+		return fmt.Errorf("route has no hops")
+	}
+	from, to := base, quote
+	if r.Direction == Buy {
+		from, to = quote, base
+	}
+	if tokens := r.Tokens(); tokens[0] != from || tokens[len(tokens)-1] != to {
+		return fmt.Errorf("%s route %s should go %s->%s", r.Direction, r, from, to)
+	}
+	for i, hop := range r.Hops {
+		if i > 0 && r.Hops[i-1].To != hop.From {
+			return fmt.Errorf("hop %d starts at %s, hop %d ends at %s", i+1, hop.From, i, r.Hops[i-1].To)
+		}
+		var side string
+		switch {
+		case hop.From == hop.Base && hop.To == hop.Quote:
+			side = "sell"
+		case hop.From == hop.Quote && hop.To == hop.Base:
+			side = "buy"
+		}
+		if side == "" || side != hop.Side {
+			return fmt.Errorf("hop %d %s->%s takes %q on %s/%s", i+1, hop.From, hop.To, hop.Side, hop.Base, hop.Quote)
+		}
+	}
+	// NOTE: relative tolerance, the reported price may be summed in another order
+	if product := r.Price(); math.Abs(product-price) > 1e-9*math.Abs(price) {
+		return fmt.Errorf("hop prices of %s multiply to %.12g, reported %.12g", r, product, price)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package routetest builds random markets and checks the routes found on them, for the
+// randomized tests of p1 and p2.
+package routetest
+
+import (
+	"math"
+	"math/rand"
+	"orderbook-pathfinder/internal/route"
+	"strconv"
+	"testing"
+)
+
+// Market is a market of token Base against token Quote on Venue, priced around Mid
+type Market struct {
+	Base, Quote int
+	Venue       string
+	Mid         float64 // Quote per Base
+	FeeBps      float64
+}
+
+// Token names token i as the markets quote it
+func Token(i int) string {
+	return "T" + strconv.Itoa(i)
+}
+
+// RandomMarkets quotes tokens against each other around one value per token, on one or two
+// venues with random fees and with a random token as the market's base. The mids agree, so
+// any book with its asks above its bids around them has no arbitrage.
+func RandomMarkets(tokens int, r *rand.Rand) []Market {
+	values := make([]float64, tokens)
+	for i := range values {
+		values[i] = math.Exp(4 * (r.Float64() - 0.5))
+	}
+	var markets []Market
+	for a := 0; a < tokens; a++ {
+		for b := a + 1; b < tokens; b++ {
+			if r.Float64() < 0.4 {
+				continue
+			}
+			base, quote := a, b
+			if r.Intn(2) == 0 {
+				base, quote = b, a
+			}
+			for venue := 0; venue <= r.Intn(2); venue++ {
+				markets = append(markets, Market{
+					Base:   base,
+					Quote:  quote,
+					Venue:  "v" + strconv.Itoa(venue),
+					Mid:    values[base] / values[quote],
+					FeeBps: float64(r.Intn(3)) * 10,
+				})
+			}
+		}
+	}
+	return markets
+}
+
+// Book looks up the market a hop names and returns the prices it quotes on the hop's side,
+// asks for a buy and bids for a sell, before its fee
+type Book func(hop route.Hop) (prices []float64, feeBps float64, found bool)
+
+// CheckPrices checks path with route.Route.Check and that every hop carries a price its market
+// quotes: what the hop receives per unit paid after the fee, inverted on a buy route whose hop
+// prices are paid per unit received
+func CheckPrices(t testing.TB, path route.Route, base, quote string, price float64, book Book) {
+	t.Helper()
+	if err := path.Check(base, quote, price); err != nil {
+		t.Fatal(err)
+	}
+	for _, hop := range path.Hops {
+		prices, feeBps, found := book(hop)
+		if !found {
+			t.Fatalf("hop %s->%s names no market %s/%s on %s", hop.From, hop.To, hop.Base, hop.Quote, hop.Venue)
+		}
+		matched := false
+		for _, market := range prices {
+			received := 1 / (market * (1 + feeBps/10000))
+			if hop.Side == "sell" {
+				received = market * (1 - feeBps/10000)
+			}
+			if path.Direction == route.Buy {
+				received = 1 / received
+			}
+			if math.Abs(received-hop.Price) <= 1e-12*received {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			t.Fatalf("%s hop %s->%s at %.12g matches no %s price of %s/%s on %s",
+				path.Direction, hop.From, hop.To, hop.Price, hop.Side, hop.Base, hop.Quote, hop.Venue)
+		}
+	}
+}